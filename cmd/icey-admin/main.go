@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/go-redis/redis/v8"
+	"meea-icey/models"
+	"meea-icey/services"
+)
+
+// 子命令表
+var commands = map[string]func(args []string) error{
+	"migrate-votes":     migrateVotes,
+	"rebuild-manifests": rebuildManifests,
+	"push-menu":         pushMenu,
+	"totp-register":     totpRegister,
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "用法: icey-admin <命令> [参数]")
+	fmt.Fprintln(os.Stderr, "命令:")
+	fmt.Fprintln(os.Stderr, "  migrate-votes      将旧版 .bm/.bmi 投票文件转换为 .vt 格式并提交")
+	fmt.Fprintln(os.Stderr, "  rebuild-manifests  根据记录文件重新生成主题清单和汇总索引并提交")
+	fmt.Fprintln(os.Stderr, "  push-menu          将自定义菜单定义推送到微信公众号")
+	fmt.Fprintln(os.Stderr, "  totp-register      为高级用户登记或注销TOTP密钥")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "migrate-votes 和 rebuild-manifests 直接改写克隆目录中的文件，执行前需停止使用该目录的服务；")
+	fmt.Fprintln(os.Stderr, "多实例部署时需停止所有实例，避免与其他实例的写入冲突")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd(os.Args[2:]); err != nil {
+		log.Fatalf("%s 执行失败: %v", os.Args[1], err)
+	}
+}
+
+// loadConfig 优先加载本地开发配置，与服务端保持一致
+func loadConfig(path string) (*models.Config, error) {
+	if path == "" {
+		path = "config.yaml"
+		if _, err := os.Stat("config.local.yaml"); err == nil {
+			path = "config.local.yaml"
+		}
+	}
+	return models.LoadConfig(path)
+}
+
+// claimClone 独占克隆目录，服务仍在使用该目录时拒绝执行
+func claimClone(config *models.Config) (func(), error) {
+	release, err := services.ClaimClone(config.Repository.ClonePath)
+	if errors.Is(err, services.ErrCloneInUse) {
+		return nil, fmt.Errorf("%v，请先停止使用该目录的服务再执行", err)
+	}
+	return release, err
+}
+
+// migrateVotes 迁移仓库中的旧版bitmap投票文件；不持有服务端的投票文件锁，需在服务停止后执行
+func migrateVotes(args []string) error {
+	fs := flag.NewFlagSet("migrate-votes", flag.ExitOnError)
+	configPath := fs.String("config", "", "配置文件路径")
+	dryRun := fs.Bool("dry-run", false, "只列出需要迁移的文件，不写盘")
+	noCommit := fs.Bool("no-commit", false, "迁移后不提交到远程仓库")
+	fs.Parse(args)
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	release, err := claimClone(config)
+	if err != nil {
+		return err
+	}
+	defer release()
+	gitService, err := services.NewGitService(config.Repository.ClonePath, config.Repository.URL, config.Repository.SSHKey)
+	if err != nil {
+		return err
+	}
+	if err := gitService.PullRepository(); err != nil {
+		return fmt.Errorf("拉取仓库失败: %v", err)
+	}
+
+	voteStore := services.NewVoteStore(gitService, config.Votes.Window)
+	repoRoot := filepath.Join(config.Repository.ClonePath, "icey-storage")
+	changed, err := voteStore.MigrateLegacy(repoRoot, *dryRun)
+	if err != nil {
+		return err
+	}
+	for _, f := range changed {
+		log.Printf("迁移: %s", f)
+	}
+	log.Printf("共涉及 %d 个文件", len(changed))
+
+	if *dryRun || *noCommit || len(changed) == 0 {
+		return nil
+	}
+	return gitService.CommitChanges("icey-storage", changed, "migrate vote bitmaps to vt format")
+}
+
+// rebuildManifests 重新生成主题清单和汇总索引；不持有服务端的清单锁，需在服务停止后执行
+func rebuildManifests(args []string) error {
+	fs := flag.NewFlagSet("rebuild-manifests", flag.ExitOnError)
+	configPath := fs.String("config", "", "配置文件路径")
+	subject := fs.String("subject", "", "只重建指定主题(SHA256)，为空时重建全部")
+	noCommit := fs.Bool("no-commit", false, "重建后不提交到远程仓库")
+	fs.Parse(args)
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	release, err := claimClone(config)
+	if err != nil {
+		return err
+	}
+	defer release()
+	gitService, err := services.NewGitService(config.Repository.ClonePath, config.Repository.URL, config.Repository.SSHKey)
+	if err != nil {
+		return err
+	}
+	if err := gitService.PullRepository(); err != nil {
+		return fmt.Errorf("拉取仓库失败: %v", err)
+	}
+
+	voteStore := services.NewVoteStore(gitService, config.Votes.Window)
+	index := services.NewSubjectIndex(voteStore, services.NewSummaryService(voteStore))
+	var changed []string
+	if *subject != "" {
+		dirPath, relativePath := services.BuildSubjectPath(config.Repository.ClonePath, *subject)
+		if dirPath == "" {
+			return fmt.Errorf("无效的subject格式")
+		}
+		if err := index.Rebuild(dirPath); err != nil {
+			return err
+		}
+		changed = []string{
+			filepath.Join(relativePath, services.ManifestFileName),
+			filepath.Join(relativePath, services.SummaryFileName),
+		}
+	} else {
+		repoRoot := filepath.Join(config.Repository.ClonePath, "icey-storage")
+		if changed, err = index.RebuildAll(repoRoot); err != nil {
+			return err
+		}
+	}
+	log.Printf("已重建 %d 个主题的索引", len(changed)/2)
+
+	if *noCommit || len(changed) == 0 {
+		return nil
+	}
+	return gitService.CommitChanges("icey-storage", changed, "rebuild subject manifests")
+}
+
+// pushMenu 推送公众号自定义菜单
+func pushMenu(args []string) error {
+	fs := flag.NewFlagSet("push-menu", flag.ExitOnError)
+	configPath := fs.String("config", "", "配置文件路径")
+	menuPath := fs.String("file", "scripts/wechat_menu.json", "菜单定义文件")
+	fs.Parse(args)
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	menu, err := os.ReadFile(*menuPath)
+	if err != nil {
+		return fmt.Errorf("读取菜单定义失败: %v", err)
+	}
+	if !json.Valid(menu) {
+		return fmt.Errorf("菜单定义不是有效的JSON: %s", *menuPath)
+	}
+
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", config.Redis.IP, config.Redis.Port),
+		Password: config.Redis.Password,
+	})
+	defer redisClient.Close()
+
+	wechatService := services.NewWechatService(config, redisClient, context.Background())
+	if err := wechatService.CreateMenu(menu); err != nil {
+		return err
+	}
+	log.Printf("已推送菜单: %s", *menuPath)
+	return nil
+}
+
+// totpRegister 登记TOTP用户，输出供验证器App扫码的otpauth URI
+func totpRegister(args []string) error {
+	fs := flag.NewFlagSet("totp-register", flag.ExitOnError)
+	configPath := fs.String("config", "", "配置文件路径")
+	user := fs.String("user", "", "用户名")
+	remove := fs.Bool("remove", false, "注销该用户的TOTP密钥")
+	fs.Parse(args)
+	if *user == "" {
+		return fmt.Errorf("必须指定 -user")
+	}
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", config.Redis.IP, config.Redis.Port),
+		Password: config.Redis.Password,
+	})
+	defer redisClient.Close()
+
+	ctx := context.Background()
+	issuer := services.NewTOTPIssuer(config, services.NewVerifyService(redisClient, config), redisClient)
+	if *remove {
+		if err := issuer.Remove(ctx, *user); err != nil {
+			return fmt.Errorf("注销TOTP用户失败: %v", err)
+		}
+		log.Printf("已注销TOTP用户: %s", *user)
+		return nil
+	}
+	uri, err := issuer.Register(ctx, *user)
+	if err != nil {
+		return err
+	}
+	fmt.Println(uri)
+	return nil
+}
@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"meea-icey/services"
+)
+
+// CodeController 微信以外的验证码获取渠道
+type CodeController struct {
+	issuers map[string]services.CodeIssuer
+}
+
+// NewCodeController 创建CodeController，只传入已启用的渠道
+func NewCodeController(issuers ...services.CodeIssuer) *CodeController {
+	c := &CodeController{issuers: make(map[string]services.CodeIssuer)}
+	for _, issuer := range issuers {
+		if issuer != nil {
+			c.issuers[issuer.Channel()] = issuer
+		}
+	}
+	return c
+}
+
+type EmailCodeRequest struct {
+	Subject string `json:"subject" binding:"required"`
+	Email   string `json:"email" binding:"required"`
+}
+
+type PartnerCodeRequest struct {
+	Subject string `json:"subject" binding:"required"`
+	// User 合作方系统内的用户标识
+	User string `json:"user" binding:"required"`
+}
+
+type TOTPCodeRequest struct {
+	Subject string `json:"subject" binding:"required"`
+	User    string `json:"user" binding:"required"`
+	TOTP    string `json:"totp" binding:"required"`
+}
+
+// HandleEmail 发送验证码到邮箱，响应中不包含验证码
+func (c *CodeController) HandleEmail(ctx *gin.Context) {
+	var req EmailCodeRequest
+	if !bindCodeRequest(ctx, &req) {
+		return
+	}
+	c.issue(ctx, services.ChannelEmail, &services.CodeRequest{Subject: req.Subject, Recipient: req.Email, ClientIP: ctx.ClientIP()})
+}
+
+// HandlePartner 合作方以请求头X-API-Key认证后为其用户获取验证码
+func (c *CodeController) HandlePartner(ctx *gin.Context) {
+	var req PartnerCodeRequest
+	if !bindCodeRequest(ctx, &req) {
+		return
+	}
+	c.issue(ctx, services.ChannelPartner, &services.CodeRequest{
+		Subject:    req.Subject,
+		Recipient:  req.User,
+		Credential: ctx.GetHeader("X-API-Key"),
+		ClientIP:   ctx.ClientIP(),
+	})
+}
+
+// HandleTOTP 已登记用户以TOTP动态码换取验证码
+func (c *CodeController) HandleTOTP(ctx *gin.Context) {
+	var req TOTPCodeRequest
+	if !bindCodeRequest(ctx, &req) {
+		return
+	}
+	c.issue(ctx, services.ChannelTOTP, &services.CodeRequest{Subject: req.Subject, Recipient: req.User, Credential: req.TOTP, ClientIP: ctx.ClientIP()})
+}
+
+func bindCodeRequest(ctx *gin.Context, req interface{}) bool {
+	if err := ctx.ShouldBindJSON(req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"msg":     "无效的请求参数: " + err.Error(),
+			"data":    nil,
+		})
+		return false
+	}
+	return true
+}
+
+func (c *CodeController) issue(ctx *gin.Context, channel string, req *services.CodeRequest) {
+	issuer, ok := c.issuers[channel]
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"msg":     "该验证渠道未启用",
+			"data":    nil,
+		})
+		return
+	}
+
+	issued, err := issuer.Issue(withSubject(ctx, services.SubjectHash(req.Subject)), req)
+	if errors.Is(err, services.ErrIssueDenied) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"msg":     err.Error(),
+			"data":    nil,
+		})
+		return
+	}
+	if err != nil {
+		slog.WarnContext(ctx.Request.Context(), "签发验证码失败", "channel", channel, "error", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"msg":     err.Error(),
+			"data":    nil,
+		})
+		return
+	}
+
+	data := gin.H{"expires_in": int(issued.ExpiresIn.Seconds())}
+	if !issued.Delivered {
+		data["code"] = issued.Code
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"msg":     "",
+		"data":    data,
+	})
+}
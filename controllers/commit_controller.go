@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
@@ -30,9 +31,10 @@ func NewCommitController(config *models.Config, verifyService *services.VerifySe
 
 // CommitRequest 提交信息请求参数
 type CommitRequest struct {
-	Subject string `json:"subject" binding:"required"`
-	Content string `json:"content" binding:"required"`
-	Code    string `json:"code" binding:"required"`
+	Subject  string `json:"subject" binding:"required"`
+	Content  string `json:"content" binding:"required"`
+	Category string `json:"category" binding:"max=32"` // 可选分类，用于主题汇总
+	Code     string `json:"code" binding:"required"`
 }
 
 // HandleCommit 处理提交信息请求
@@ -41,8 +43,8 @@ func (c *CommitController) HandleCommit(ctx *gin.Context) {
 	if err := ctx.ShouldBindBodyWith(&req, binding.JSON); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"msg":    "无效的请求参数: " + err.Error(),
-			"data":   nil,
+			"msg":     "无效的请求参数: " + err.Error(),
+			"data":    nil,
 		})
 		return
 	}
@@ -51,27 +53,27 @@ func (c *CommitController) HandleCommit(ctx *gin.Context) {
 	if len(req.Subject) < 6 {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"msg":    "subject必须至少包含6个字符",
-			"data":   nil,
+			"msg":     "subject必须至少包含6个字符",
+			"data":    nil,
 		})
 		return
 	}
 
 	// 调用服务层处理提交逻辑
-	result, err := c.commitService.ProcessCommit(req.Subject, req.Content, req.Code)
+	result, err := c.commitService.ProcessCommit(withSubject(ctx, req.Subject), req.Subject, req.Content, strings.TrimSpace(req.Category), req.Code)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
-			"msg":    err.Error(),
-			"data":   nil,
+			"msg":     err.Error(),
+			"data":    nil,
 		})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"msg":    "",
-		"data":   gin.H{
+		"msg":     "",
+		"data": gin.H{
 			"token": result,
 		},
 	})
@@ -39,7 +39,7 @@ func (c *DeleteController) HandleDelete(ctx *gin.Context) {
 		return
 	}
 
-	err := c.deleteService.ProcessDelete(req.Subject, req.Code, req.Token)
+	err := c.deleteService.ProcessDelete(withSubject(ctx, req.Subject), req.Subject, req.Code, req.Token)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"meea-icey/models"
+	"meea-icey/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webhook请求体上限
+const maxHookBodySize = 5 << 20
+
+// GitHookController 接收仓库推送通知，立即触发同步并使变更主题的查询缓存失效
+type GitHookController struct {
+	secret string
+	syncer *services.RepoSyncer
+	cache  *services.QueryCache
+}
+
+func NewGitHookController(config *models.Config, syncer *services.RepoSyncer, cache *services.QueryCache) *GitHookController {
+	return &GitHookController{
+		secret: config.Repository.WebhookSecret,
+		syncer: syncer,
+		cache:  cache,
+	}
+}
+
+// GitHub推送通知最多列出的提交数，推送通知中没有提交总数
+const githubCommitLimit = 20
+
+// pushPayload GitHub/Gitea/GitLab推送事件中用到的字段，提交明细格式一致，提交总数字段各不相同
+type pushPayload struct {
+	Ref               string `json:"ref"`
+	TotalCommitsCount int    `json:"total_commits_count"` // GitLab
+	TotalCommits      int    `json:"total_commits"`       // Gitea
+	Commits           []struct {
+		Added    []string `json:"added"`
+		Modified []string `json:"modified"`
+		Removed  []string `json:"removed"`
+	} `json:"commits"`
+}
+
+func (c *GitHookController) HandleHook(ctx *gin.Context) {
+	if c.secret == "" {
+		ctx.JSON(http.StatusForbidden, gin.H{"success": false, "msg": "未配置webhook密钥"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(ctx.Request.Body, maxHookBodySize))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "读取请求体失败"})
+		return
+	}
+
+	provider, event, ok := c.verify(ctx.Request.Header, body)
+	if !ok {
+		slog.WarnContext(ctx.Request.Context(), "推送通知签名验证失败", "provider", provider)
+		ctx.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "签名验证失败"})
+		return
+	}
+
+	// 只处理推送事件，其余事件(如ping)直接确认
+	if event != "push" && event != "Push Hook" {
+		ctx.JSON(http.StatusOK, gin.H{"success": true, "msg": "忽略事件: " + event})
+		return
+	}
+
+	var payload pushPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "无效的推送内容: " + err.Error()})
+		return
+	}
+	if !strings.HasPrefix(payload.Ref, "refs/heads/") {
+		ctx.JSON(http.StatusOK, gin.H{"success": true, "msg": "忽略非分支推送"})
+		return
+	}
+
+	var paths []string
+	for _, commit := range payload.Commits {
+		paths = append(paths, commit.Added...)
+		paths = append(paths, commit.Modified...)
+		paths = append(paths, commit.Removed...)
+	}
+	// 推送通知只携带部分提交明细时无法确定全部变更文件，清空缓存
+	if payload.truncated(provider) {
+		c.cache.Purge()
+	} else {
+		c.cache.InvalidatePaths(paths)
+	}
+	c.syncer.Trigger()
+
+	slog.InfoContext(ctx.Request.Context(), "收到推送通知", "provider", provider, "ref", payload.Ref, "commits", len(payload.Commits), "paths", len(paths))
+	ctx.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// truncated 判断提交明细是否可能不完整
+func (p *pushPayload) truncated(provider string) bool {
+	switch provider {
+	case "github":
+		// GitHub最多列出20个提交，未达到上限时明细完整
+		return len(p.Commits) >= githubCommitLimit
+	case "gitea":
+		return p.TotalCommits > len(p.Commits)
+	default:
+		return p.TotalCommitsCount > len(p.Commits)
+	}
+}
+
+// verify 根据请求头识别来源并验证签名，返回来源和事件类型
+func (c *GitHookController) verify(header http.Header, body []byte) (string, string, bool) {
+	switch {
+	case header.Get("X-Gitea-Event") != "":
+		return "gitea", header.Get("X-Gitea-Event"), c.verifyHMAC(header.Get("X-Gitea-Signature"), body)
+	case header.Get("X-GitHub-Event") != "":
+		sig := header.Get("X-Hub-Signature-256")
+		if !strings.HasPrefix(sig, "sha256=") {
+			return "github", "", false
+		}
+		return "github", header.Get("X-GitHub-Event"), c.verifyHMAC(strings.TrimPrefix(sig, "sha256="), body)
+	case header.Get("X-Gitlab-Event") != "":
+		// GitLab不签名请求体，只回传配置的密钥
+		token := header.Get("X-Gitlab-Token")
+		ok := subtle.ConstantTimeCompare([]byte(token), []byte(c.secret)) == 1
+		return "gitlab", header.Get("X-Gitlab-Event"), ok
+	}
+	return "unknown", "", false
+}
+
+func (c *GitHookController) verifyHMAC(signature string, body []byte) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(c.secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
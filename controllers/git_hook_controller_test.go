@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"meea-icey/models"
+	"meea-icey/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	hookSecret   = "hook-secret"
+	hookSubjectA = "aabbccdd0001"
+	hookSubjectB = "aabbccdd0002"
+)
+
+type hookFixture struct {
+	router http.Handler
+	cache  *services.QueryCache
+}
+
+func newHookFixture() *hookFixture {
+	gin.SetMode(gin.TestMode)
+	config := &models.Config{}
+	config.Repository.WebhookSecret = hookSecret
+	config.QueryCache.Enabled = true
+	config.QueryCache.TTL = time.Minute
+	cache := services.NewQueryCache(config)
+	router := gin.New()
+	router.POST("/hooks/git", NewGitHookController(config, services.NewRepoSyncer(config, nil), cache).HandleHook)
+	return &hookFixture{router: router, cache: cache}
+}
+
+// fill 为两个主题写入缓存
+func (f *hookFixture) fill() {
+	for _, subject := range []string{hookSubjectA, hookSubjectB} {
+		f.cache.Put(subject, "wilson|", f.cache.Generation(subject), subject)
+	}
+}
+
+func (f *hookFixture) cached(subject string) bool {
+	_, ok := f.cache.Get(subject, "wilson|")
+	return ok
+}
+
+func (f *hookFixture) send(header map[string]string, body string) int {
+	req := httptest.NewRequest(http.MethodPost, "/hooks/git", bytes.NewBufferString(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func sign(body string) string {
+	mac := hmac.New(sha256.New, []byte(hookSecret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+const hookCommit = `{"id":"3f786850e387550fdab836ed7e6dc881de23001b","message":"vote","timestamp":"2024-01-01T00:00:00Z",` +
+	`"added":[],"removed":[],"modified":["aa/bb/cc/aabbccdd0001/1700000000000-1.vt"]}`
+
+// hookBody GitHub推送通知的格式(不含提交总数)，Gitea/GitLab的提交明细字段与之一致
+const hookBody = `{"ref":"refs/heads/main","before":"0000000000000000000000000000000000000000",` +
+	`"after":"3f786850e387550fdab836ed7e6dc881de23001b","created":false,"deleted":false,"forced":false,` +
+	`"commits":[` + hookCommit + `],"head_commit":` + hookCommit + `}`
+
+func TestHookSignatures(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		header map[string]string
+		want   int
+	}{
+		{"github有效", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + sign(hookBody)}, http.StatusOK},
+		{"github无效", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + sign("other")}, http.StatusUnauthorized},
+		{"github缺少前缀", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign(hookBody)}, http.StatusUnauthorized},
+		{"github缺少签名", map[string]string{"X-GitHub-Event": "push"}, http.StatusUnauthorized},
+		{"gitea有效", map[string]string{"X-Gitea-Event": "push", "X-Gitea-Signature": sign(hookBody)}, http.StatusOK},
+		{"gitea无效", map[string]string{"X-Gitea-Event": "push", "X-Gitea-Signature": "zz" + sign(hookBody)[2:]}, http.StatusUnauthorized},
+		{"gitea缺少签名", map[string]string{"X-Gitea-Event": "push"}, http.StatusUnauthorized},
+		{"gitlab有效", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": hookSecret}, http.StatusOK},
+		{"gitlab无效", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "wrong"}, http.StatusUnauthorized},
+		{"gitlab缺少令牌", map[string]string{"X-Gitlab-Event": "Push Hook"}, http.StatusUnauthorized},
+		{"未知来源", map[string]string{}, http.StatusUnauthorized},
+	} {
+		f := newHookFixture()
+		f.fill()
+		if got := f.send(c.header, hookBody); got != c.want {
+			t.Errorf("%s: 状态码%d, 预期%d", c.name, got, c.want)
+		}
+		// 只有验证通过的通知才使缓存失效
+		if invalidated := !f.cached(hookSubjectA); invalidated != (c.want == http.StatusOK) {
+			t.Errorf("%s: 缓存失效状态不符", c.name)
+		}
+		if !f.cached(hookSubjectB) {
+			t.Errorf("%s: 未变更的主题不应失效", c.name)
+		}
+	}
+}
+
+func TestHookPurgesTruncatedPush(t *testing.T) {
+	commits := func(n int) string {
+		out := hookCommit
+		for i := 1; i < n; i++ {
+			out += "," + hookCommit
+		}
+		return out
+	}
+	github := func(n int) string {
+		return `{"ref":"refs/heads/main","before":"0000000000000000000000000000000000000000",` +
+			`"after":"3f786850e387550fdab836ed7e6dc881de23001b","commits":[` + commits(n) + `],"head_commit":` + hookCommit + `}`
+	}
+	for _, c := range []struct {
+		name  string
+		body  string
+		purge bool
+	}{
+		{"单个提交", github(1), false},
+		{"未达上限", github(19), false},
+		{"达到上限", github(20), true},
+	} {
+		f := newHookFixture()
+		f.fill()
+		header := map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + sign(c.body)}
+		if got := f.send(header, c.body); got != http.StatusOK {
+			t.Fatalf("%s: 状态码%d", c.name, got)
+		}
+		if purged := !f.cached(hookSubjectB); purged != c.purge {
+			t.Errorf("%s: 清空缓存=%v, 预期%v", c.name, purged, c.purge)
+		}
+	}
+
+	// GitLab/Gitea按提交总数判断
+	f := newHookFixture()
+	f.fill()
+	body := `{"ref":"refs/heads/main","total_commits_count":5,"commits":[` + commits(3) + `]}`
+	f.send(map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": hookSecret}, body)
+	if f.cached(hookSubjectB) {
+		t.Error("GitLab提交明细不完整时应清空缓存")
+	}
+	f = newHookFixture()
+	f.fill()
+	body = `{"ref":"refs/heads/main","total_commits":3,"commits":[` + commits(3) + `]}`
+	f.send(map[string]string{"X-Gitea-Event": "push", "X-Gitea-Signature": sign(body)}, body)
+	if !f.cached(hookSubjectB) {
+		t.Error("Gitea提交明细完整时只应使变更主题失效")
+	}
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"meea-icey/services"
+)
+
+// HealthController 存活和就绪检查接口
+type HealthController struct {
+	health *services.HealthService
+}
+
+// NewHealthController 创建HealthController实例
+func NewHealthController(health *services.HealthService) *HealthController {
+	return &HealthController{health: health}
+}
+
+// HandleLiveness 存活检查：进程能处理请求即返回200，不检查外部依赖，避免依赖故障时被反复重启
+func (c *HealthController) HandleLiveness(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": services.HealthOK,
+		"uptime": c.health.Uptime().Round(time.Second).String(),
+	})
+}
+
+// HandleReadiness 就绪检查：任一检查失败或超时时返回503，响应中包含每项检查的详情
+func (c *HealthController) HandleReadiness(ctx *gin.Context) {
+	report := c.health.Check(ctx.Request.Context())
+	status := http.StatusOK
+	if !report.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+	ctx.JSON(status, report)
+}
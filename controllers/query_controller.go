@@ -1,16 +1,19 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
-	"strings"
+	"strconv"
+
+	"meea-icey/internal/logging"
 	"meea-icey/services"
 )
 
@@ -19,24 +22,45 @@ var sha256Regex = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
 type QueryController struct {
 	verifyService *services.VerifyService
 	gitService    *services.GitService
+	syncer        *services.RepoSyncer
+	voteStore     *services.VoteStore
+	scorer        *services.Scorer
+	index         *services.SubjectIndex
+	cache         *services.QueryCache
+	clock         services.Clock
 }
 
-func NewQueryController(verifyService *services.VerifyService, gitService *services.GitService) *QueryController {
+func NewQueryController(verifyService *services.VerifyService, gitService *services.GitService, syncer *services.RepoSyncer, voteStore *services.VoteStore, scorer *services.Scorer, index *services.SubjectIndex, cache *services.QueryCache, clock services.Clock) *QueryController {
 	return &QueryController{
 		verifyService: verifyService,
 		gitService:    gitService,
+		syncer:        syncer,
+		voteStore:     voteStore,
+		scorer:        scorer,
+		index:         index,
+		cache:         cache,
+		clock:         clock.OrSystem(),
 	}
 }
 
+// cachedQuery 缓存的渲染后查询结果
+type cachedQuery struct {
+	data    []map[string]interface{}
+	summary *services.SummaryView
+}
+
 type QueryRequest struct {
 	Subject string `json:"subject"`
 	Code    string `json:"code"`
+	Score   string `json:"score"` // 评分方法，为空时使用配置的默认方法
+	Sort    string `json:"sort"`  // 排序方式: time(默认) / score
 }
 
 type QueryResponse[T any] struct {
-	Success bool   `json:"success"`
-	Msg     string `json:"msg,omitempty"`
-	Data    T      `json:"data,omitempty"`
+	Success bool                  `json:"success"`
+	Msg     string                `json:"msg,omitempty"`
+	Data    T                     `json:"data,omitempty"`
+	Summary *services.SummaryView `json:"summary,omitempty"`
 }
 
 func (c *QueryController) HandleQuery(w http.ResponseWriter, r *http.Request) {
@@ -49,11 +73,11 @@ func (c *QueryController) HandleQuery(w http.ResponseWriter, r *http.Request) {
 
 	var req QueryRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("[QueryController] 解析请求体错误: %%v, 请求: %%+v", err, r.Body)
+		slog.InfoContext(r.Context(), "解析查询请求失败", "error", err)
 		resp := QueryResponse[string]{Success: false}
 		var syntaxErr *json.SyntaxError
 		var typeErr *json.UnmarshalTypeError
-		
+
 		if errors.As(err, &syntaxErr) {
 			resp.Msg = fmt.Sprintf("JSON语法错误在位置 %d: %v", syntaxErr.Offset, err)
 		} else if errors.As(err, &typeErr) {
@@ -83,165 +107,183 @@ func (c *QueryController) HandleQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !services.ValidScoreMethod(req.Score) {
+		resp := QueryResponse[string]{Success: false, Msg: "不支持的评分方法: " + req.Score}
+		writeJSONResponse(w, http.StatusBadRequest, resp)
+		return
+	}
+	if req.Sort != "" && req.Sort != "time" && req.Sort != "score" {
+		resp := QueryResponse[string]{Success: false, Msg: "sort只能为time或score"}
+		writeJSONResponse(w, http.StatusBadRequest, resp)
+		return
+	}
+
+	ctx := logging.WithSubject(r.Context(), req.Subject)
+
 	// 调用验证服务进行验证码验证
-	valid, err := c.verifyService.VerifyCode(req.Subject, req.Code)
+	valid, err := c.verifyService.VerifyCode(ctx, req.Subject, req.Code)
 	if err != nil {
-		log.Printf("[QueryController] 验证码验证过程错误: %%v, subject=%%s, code=%%s", err, req.Subject, req.Code)
+		slog.ErrorContext(ctx, "验证码验证过程错误", "error", err)
 		resp := QueryResponse[string]{Success: false, Msg: "验证过程失败: " + err.Error()}
 		writeJSONResponse(w, http.StatusInternalServerError, resp)
 		return
 	}
 
 	if !valid {
-		log.Printf("[QueryController] 验证码无效: subject=%%s, code=%%s", req.Subject, req.Code)
+		slog.InfoContext(ctx, "验证码无效")
 		resp := QueryResponse[string]{Success: false, Msg: "验证码错误"}
 		writeJSONResponse(w, http.StatusForbidden, resp)
 		return
 	}
 
-	// 验证码验证通过，执行查询逻辑
-	result, err := c.executeQuery(req.Subject)
+	// 验证码验证通过，优先使用缓存的查询结果
+	variant := c.scorer.Resolve(req.Score) + "|" + req.Sort
+	if v, ok := c.cache.Get(req.Subject, variant); ok {
+		cached := v.(*cachedQuery)
+		resp := QueryResponse[[]map[string]interface{}]{Success: true, Data: cached.data, Summary: cached.summary}
+		writeJSONResponse(w, http.StatusOK, resp)
+		return
+	}
+
+	// 执行查询逻辑，先记录缓存代数，查询期间主题失效时不缓存结果
+	gen := c.cache.Generation(req.Subject)
+	result, sum, err := c.executeQuery(ctx, req.Subject, req.Score, req.Sort)
+	if err != nil {
+		slog.ErrorContext(ctx, "查询执行失败", "error", err)
+		resp := QueryResponse[string]{Success: false, Msg: "查询执行失败"}
+		writeJSONResponse(w, http.StatusInternalServerError, resp)
+		return
+	}
+
+	// 主题汇总只读取索引文件，不扫描全部记录；清单计数与投票文件不一致时已由清单重新计算
+	if sum == nil {
+		dirPath, _ := services.BuildSubjectPath(c.gitService.GetClonePath(), req.Subject)
+		sum, err = c.index.LoadSummary(dirPath)
+	}
 	if err != nil {
-		log.Printf("[QueryController] 查询执行失败: %%v, subject=%%s", err, req.Subject)
+		slog.ErrorContext(ctx, "读取汇总索引失败", "error", err)
 		resp := QueryResponse[string]{Success: false, Msg: "查询执行失败"}
 		writeJSONResponse(w, http.StatusInternalServerError, resp)
 		return
 	}
+	view, err := sum.View(c.scorer, req.Score, c.clock())
+	if err != nil {
+		resp := QueryResponse[string]{Success: false, Msg: err.Error()}
+		writeJSONResponse(w, http.StatusBadRequest, resp)
+		return
+	}
+
+	c.cache.Put(req.Subject, variant, gen, &cachedQuery{data: result, summary: view})
 
-	resp := QueryResponse[[]map[string]interface{}]{Success: true, Data: result}
+	resp := QueryResponse[[]map[string]interface{}]{Success: true, Data: result, Summary: view}
 	writeJSONResponse(w, http.StatusOK, resp)
 }
 
-// 执行具体的查询逻辑
-func (c *QueryController) executeQuery(subject string) ([]map[string]interface{}, error) {
-	log.Printf("开始处理查询请求，subject: %s", subject)
-	log.Printf("GitService 克隆路径: %s", c.gitService.GetClonePath())
-	// 拉取代码
-	if err := c.gitService.PullRepository("icey-storage"); err != nil {
-		log.Printf("[QueryController] Git拉取失败: %v, 路径=%s", err, "icey-storage")
-		return nil, err
+// 执行具体的查询逻辑，清单计数已过期时同时返回按投票文件重新计算的汇总
+func (c *QueryController) executeQuery(ctx context.Context, subject, scoreMethod, sortBy string) ([]map[string]interface{}, *services.SubjectSummary, error) {
+	// 本地仓库由后台同步，只有超过最大陈旧时间时才同步拉取
+	if err := c.syncer.EnsureFresh(); err != nil {
+		slog.ErrorContext(ctx, "Git拉取失败", "error", err)
+		return nil, nil, err
 	}
 
 	// 检查SHA256目录是否存在
 	exists, err := c.gitService.CheckSHA256Directory(subject)
 	if err != nil {
-		log.Printf("[QueryController] 检查SHA256目录失败: %v", err)
-		return nil, err
+		slog.ErrorContext(ctx, "检查主题目录失败", "error", err)
+		return nil, nil, err
 	}
 
 	if !exists {
-		log.Printf("未找到任何.sj文件，返回空数组")
-		return []map[string]interface{}{}, nil
+		slog.DebugContext(ctx, "主题目录不存在，返回空结果")
+		return []map[string]interface{}{}, nil, nil
 	}
 
 	// 构建完整目录路径
 	dirPath, _ := services.BuildSubjectPath(c.gitService.GetClonePath(), subject)
 	if dirPath == "" {
-		return nil, fmt.Errorf("无效的subject格式")
+		return nil, nil, fmt.Errorf("无效的subject格式")
 	}
-	log.Printf("完整查询目录路径: %s", dirPath)
 
-	// 读取目录下所有文件
-	entries, err := os.ReadDir(dirPath)
+	// 从清单获取记录列表和投票计数，无需扫描目录
+	manifest, err := c.index.Load(dirPath)
 	if err != nil {
-		log.Printf("读取目录失败: %v, 路径: %s", err, dirPath)
-		return nil, fmt.Errorf("读取目录失败: %v", err)
+		slog.ErrorContext(ctx, "读取清单失败", "error", err)
+		return nil, nil, err
 	}
-
-	log.Printf("找到文件数量: %d", len(entries))
-	var sjFiles []string
-	for i, entry := range entries {
-		log.Printf("文件 %d: 名称=%s, 是否是目录=%v", i+1, entry.Name(), entry.IsDir())
-		
-		if !strings.HasSuffix(entry.Name(), ".sj") {
-			log.Printf("跳过非.sj文件: %s", entry.Name())
-			continue
-		}
-		
-		// 解析文件名格式: 时间戳-ID
-		fileNameParts := strings.Split(strings.TrimSuffix(entry.Name(), ".sj"), "-")
-		if len(fileNameParts) != 2 {
-			log.Printf("文件名格式不正确: %s", entry.Name())
-			continue
-		}
-		
-		sjFiles = append(sjFiles, entry.Name())
+	// 以投票文件校验清单中的计数，清单未随投票文件更新时改用投票文件的统计
+	verified, stale := c.index.Verify(dirPath, manifest)
+	var summary *services.SubjectSummary
+	if stale {
+		slog.WarnContext(ctx, "清单计数与投票文件不一致，使用投票文件统计")
+		summary = manifest.Summary()
 	}
-
-	// 按时间戳排序
-	sort.Slice(sjFiles, func(i, j int) bool {
-		ts1 := strings.Split(sjFiles[i], "-")[0]
-		ts2 := strings.Split(sjFiles[j], "-")[0]
-		return ts1 > ts2
-	})
+	records := manifest.Active()
 
 	// 解析文件内容
+	now := c.clock()
 	var results []map[string]interface{}
-	for _, filename := range sjFiles {
-		// 解析文件名
-		prefix := strings.TrimSuffix(filename, ".sj")
-		parts := strings.Split(prefix, "-")
-		if len(parts) < 2 {
-			continue
-		}
-		ts := parts[0]
-		id := prefix // 这里id为完整前缀"时间戳-雪花ID"
+	for _, rec := range records {
+		prefix := rec.Prefix
 
 		// 读取文件内容
-		filePath := filepath.Join(dirPath, filename)
+		filePath := filepath.Join(dirPath, prefix+".sj")
 		content, err := os.ReadFile(filePath)
 		if err != nil {
-			log.Printf("读取文件失败: %v, 文件: %s", err, filePath)
+			slog.WarnContext(ctx, "读取记录文件失败", "id", prefix, "error", err)
 			continue
 		}
-		log.Printf("成功读取文件: %s", filePath)
 		contentStr := string(content)
 
-		// 获取bitmap统计信息（针对每条记录自己的 .bm/.bmi 文件）
-		bmFile := filepath.Join(dirPath, prefix+".bm")
-		bmiFile := filepath.Join(dirPath, prefix+".bmi")
-		bm, _ := os.ReadFile(bmFile)
-		bmi, _ := os.ReadFile(bmiFile)
-		trueCount := 0
-		falseCount := 0
-		total := 0
-		for i := 0; i < len(bmi) && i < len(bm); i++ {
-			if bmi[i] == 1 {
-				total++
-				if bm[i] == 1 {
-					trueCount++
-				} else {
-					falseCount++
-				}
-			}
+		stats, ok := verified[prefix]
+		if !ok {
+			stats = rec.Stats()
 		}
-		percent := 0
-		if total > 0 {
-			percent = int(float64(trueCount) / float64(total) * 100)
+		score, method, err := c.scorer.Score(scoreMethod, stats, now)
+		if err != nil {
+			return nil, nil, err
 		}
 		conf := map[string]interface{}{
-			"total": total,
-			"true": trueCount,
-			"false": falseCount,
-			"percent": percent,
+			"total":          stats.Total,
+			"true":           stats.True,
+			"false":          stats.False,
+			"percent":        stats.Percent,
+			"recent_total":   stats.RecentTotal,
+			"recent_true":    stats.RecentTrue,
+			"recent_false":   stats.RecentFalse,
+			"recent_percent": stats.RecentPercent,
+			"weighted_true":  stats.WeightedTrue,
+			"weighted_total": stats.WeightedTotal,
+			"score":          score,
+			"score_method":   method,
 		}
 
 		result := map[string]interface{}{
 			"content": contentStr,
-			"id":     id, // 返回完整前缀
-			"ts":     ts,
-			"conf":   conf,
+			"id":      prefix, // 返回完整前缀"时间戳-雪花ID"
+			"ts":      strconv.FormatInt(rec.TS, 10),
+			"conf":    conf,
+		}
+		if rec.Category != "" {
+			result["category"] = rec.Category
 		}
 		results = append(results, result)
-		log.Printf("成功读取文件: %s", filePath)
 	}
 
 	if len(results) == 0 {
-		return []map[string]interface{}{}, nil
+		return []map[string]interface{}{}, summary, nil
 	}
 
-	log.Printf("准备返回 %d 条查询结果", len(results))
-	return results, nil
+	// 按评分从高到低排序，评分相同时保持时间倒序
+	if sortBy == "score" {
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i]["conf"].(map[string]interface{})["score"].(float64) >
+				results[j]["conf"].(map[string]interface{})["score"].(float64)
+		})
+	}
+
+	slog.DebugContext(ctx, "查询完成", "records", len(results))
+	return results, summary, nil
 }
 
 // 辅助函数：写入JSON响应
@@ -249,4 +291,4 @@ func writeJSONResponse(w http.ResponseWriter, statusCode int, data interface{})
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(data)
-}
\ No newline at end of file
+}
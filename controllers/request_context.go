@@ -0,0 +1,14 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"meea-icey/internal/logging"
+)
+
+// withSubject 将主题哈希前缀附加到请求的context，之后的服务日志和访问日志都会带上该字段
+func withSubject(ctx *gin.Context, subjectHash string) context.Context {
+	ctx.Request = ctx.Request.WithContext(logging.WithSubject(ctx.Request.Context(), subjectHash))
+	return ctx.Request.Context()
+}
@@ -25,7 +25,9 @@ type VoteResponse struct {
 	Success bool   `json:"success"`
 	Msg     string `json:"msg"`
 	Data    struct {
-		Percent int `json:"percent"`
+		Percent       int `json:"percent"`
+		RecentPercent int `json:"recent_percent"`
+		Total         int `json:"total"`
 	} `json:"data"`
 }
 
@@ -47,7 +49,7 @@ func (c *VoteController) HandleVote(ctx *gin.Context) {
 		})
 		return
 	}
-	percent, err := c.voteService.Vote(req.Subject, req.ID, uint8(*req.Vote), req.Code)
+	stats, err := c.voteService.Vote(withSubject(ctx, req.Subject), req.Subject, req.ID, uint8(*req.Vote), req.Code)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
@@ -61,7 +63,9 @@ func (c *VoteController) HandleVote(ctx *gin.Context) {
 		"success": true,
 		"msg":    "",
 		"data": gin.H{
-			"percent": percent,
+			"percent":        stats.Percent,
+			"recent_percent": stats.RecentPercent,
+			"total":          stats.Total,
 		},
 	})
 } 
\ No newline at end of file
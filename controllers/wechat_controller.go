@@ -2,20 +2,16 @@ package controllers
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/xml"
 	"fmt"
 	"io/ioutil"
-	"log"
-	"math/big"
+	"log/slog"
 	"net/http"
-	"regexp"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"meea-icey/internal/logging"
 	"meea-icey/models"
 	"meea-icey/services"
 	"meea-icey/tools"
@@ -26,145 +22,227 @@ type WechatController struct {
 	config        *models.Config
 	redisClient   *redis.Client
 	wechatService *services.WechatService
+	router        *services.CommandRouter
+	subscriptions *services.SubscriptionService
+	verifyService *services.VerifyService
+	clock         services.Clock
 	ctx           context.Context
 }
 
 // NewWechatController 创建微信控制器实例
-func NewWechatController(config *models.Config, redisClient *redis.Client, ctx context.Context) *WechatController {
+func NewWechatController(config *models.Config, redisClient *redis.Client, ctx context.Context, wechatService *services.WechatService, router *services.CommandRouter, subscriptions *services.SubscriptionService, verifyService *services.VerifyService, clock services.Clock) *WechatController {
 	return &WechatController{
 		config:        config,
 		redisClient:   redisClient,
-		wechatService: services.NewWechatService(config, redisClient, ctx),
+		wechatService: wechatService,
+		router:        router,
+		subscriptions: subscriptions,
+		verifyService: verifyService,
+		clock:         clock.OrSystem(),
 		ctx:           ctx,
 	}
 }
 
 // HandleMessage 处理微信消息请求
 func (c *WechatController) HandleMessage(w http.ResponseWriter, r *http.Request) {
-	log.Println("接收到微信接口请求，开始处理")
+	ctx := r.Context()
 	// 处理GET请求(微信服务器验证)
 	if r.Method == "GET" {
-		log.Println("接收到微信服务器验证请求")
 		signature := r.URL.Query().Get("signature")
 		timestamp := r.URL.Query().Get("timestamp")
 		nonce := r.URL.Query().Get("nonce")
 		echostr := r.URL.Query().Get("echostr")
 
-		log.Printf("验证参数: signature=%s, timestamp=%s, nonce=%s, echostr=%s", signature, timestamp, nonce, echostr)
+		slog.DebugContext(ctx, "微信服务器验证请求", "signature", signature, "timestamp", timestamp, "nonce", nonce)
 		// 验证签名
 		if tools.VerifyWechatSignature(c.config.Wechat.Token, signature, timestamp, nonce) {
-			log.Println("签名验证成功，返回echostr")
 			w.Write([]byte(echostr))
 			return
 		}
-		log.Println("签名验证失败")
+		slog.WarnContext(ctx, "微信服务器验证签名失败")
 		w.WriteHeader(http.StatusForbidden)
 		w.Write([]byte("Invalid signature"))
 		return
 	}
 
 	// 处理POST请求(接收微信消息)
-	log.Println("开始处理微信POST消息请求")
 	r.ParseForm()
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("读取请求体失败: %v", err)
+		slog.ErrorContext(ctx, "读取微信消息失败", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 	defer r.Body.Close()
-	log.Printf("接收到微信消息原始数据: %s", string(body))
+	slog.DebugContext(ctx, "接收到微信消息", "body", string(body))
 
-	// 解析加密消息
-	var encryptedMsg tools.EncryptedMessage
-	err = xml.Unmarshal(body, &encryptedMsg)
+	// 校验签名、时间戳和nonce后解析消息(安全模式下解密)
+	decryptedMsg, status, err := c.parseInbound(r, body)
 	if err != nil {
-		log.Printf("解析加密消息失败: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		slog.WarnContext(ctx, "拒绝微信消息", "status", status, "error", err)
+		w.WriteHeader(status)
 		return
 	}
+	ctx = logging.With(ctx, "openid", decryptedMsg.FromUserName, "msg_type", decryptedMsg.MsgType)
+	slog.DebugContext(ctx, "解析微信消息", "content", decryptedMsg.Content)
 
-	// 解密消息
-	log.Println("开始解密微信消息")
-	decryptedMsg, err := tools.DecryptWechatMessage(encryptedMsg.Encrypt, c.config.Wechat.EncodingAESKey, c.config.Wechat.AppID)
-	if err != nil {
-		log.Printf("解密消息失败: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	if decryptedMsg.MsgType == "event" {
+		c.handleEvent(ctx, w, r, decryptedMsg)
 		return
 	}
-	log.Printf("解密后的消息内容: %+v", decryptedMsg)
 
 	// 检查消息类型是否为文本
 	if decryptedMsg.MsgType != "text" {
-		log.Printf("不支持的消息类型: %s", decryptedMsg.MsgType)
+		slog.InfoContext(ctx, "忽略不支持的消息类型")
 		w.Write([]byte("success"))
 		return
 	}
 
-	// 提取subject和验证码关键字
-	content := decryptedMsg.Content
-	codeRegex := regexp.MustCompile(`^(.*?)验证码$`)
-	matches := codeRegex.FindStringSubmatch(content)
+	// 按指令分发文本消息
+	reply, err := c.router.Dispatch(c.ctx, decryptedMsg.FromUserName, decryptedMsg.Content)
+	if err != nil {
+		slog.ErrorContext(ctx, "处理指令失败", "error", err, "content", decryptedMsg.Content)
+		reply = "处理失败，请稍后重试。"
+	}
+	c.writeTextReply(ctx, w, r, decryptedMsg, reply)
+}
 
-	if len(matches) < 2 {
-		log.Printf("消息内容不包含验证码关键字: %s", content)
-		w.Write([]byte("success"))
-		return
+// parseInbound 校验推送请求并解析消息
+// 所有模式都校验URL中的signature、时间戳偏差和nonce重放；encrypt_type=aes时额外校验msg_signature并解密
+func (c *WechatController) parseInbound(r *http.Request, body []byte) (*tools.DecryptedMessage, int, error) {
+	query := r.URL.Query()
+	timestamp := query.Get("timestamp")
+	nonce := query.Get("nonce")
+	encrypted := query.Get("encrypt_type") == "aes"
+
+	switch c.mode() {
+	case "safe":
+		if !encrypted {
+			return nil, http.StatusBadRequest, fmt.Errorf("安全模式下只接受加密消息")
+		}
+	case "plaintext":
+		if encrypted {
+			return nil, http.StatusBadRequest, fmt.Errorf("明文模式下不接受加密消息")
+		}
 	}
 
-	subject := strings.TrimSpace(matches[1])
-	if subject == "" {
-		log.Printf("未提取到主题内容: %s", content)
-		w.Write([]byte("success"))
-		return
+	if !tools.VerifyWechatSignature(c.config.Wechat.Token, query.Get("signature"), timestamp, nonce) {
+		return nil, http.StatusForbidden, fmt.Errorf("signature验证失败")
+	}
+	if err := c.checkFreshness(timestamp, nonce); err != nil {
+		return nil, http.StatusForbidden, err
 	}
 
-	// 生成六位随机验证码
-	code := generateSixDigitCode()
-	log.Printf("生成验证码: %s, 主题: %s", code, subject)
+	if !encrypted {
+		var msg tools.DecryptedMessage
+		if err := xml.Unmarshal(body, &msg); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("解析明文消息失败: %v", err)
+		}
+		return &msg, http.StatusOK, nil
+	}
 
-	// 计算subject的SHA256哈希
-	hash := sha256.Sum256([]byte(subject))
-	subjectHash := hex.EncodeToString(hash[:])
+	var encryptedMsg tools.EncryptedMessage
+	if err := xml.Unmarshal(body, &encryptedMsg); err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("解析加密消息失败: %v", err)
+	}
+	if !tools.VerifyMsgSignature(c.config.Wechat.Token, timestamp, nonce, encryptedMsg.Encrypt, query.Get("msg_signature")) {
+		return nil, http.StatusForbidden, fmt.Errorf("msg_signature验证失败")
+	}
+	msg, err := tools.DecryptWechatMessage(encryptedMsg.Encrypt, c.config.Wechat.EncodingAESKey, c.config.Wechat.AppID)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("解密消息失败: %v", err)
+	}
+	return msg, http.StatusOK, nil
+}
 
-	// 构建Redis key
-	redisKey := fmt.Sprintf("icey:subject:%s:%s", subjectHash, code)
+// checkFreshness 拒绝时间戳偏差过大或nonce重复的请求
+func (c *WechatController) checkFreshness(timestamp, nonce string) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("无效的timestamp: %s", timestamp)
+	}
+	skew := c.config.Wechat.TimestampSkew
+	if skew <= 0 {
+		skew = 5 * time.Minute
+	}
+	diff := c.clock().Sub(time.Unix(ts, 0))
+	if diff > skew || diff < -skew {
+		return fmt.Errorf("timestamp偏差过大: %s", diff.Round(time.Second))
+	}
 
-	// 存储到Redis，初始值为0
-	err = c.redisClient.Set(c.ctx, redisKey, 0, 24*time.Hour).Err()
+	// 同一nonce在时间窗口内只能使用一次
+	key := fmt.Sprintf("icey:wechat:nonce:%s:%s", timestamp, nonce)
+	first, err := c.redisClient.SetNX(c.ctx, key, 1, 2*skew).Result()
 	if err != nil {
-		log.Printf("存储验证码到Redis失败: %v", err)
-		w.Write([]byte("success"))
-		return
+		return fmt.Errorf("检查nonce失败: %v", err)
 	}
-	log.Printf("验证码成功存储到Redis: %s", redisKey)
+	if !first {
+		return fmt.Errorf("重复的nonce: %s", nonce)
+	}
+	return nil
+}
 
-	// 构建被动回复XML
-	msgContent := fmt.Sprintf("您的验证码是: %s", code)
-	replyXML := fmt.Sprintf(`<xml>
-  <ToUserName><![CDATA[%s]]></ToUserName>
-  <FromUserName><![CDATA[%s]]></FromUserName>
-  <CreateTime>%d</CreateTime>
-  <MsgType><![CDATA[text]]></MsgType>
-  <Content><![CDATA[%s]]></Content>
-</xml>`, decryptedMsg.FromUserName, decryptedMsg.ToUserName, time.Now().Unix(), msgContent)
+// mode 返回配置的消息加解密方式，未配置时为安全模式
+func (c *WechatController) mode() string {
+	switch c.config.Wechat.Mode {
+	case "compatible", "plaintext":
+		return c.config.Wechat.Mode
+	}
+	return "safe"
+}
 
-	w.Header().Set("Content-Type", "application/xml")
-	if _, err := w.Write([]byte(replyXML)); err != nil {
-		log.Printf("发送被动回复失败: %v", err)
+// handleEvent 处理关注/取消关注和菜单事件
+func (c *WechatController) handleEvent(ctx context.Context, w http.ResponseWriter, r *http.Request, msg *tools.DecryptedMessage) {
+	slog.InfoContext(ctx, "接收到微信事件", "event", msg.Event, "event_key", msg.EventKey)
+	switch msg.Event {
+	case "subscribe":
+		c.writeTextReply(ctx, w, r, msg, "欢迎关注！发送“<主题>验证码”获取验证码，发送“<主题>查询”查看主题汇总。\n"+c.router.Help())
+	case "unsubscribe":
+		// 用户取消关注后无法再收到消息，清理订阅和未过期的验证码
+		if err := c.subscriptions.UnsubscribeAll(c.ctx, msg.FromUserName); err != nil {
+			slog.WarnContext(ctx, "清理用户订阅失败", "error", err)
+		}
+		if _, err := c.verifyService.RevokeCodes(c.ctx, services.HashOpenID(msg.FromUserName)); err != nil {
+			slog.WarnContext(ctx, "清理用户验证码失败", "error", err)
+		}
+		w.Write([]byte("success"))
+	case "CLICK":
+		// 菜单的key即为指令文本
+		reply, err := c.router.Dispatch(c.ctx, msg.FromUserName, msg.EventKey)
+		if err != nil {
+			slog.ErrorContext(ctx, "处理菜单指令失败", "error", err, "event_key", msg.EventKey)
+			reply = "处理失败，请稍后重试。"
+		}
+		c.writeTextReply(ctx, w, r, msg, reply)
+	default:
+		// VIEW等跳转类事件无需回复
+		w.Write([]byte("success"))
 	}
 }
 
-// 生成六位随机数字验证码
-func generateSixDigitCode() string {
-	max := big.NewInt(900000)
-	min := big.NewInt(100000)
-	// 生成 [0, 900000) 之间的随机数
-	randNum, err := rand.Int(rand.Reader, max)
+// writeTextReply 构建并写入文本被动回复XML，请求为安全模式(encrypt_type=aes)时加密并签名
+func (c *WechatController) writeTextReply(ctx context.Context, w http.ResponseWriter, r *http.Request, msg *tools.DecryptedMessage, content string) {
+	now := c.clock().Unix()
+	replyXML, err := tools.BuildTextReply(msg.FromUserName, msg.ToUserName, now, content)
 	if err != nil {
-		return "123456"
+		slog.ErrorContext(ctx, "生成被动回复失败", "error", err)
+		w.Write([]byte("success"))
+		return
+	}
+
+	if r.URL.Query().Get("encrypt_type") == "aes" {
+		nonce := r.URL.Query().Get("nonce")
+		encrypted, err := tools.BuildEncryptedReply(replyXML, c.config.Wechat.Token, c.config.Wechat.EncodingAESKey, c.config.Wechat.AppID, strconv.FormatInt(now, 10), nonce)
+		if err != nil {
+			slog.ErrorContext(ctx, "加密被动回复失败", "error", err)
+			w.Write([]byte("success"))
+			return
+		}
+		replyXML = encrypted
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	if _, err := w.Write(replyXML); err != nil {
+		slog.WarnContext(ctx, "发送被动回复失败", "error", err)
 	}
-	// 加上 min，得到 [100000, 1000000) 之间的随机数
-	result := new(big.Int).Add(randNum, min)
-	return result.String()
-}
\ No newline at end of file
+}
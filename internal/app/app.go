@@ -0,0 +1,460 @@
+// Package app 组装服务端的全部依赖和路由，供 cmd/server、其他二进制和测试嵌入使用
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+
+	"meea-icey/controllers"
+	"meea-icey/internal/crypto"
+	"meea-icey/internal/events"
+	"meea-icey/internal/license"
+	"meea-icey/internal/logging"
+	"meea-icey/internal/metrics"
+	"meea-icey/internal/verification"
+	"meea-icey/models"
+	"meea-icey/services"
+)
+
+// Option 自定义App的依赖
+type Option func(*App)
+
+// WithRedis 使用已有的Redis客户端，App不负责关闭
+func WithRedis(client *redis.Client) Option {
+	return func(a *App) { a.redisClient = client }
+}
+
+// WithGitService 使用已有的GitService，例如指向本地裸仓库的实例
+func WithGitService(gitService *services.GitService) Option {
+	return func(a *App) { a.gitService = gitService }
+}
+
+// WithClock 注入时钟，用于评分衰减和微信消息时间戳校验
+func WithClock(clock services.Clock) Option {
+	return func(a *App) { a.clock = clock }
+}
+
+// App 服务端应用
+type App struct {
+	config      *models.Config
+	redisClient *redis.Client
+	ownsRedis   bool
+	gitService  *services.GitService
+	clock       services.Clock
+
+	syncer        *services.RepoSyncer
+	repoChecker   *services.RepoChecker
+	reputation    *services.ReputationService
+	eventBus      *events.Bus
+	wechatService *services.WechatService
+	queryCache    *services.QueryCache
+
+	router *gin.Engine
+	server *http.Server
+	// metricsServer 独立监听地址上的/metrics，未配置metrics.listen时为nil
+	metricsServer *http.Server
+	// releaseClone 释放Run期间独占的克隆目录，icey-admin据此拒绝改写服务正在使用的仓库
+	releaseClone func()
+
+	// 后台任务
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+}
+
+// New 根据配置创建App，未注入的依赖按配置创建；不启动后台任务和HTTP服务
+func New(config *models.Config, opts ...Option) (*App, error) {
+	a := &App{config: config}
+	for _, opt := range opts {
+		opt(a)
+	}
+	a.clock = a.clock.OrSystem()
+
+	if a.redisClient == nil {
+		a.redisClient = redis.NewClient(&redis.Options{
+			Addr:     fmt.Sprintf("%s:%d", config.Redis.IP, config.Redis.Port),
+			Password: config.Redis.Password,
+			DB:       0,
+		})
+		a.ownsRedis = true
+		if _, err := a.redisClient.Ping(context.Background()).Result(); err != nil {
+			a.redisClient.Close()
+			return nil, fmt.Errorf("Redis连接失败: %v", err)
+		}
+		slog.Info("Redis连接成功")
+	}
+
+	if a.gitService == nil {
+		gitService, err := services.NewGitService(config.Repository.ClonePath, config.Repository.URL, config.Repository.SSHKey)
+		if err != nil {
+			a.closeRedis()
+			return nil, fmt.Errorf("初始化GitService失败: %v", err)
+		}
+		a.gitService = gitService
+	}
+
+	a.router = a.buildRouter()
+	return a, nil
+}
+
+// Router 返回已注册全部路由的gin引擎
+func (a *App) Router() *gin.Engine {
+	return a.router
+}
+
+// Start 启动后台任务：仓库同步和检查、信誉结算、事件投递和access_token刷新
+func (a *App) Start(ctx context.Context) {
+	ctx, a.cancel = context.WithCancel(ctx)
+	a.goWorker(func() { a.syncer.Run(ctx) })
+	a.goWorker(func() { a.repoChecker.Run(ctx) })
+	a.goWorker(func() { a.reputation.Run(ctx) })
+	a.goWorker(func() { a.eventBus.Run(ctx) })
+	if a.config.Wechat.AppID != "" {
+		a.goWorker(func() { a.wechatService.Tokens().Run(ctx) })
+	}
+}
+
+// Run 独占克隆目录，恢复上次异常退出的工作区并检查仓库，启动后台任务并监听HTTP端口；
+// ctx取消(如收到SIGTERM)时在shutdown_timeout内优雅关闭后返回
+func (a *App) Run(ctx context.Context) error {
+	release, err := services.ClaimClone(a.config.Repository.ClonePath)
+	if err != nil {
+		return fmt.Errorf("占用克隆目录失败: %v", err)
+	}
+	a.releaseClone = release
+
+	if err := a.gitService.RecoverWorktree(); err != nil {
+		slog.Warn("恢复仓库工作区失败", "error", err)
+	}
+	if health := a.repoChecker.Check(); !health.Healthy {
+		slog.Warn("仓库检查未通过，服务继续启动", "problems", health.Problems)
+	}
+	a.Start(context.Background())
+
+	listenAddr := fmt.Sprintf("%s:%d", a.config.Server.Host, a.config.Server.Port)
+	a.server = &http.Server{
+		Addr:    listenAddr,
+		Handler: a.router,
+	}
+
+	errCh := make(chan error, 2)
+	go func() {
+		slog.Info("服务器启动", "addr", listenAddr)
+		errCh <- a.server.ListenAndServe()
+	}()
+	if a.config.Metrics.Enabled && a.config.Metrics.Listen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Protect(metrics.Handler(), a.config.Metrics.Token))
+		a.metricsServer = &http.Server{
+			Addr:    a.config.Metrics.Listen,
+			Handler: mux,
+		}
+		go func() {
+			slog.Info("指标服务启动", "addr", a.config.Metrics.Listen)
+			errCh <- a.metricsServer.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		if a.server != nil {
+			a.server.Close()
+		}
+		if a.metricsServer != nil {
+			a.metricsServer.Close()
+		}
+		a.stopWorkers(context.Background())
+		a.releaseClone()
+		return fmt.Errorf("服务器启动失败: %v", err)
+	case <-ctx.Done():
+		slog.Info("收到退出信号，开始关闭服务")
+		timeout := a.config.Server.ShutdownTimeout
+		if timeout <= 0 {
+			timeout = defaultShutdownTimeout
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return a.Shutdown(shutdownCtx)
+	}
+}
+
+// 未配置shutdown_timeout时的默认值
+const defaultShutdownTimeout = 25 * time.Second
+
+// Shutdown 按顺序关闭服务，ctx到期后不再等待：
+// 停止接收新请求并等待处理中的请求，停止后台任务，等待进行中的Git提交和推送，
+// 最后释放仍持有的文件锁
+func (a *App) Shutdown(ctx context.Context) error {
+	var errs []error
+	if a.server != nil {
+		if err := a.server.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("关闭HTTP服务失败: %v", err))
+		}
+	}
+	if a.metricsServer != nil {
+		if err := a.metricsServer.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("关闭指标服务失败: %v", err))
+		}
+	}
+	// 请求处理完后投递剩余事件，超时未投递的写入重试队列
+	if err := a.eventBus.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := a.stopWorkers(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := a.gitService.Drain(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if n := a.gitService.ReleaseLocks(); n > 0 {
+		errs = append(errs, fmt.Errorf("%d个文件锁释放失败", n))
+	}
+	a.closeRedis()
+	if a.releaseClone != nil {
+		a.releaseClone()
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	slog.Info("服务已关闭")
+	return nil
+}
+
+func (a *App) goWorker(fn func()) {
+	a.workers.Add(1)
+	go func() {
+		defer a.workers.Done()
+		fn()
+	}()
+}
+
+func (a *App) stopWorkers(ctx context.Context) error {
+	if a.cancel != nil {
+		a.cancel()
+	}
+	done := make(chan struct{})
+	go func() {
+		a.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("等待后台任务退出超时: %v", ctx.Err())
+	}
+}
+
+func (a *App) closeRedis() {
+	if a.ownsRedis {
+		a.redisClient.Close()
+	}
+}
+
+// buildRouter 初始化所有服务并注册路由
+func (a *App) buildRouter() *gin.Engine {
+	config := a.config
+	redisClient := a.redisClient
+	gitService := a.gitService
+	ctx := context.Background()
+
+	// 初始化服务
+	verifyService := services.NewVerifyService(redisClient, config)
+	voteStore := services.NewVoteStore(gitService, config.Votes.Window)
+
+	// 后台同步远程仓库，读请求使用本地仓库
+	repoSyncer := services.NewRepoSyncer(config, gitService)
+	a.syncer = repoSyncer
+	// 启动时和定期检查本地仓库，按配置修复
+	a.repoChecker = services.NewRepoChecker(config, gitService, repoSyncer)
+
+	// 初始化控制器
+	scorer := services.NewScorer(config)
+	subjectIndex := services.NewSubjectIndex(voteStore, services.NewSummaryService(voteStore))
+	// 查询缓存：本地提交或拉取到远程变更时按主题失效
+	queryCache := services.NewQueryCache(config)
+	gitService.OnChange(queryCache.InvalidatePaths)
+	a.queryCache = queryCache
+	queryController := controllers.NewQueryController(verifyService, gitService, repoSyncer, voteStore, scorer, subjectIndex, queryCache, a.clock)
+
+	// 初始化Services
+	reputationService := services.NewReputationService(config, redisClient, voteStore)
+	if err := reputationService.Restore(ctx); err != nil {
+		slog.Warn("恢复信誉数据失败", "error", err)
+	}
+	a.reputation = reputationService
+	// 记录生命周期事件总线
+	eventBus := events.NewBus(config.Events.Buffer)
+	if config.Events.Webhook.Enabled {
+		eventBus.Subscribe(events.NewWebhookSink(events.WebhookOptions{
+			URLs:          config.Events.Webhook.URLs,
+			Secret:        config.Events.Webhook.Secret,
+			MaxRetries:    config.Events.Webhook.MaxRetries,
+			RetryBackoff:  config.Events.Webhook.RetryBackoff,
+			Timeout:       config.Events.Webhook.Timeout,
+			DeadLetterKey: config.Events.Webhook.DeadLetterKey,
+			RetryKey:      config.Events.Webhook.RetryKey,
+		}, redisClient))
+	}
+	if config.Events.Stream.Enabled {
+		eventBus.Subscribe(events.NewStreamSink(redisClient, config.Events.Stream.Key, config.Events.Stream.MaxLen))
+	}
+	a.eventBus = eventBus
+
+	// 微信服务和主题订阅通知
+	wechatService := services.NewWechatService(config, redisClient, ctx)
+	a.wechatService = wechatService
+	subscriptionService := services.NewSubscriptionService(config, redisClient, wechatService)
+	eventBus.Subscribe(subscriptionService)
+
+	commitService := services.NewCommitService(config, verifyService, gitService, repoSyncer, voteStore, reputationService, subjectIndex, eventBus)
+	deleteService := services.NewDeleteService(config, verifyService, gitService, repoSyncer, subjectIndex, eventBus)
+	voteService := services.NewVoteService(config, verifyService, gitService, repoSyncer, voteStore, reputationService, subjectIndex, eventBus)
+
+	// 初始化Controllers
+	commitController := controllers.NewCommitController(config, verifyService, gitService, commitService)
+	deleteController := controllers.NewDeleteController(deleteService)
+	voteController := controllers.NewVoteController(voteService)
+	commandRouter := services.NewWechatCommandRouter(config, redisClient, verifyService, subscriptionService, reputationService, repoSyncer, subjectIndex, scorer)
+	wechatController := controllers.NewWechatController(config, redisClient, ctx, wechatService, commandRouter, subscriptionService, verifyService, a.clock)
+	gitHookController := controllers.NewGitHookController(config, repoSyncer, queryCache)
+	// 微信以外的验证码渠道
+	var codeIssuers []services.CodeIssuer
+	if config.Verification.Email.Enabled {
+		codeIssuers = append(codeIssuers, services.NewEmailIssuer(config, verifyService, redisClient))
+	}
+	if config.Verification.Partners.Enabled {
+		codeIssuers = append(codeIssuers, services.NewPartnerIssuer(config, verifyService))
+	}
+	if config.Verification.TOTP.Enabled {
+		codeIssuers = append(codeIssuers, services.NewTOTPIssuer(config, verifyService, redisClient))
+	}
+	codeController := controllers.NewCodeController(codeIssuers...)
+
+	// 初始化许可证系统
+	cryptoService, err := crypto.NewService(
+		config.License.CommPrivateKeyPath,
+		config.License.SignPrivateKeyPath,
+	)
+	if err != nil {
+		slog.Warn("许可证系统初始化失败，许可证功能将不可用", "error", err)
+	}
+
+	var licenseHandler *license.Handler
+	var licenseAdminHandler *license.AdminHandler
+	var licenseVerificationService *verification.LicenseVerificationService
+
+	if cryptoService != nil {
+		// 初始化许可证专用验证服务
+		licenseVerificationService = verification.NewLicenseVerificationService(redisClient, config.License.DebugMode)
+
+		// 初始化许可证服务
+		licenseService := license.NewService(cryptoService, licenseVerificationService)
+		licenseHandler = license.NewHandler(licenseService)
+
+		// 初始化许可证管理服务
+		licenseAdminHandler = license.NewAdminHandler(licenseVerificationService)
+	}
+
+	// 就绪检查
+	healthService := services.NewHealthService(config)
+	a.registerHealthChecks(healthService, err)
+	healthController := controllers.NewHealthController(healthService)
+
+	// 设置路由
+	router := gin.New()
+	// 默认采信任意来源的X-Forwarded-For，客户端可伪造IP绕过按IP的限流
+	if err := router.SetTrustedProxies(config.Server.TrustedProxies); err != nil {
+		slog.Warn("可信代理配置无效，忽略X-Forwarded-For", "error", err)
+		router.SetTrustedProxies(nil)
+	}
+	router.Use(gin.Recovery(), logging.Middleware())
+
+	// 添加CORS中间件
+	router.Use(CORSMiddleware())
+	if config.Metrics.Enabled {
+		router.Use(metrics.Middleware())
+		// 配置了独立监听地址时由Run启动管理端口，业务端口不开放；否则必须配置令牌
+		switch {
+		case config.Metrics.Listen != "":
+		case config.Metrics.Token != "":
+			router.GET("/metrics", gin.WrapH(metrics.Protect(metrics.Handler(), config.Metrics.Token)))
+		default:
+			slog.Warn("未配置metrics.listen或metrics.token，不开放/metrics")
+		}
+	}
+
+	// 健康检查接口
+	router.GET("/health", func(c *gin.Context) {
+		c.JSON(200, gin.H{"status": "ok", "query_cache": queryCache.Stats(), "repository": repoSyncer.Status(), "repository_health": a.repoChecker.Status()})
+	})
+	router.GET("/healthz", healthController.HandleLiveness)
+	router.GET("/readyz", healthController.HandleReadiness)
+
+	router.Any("/wechat", func(c *gin.Context) {
+		wechatController.HandleMessage(c.Writer, c.Request)
+	})
+	router.POST("/query", func(c *gin.Context) {
+		queryController.HandleQuery(c.Writer, c.Request)
+	})
+	router.POST("/commit", commitController.HandleCommit)
+	router.POST("/hooks/git", gitHookController.HandleHook)
+	router.POST("/delete", deleteController.HandleDelete)
+	router.POST("/vote", voteController.HandleVote)
+
+	codes := router.Group("/api/v1/codes")
+	{
+		codes.POST("/email", codeController.HandleEmail)
+		codes.POST("/partner", codeController.HandlePartner)
+		codes.POST("/totp", codeController.HandleTOTP)
+	}
+
+	// 许可证API路由
+	if licenseHandler != nil {
+		v1 := router.Group("/api/v1")
+		{
+			v1.POST("/license", licenseHandler.RequestLicense)
+		}
+
+		// 许可证管理API路由
+		if licenseAdminHandler != nil {
+			admin := router.Group("/api/admin")
+			{
+				admin.POST("/license/generate-code", licenseAdminHandler.GenerateLicenseCode)
+			}
+		}
+
+		slog.Info("许可证系统已启用")
+	} else {
+		slog.Info("许可证系统未启用")
+	}
+
+	return router
+}
+
+// CORS中间件
+func CORSMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.Header("Access-Control-Allow-Credentials", "true")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Header("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(204)
+			return
+		}
+
+		c.Next()
+	}
+}
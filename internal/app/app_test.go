@@ -0,0 +1,400 @@
+package app_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-redis/redis/v8"
+	"meea-icey/internal/app"
+	"meea-icey/internal/wechatsim"
+	"meea-icey/models"
+	"meea-icey/services"
+)
+
+var testAccount = wechatsim.Account{
+	Token:          "e2etoken",
+	EncodingAESKey: "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFG",
+	AppID:          "wx0123456789abcdef",
+	OriginalID:     "gh_0123456789ab",
+}
+
+// harness 进程内的完整服务: 本地裸仓库作为远程仓库，miniredis代替Redis
+type harness struct {
+	t      *testing.T
+	config *models.Config
+	redis  *redis.Client
+	git    *services.GitService
+	router http.Handler
+	remote string
+	wechat *wechatsim.Client
+}
+
+func newHarness(t *testing.T, opts ...app.Option) *harness {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	remote := filepath.Join(dir, "remote.git")
+	initBareRepository(t, remote, filepath.Join(dir, "seed"))
+
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+
+	cfg := &models.Config{}
+	cfg.Repository.URL = "file://" + remote
+	cfg.Repository.ClonePath = filepath.Join(dir, "clone")
+	cfg.Repository.SyncInterval = time.Hour
+	cfg.Repository.MaxStaleness = time.Hour
+	cfg.Wechat.AppID = testAccount.AppID
+	cfg.Wechat.Token = testAccount.Token
+	cfg.Wechat.EncodingAESKey = testAccount.EncodingAESKey
+	cfg.Verification.MaxAttempts = 15
+	cfg.Votes.Window = 256
+	cfg.QueryCache.Enabled = true
+	cfg.Metrics.Enabled = true
+	cfg.Metrics.Token = testMetricsToken
+	cfg.License.CommPrivateKeyPath = filepath.Join(dir, "missing-comm.pem")
+	cfg.License.SignPrivateKeyPath = filepath.Join(dir, "missing-sign.pem")
+
+	gitService, err := services.NewGitService(cfg.Repository.ClonePath, cfg.Repository.URL, "")
+	if err != nil {
+		t.Fatalf("本地仓库不应要求SSH密钥: %v", err)
+	}
+	application, err := app.New(cfg, append([]app.Option{app.WithRedis(redisClient), app.WithGitService(gitService)}, opts...)...)
+	if err != nil {
+		t.Fatalf("初始化服务失败: %v", err)
+	}
+	application.Start(context.Background())
+	t.Cleanup(func() { application.Shutdown(context.Background()) })
+	router := application.Router()
+	return &harness{t: t, config: cfg, redis: redisClient, git: gitService, router: router, remote: remote, wechat: wechatsim.NewClient(router, testAccount)}
+}
+
+// initBareRepository 创建带初始提交的裸仓库，空仓库无法克隆
+func initBareRepository(t *testing.T, remote, seed string) {
+	t.Helper()
+	if _, err := git.PlainInit(remote, true); err != nil {
+		t.Fatalf("创建裸仓库失败: %v", err)
+	}
+	r, err := git.PlainInit(seed, false)
+	if err != nil {
+		t.Fatalf("创建种子仓库失败: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(seed, "README.md"), []byte("icey-storage\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, _ := r.Worktree()
+	w.Add("README.md")
+	sig := &object.Signature{Name: "seed", Email: "seed@example.com", When: time.Now()}
+	if _, err := w.Commit("init", &git.CommitOptions{Author: sig}); err != nil {
+		t.Fatalf("初始提交失败: %v", err)
+	}
+	if _, err := r.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remote}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Push(&git.PushOptions{}); err != nil {
+		t.Fatalf("推送初始提交失败: %v", err)
+	}
+}
+
+// requestCode 通过模拟的公众号消息获取验证码
+func (h *harness) requestCode(subject string) string {
+	h.t.Helper()
+	reply, err := h.wechat.SendText("oE2EUser", subject+"验证码")
+	if err != nil {
+		h.t.Fatalf("获取验证码失败: %v", err)
+	}
+	m := regexp.MustCompile(`验证码是: (\d{6})`).FindStringSubmatch(reply.Content())
+	if m == nil {
+		h.t.Fatalf("回复中没有验证码: %q", reply.Content())
+	}
+	return m[1]
+}
+
+type apiResponse struct {
+	Success bool            `json:"success"`
+	Msg     string          `json:"msg"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func (h *harness) post(path string, body interface{}) (int, apiResponse) {
+	h.t.Helper()
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.router.ServeHTTP(rec, req)
+	var resp apiResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		h.t.Fatalf("%s 响应不是JSON: %s", path, rec.Body.String())
+	}
+	return rec.Code, resp
+}
+
+type queryRecord struct {
+	ID      string `json:"id"`
+	Content string `json:"content"`
+	Conf    struct {
+		Total   int `json:"total"`
+		True    int `json:"true"`
+		Percent int `json:"percent"`
+	} `json:"conf"`
+}
+
+func (h *harness) query(subject, code string) []queryRecord {
+	h.t.Helper()
+	status, resp := h.post("/query", map[string]string{"subject": subject, "code": code})
+	if status != http.StatusOK || !resp.Success {
+		h.t.Fatalf("查询失败: status=%d, msg=%s", status, resp.Msg)
+	}
+	var records []queryRecord
+	if len(resp.Data) == 0 {
+		// 没有记录时data字段被省略
+		return nil
+	}
+	if err := json.Unmarshal(resp.Data, &records); err != nil {
+		h.t.Fatalf("解析查询结果失败: %v, %s", err, resp.Data)
+	}
+	return records
+}
+
+// remoteHistory 返回远程裸仓库的提交说明，最新的在前
+func (h *harness) remoteHistory() []string {
+	h.t.Helper()
+	r, err := git.PlainOpen(h.remote)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	iter, err := r.Log(&git.LogOptions{})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	var messages []string
+	iter.ForEach(func(c *object.Commit) error {
+		messages = append(messages, c.Message)
+		return nil
+	})
+	return messages
+}
+
+// remoteFiles 返回远程仓库HEAD中指定目录下的文件名
+func (h *harness) remoteFiles(dir string) []string {
+	h.t.Helper()
+	r, _ := git.PlainOpen(h.remote)
+	ref, err := r.Head()
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	commit, _ := r.CommitObject(ref.Hash())
+	tree, _ := commit.Tree()
+	sub, err := tree.Tree(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range sub.Entries {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+func TestCommitQueryVoteDelete(t *testing.T) {
+	h := newHarness(t)
+	subject := services.SubjectHash("张三")
+	relDir := filepath.ToSlash(filepath.Join(subject[:2], subject[2:4], subject[4:6], subject))
+	code := h.requestCode("张三")
+
+	// 提交
+	status, resp := h.post("/commit", map[string]string{"subject": subject, "content": "借钱不还", "category": "借贷", "code": code})
+	if status != http.StatusOK || !resp.Success {
+		t.Fatalf("提交失败: status=%d, msg=%s", status, resp.Msg)
+	}
+	var committed struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(resp.Data, &committed)
+	if committed.Token == "" {
+		t.Fatal("提交应返回删除token")
+	}
+
+	// 查询
+	records := h.query(subject, code)
+	if len(records) != 1 || records[0].Content != "借钱不还" || records[0].Conf.Total != 0 {
+		t.Fatalf("查询结果不符: %+v", records)
+	}
+	id := records[0].ID
+	if !strings.HasSuffix(committed.Token, "-"+strings.SplitN(id, "-", 2)[1]) {
+		t.Errorf("token应以记录ID结尾: token=%s, id=%s", committed.Token, id)
+	}
+
+	// 投票
+	status, resp = h.post("/vote", map[string]interface{}{"subject": subject, "id": id, "vote": 1, "code": code})
+	if status != http.StatusOK || !resp.Success {
+		t.Fatalf("投票失败: status=%d, msg=%s", status, resp.Msg)
+	}
+	records = h.query(subject, code)
+	if len(records) != 1 || records[0].Conf.Total != 1 || records[0].Conf.True != 1 || records[0].Conf.Percent != 100 {
+		t.Fatalf("投票后查询结果不符: %+v", records)
+	}
+
+	files := strings.Join(h.remoteFiles(relDir), ",")
+	for _, ext := range []string{".sj", ".vt", ".dt"} {
+		if !strings.Contains(files, id+ext) {
+			t.Errorf("远程仓库缺少%s文件: %s", ext, files)
+		}
+	}
+
+	// 错误的token不能删除
+	status, resp = h.post("/delete", map[string]string{"subject": subject, "code": code, "token": "x" + committed.Token[1:]})
+	if status == http.StatusOK && resp.Success {
+		t.Fatal("错误的token不应删除成功")
+	}
+
+	// 删除
+	status, resp = h.post("/delete", map[string]string{"subject": subject, "code": code, "token": committed.Token})
+	if status != http.StatusOK || !resp.Success {
+		t.Fatalf("删除失败: status=%d, msg=%s", status, resp.Msg)
+	}
+	if records := h.query(subject, code); len(records) != 0 {
+		t.Fatalf("删除后不应再查到记录: %+v", records)
+	}
+	files = strings.Join(h.remoteFiles(relDir), ",")
+	if strings.Contains(files, id) {
+		t.Errorf("删除后远程仓库仍有记录文件: %s", files)
+	}
+
+	// 远程仓库的提交历史: 初始提交、新增、投票、删除
+	history := h.remoteHistory()
+	if len(history) != 4 {
+		t.Fatalf("远程仓库应有4个提交, 实际%d: %q", len(history), history)
+	}
+	want := []string{"delete " + subject + " - " + id, "vote update for " + subject + "-" + id, subject + "-", "init"}
+	for i, prefix := range want {
+		if !strings.HasPrefix(history[i], prefix) {
+			t.Errorf("第%d个提交说明应以%q开头, 实际%q", i+1, prefix, history[i])
+		}
+	}
+}
+
+func TestRejectsInvalidCode(t *testing.T) {
+	h := newHarness(t)
+	subject := services.SubjectHash("李四")
+	h.requestCode("李四")
+
+	status, resp := h.post("/commit", map[string]string{"subject": subject, "content": "test", "code": "000000"})
+	if status == http.StatusOK || resp.Success {
+		t.Fatal("无效验证码不应提交成功")
+	}
+	status, _ = h.post("/query", map[string]string{"subject": subject, "code": "000000"})
+	if status != http.StatusForbidden {
+		t.Fatalf("无效验证码查询应返回403, 实际%d", status)
+	}
+	if history := h.remoteHistory(); len(history) != 1 {
+		t.Fatalf("远程仓库不应有新提交: %q", history)
+	}
+}
+
+func TestInjectedClock(t *testing.T) {
+	h := newHarness(t, app.WithClock(func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }))
+
+	// 时间戳按注入的时钟校验，系统时间的消息视为过期
+	if reply, _ := h.wechat.SendText("oE2EUser", "帮助"); reply.Status != http.StatusForbidden {
+		t.Fatalf("与注入时钟偏差过大的消息应被拒绝, 实际%d", reply.Status)
+	}
+	h.wechat.Now = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC) }
+	reply, err := h.wechat.SendText("oE2EUser", "帮助")
+	if err != nil || reply.Status != http.StatusOK {
+		t.Fatalf("与注入时钟一致的消息应被接受: %+v, %v", reply, err)
+	}
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	h := newHarness(t)
+	h.config.Server.Host = "127.0.0.1"
+	h.config.Server.Port = 0
+	application, err := app.New(h.config, app.WithRedis(h.redis), app.WithGitService(h.git))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- application.Run(ctx) }()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("取消后Run应正常返回: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("取消后Run未返回")
+	}
+}
+
+func TestClientIPOnlyTrustsConfiguredProxies(t *testing.T) {
+	h := newHarness(t)
+	clientIP := func(router *gin.Engine, remoteAddr string) string {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/client-ip", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		router.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+	route := func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) }
+
+	// 未配置可信代理时忽略X-Forwarded-For，按IP限流不能被伪造的请求头绕过
+	router := h.router.(*gin.Engine)
+	router.GET("/client-ip", route)
+	if ip := clientIP(router, "192.0.2.1:1234"); ip != "192.0.2.1" {
+		t.Errorf("未配置可信代理时 ClientIP = %s, 应为连接地址", ip)
+	}
+
+	h.config.Server.TrustedProxies = []string{"10.0.0.0/8"}
+	application, err := app.New(h.config, app.WithRedis(h.redis), app.WithGitService(h.git))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router = application.Router()
+	router.GET("/client-ip", route)
+	if ip := clientIP(router, "10.1.2.3:1234"); ip != "203.0.113.9" {
+		t.Errorf("可信代理转发时 ClientIP = %s, 应为X-Forwarded-For", ip)
+	}
+	if ip := clientIP(router, "192.0.2.1:1234"); ip != "192.0.2.1" {
+		t.Errorf("非可信来源 ClientIP = %s, 应为连接地址", ip)
+	}
+}
+
+func TestRunRefusesClaimedClone(t *testing.T) {
+	h := newHarness(t)
+	h.config.Server.Host = "127.0.0.1"
+	h.config.Server.Port = 0
+	// 管理命令正在改写克隆目录时服务不启动
+	release, err := services.ClaimClone(h.config.Repository.ClonePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	application, err := app.New(h.config, app.WithRedis(h.redis), app.WithGitService(h.git))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := application.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "克隆目录已被其他进程使用") {
+		t.Fatalf("克隆目录被占用时Run应失败: %v", err)
+	}
+}
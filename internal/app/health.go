@@ -0,0 +1,107 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"meea-icey/services"
+)
+
+// 默认的最大同步间隔
+const defaultMaxSyncAge = 10 * time.Minute
+
+// registerHealthChecks 注册/readyz的各项检查；licenseErr为许可证密钥加载失败的原因
+func (a *App) registerHealthChecks(health *services.HealthService, licenseErr error) {
+	health.Register("redis", a.checkRedis)
+	health.Register("repository", a.checkRepository)
+	health.Register("sync", a.checkSync)
+	health.Register("push", a.checkPush)
+	health.Register("license", func(ctx context.Context) (interface{}, error) {
+		// 许可证功能可选，密钥不可用时其余接口仍可服务
+		if licenseErr != nil {
+			return nil, services.Warning("许可证密钥不可用: %v", licenseErr)
+		}
+		return nil, nil
+	})
+	health.Register("wechat_token", a.checkWechatToken)
+}
+
+func (a *App) checkRedis(ctx context.Context) (interface{}, error) {
+	if err := a.redisClient.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("Redis连接失败: %v", err)
+	}
+	return nil, nil
+}
+
+// checkRepository 本地仓库可以打开且HEAD有效，关闭过程中视为未就绪
+func (a *App) checkRepository(ctx context.Context) (interface{}, error) {
+	if a.gitService.Draining() {
+		return nil, errors.New("服务正在关闭")
+	}
+	head, err := a.gitService.HeadCommit()
+	if err != nil {
+		return nil, fmt.Errorf("本地仓库不可用: %v", err)
+	}
+	detail := map[string]interface{}{"head": head}
+	status := a.repoChecker.Status()
+	if !status.CheckedAt.IsZero() {
+		detail["checked_at"] = status.CheckedAt
+		if len(status.Problems) > 0 {
+			detail["problems"] = status.Problems
+			return detail, services.Warning("仓库检查发现%d个问题", len(status.Problems))
+		}
+	}
+	return detail, nil
+}
+
+// checkSync 最近一次成功拉取远程仓库的时间
+func (a *App) checkSync(ctx context.Context) (interface{}, error) {
+	status := a.syncer.Status()
+	maxAge := a.config.Health.MaxSyncAge
+	if maxAge <= 0 {
+		maxAge = defaultMaxSyncAge
+	}
+	if status.LastSyncAt.IsZero() {
+		return status, errors.New("尚未成功拉取远程仓库")
+	}
+	if age := time.Since(status.LastSyncAt); age > maxAge {
+		return status, fmt.Errorf("距上次成功拉取已%s，超过%s", age.Round(time.Second), maxAge)
+	}
+	return status, nil
+}
+
+// checkPush 最近一次成功推送的时间；没有写入时不会推送，只有存在未推送的提交才告警
+func (a *App) checkPush(ctx context.Context) (interface{}, error) {
+	detail := map[string]interface{}{}
+	if last := a.gitService.LastPushAt(); !last.IsZero() {
+		detail["last_push_at"] = last
+		detail["age"] = time.Since(last).Round(time.Second).String()
+	}
+	if ahead := a.repoChecker.Status().Ahead; ahead > 0 {
+		detail["unpushed"] = ahead
+		return detail, services.Warning("有%d个未推送的提交", ahead)
+	}
+	return detail, nil
+}
+
+// checkWechatToken access_token缓存的剩余有效期；缓存为空时由下一次请求获取，只告警
+func (a *App) checkWechatToken(ctx context.Context) (interface{}, error) {
+	if a.config.Wechat.AppID == "" {
+		return map[string]interface{}{"configured": false}, nil
+	}
+	tokens := a.wechatService.Tokens()
+	ttl, err := tokens.TTL(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ttl < 0 {
+		return nil, services.Warning("缓存中没有access_token")
+	}
+	detail := map[string]interface{}{"expires_in": ttl.Round(time.Second).String()}
+	if ttl <= tokens.RefreshBefore() {
+		return detail, services.Warning("access_token即将过期且尚未刷新")
+	}
+	return detail, nil
+}
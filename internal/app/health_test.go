@@ -0,0 +1,92 @@
+package app_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"meea-icey/models"
+	"meea-icey/services"
+)
+
+// get 请求接口并解析JSON响应
+func (h *harness) get(path string, v interface{}) int {
+	h.t.Helper()
+	rec := httptest.NewRecorder()
+	h.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		h.t.Fatalf("%s 响应不是JSON: %s", path, rec.Body.String())
+	}
+	return rec.Code
+}
+
+func TestReadiness(t *testing.T) {
+	h := newHarness(t)
+	h.commitRecord("张三")
+
+	var live map[string]string
+	if status := h.get("/healthz", &live); status != http.StatusOK || live["status"] != services.HealthOK {
+		t.Fatalf("存活检查应返回ok: %d, %v", status, live)
+	}
+
+	var report services.HealthReport
+	if status := h.get("/readyz", &report); status != http.StatusOK {
+		t.Fatalf("依赖正常时应就绪: %d, %+v", status, report)
+	}
+	for _, name := range []string{"redis", "repository", "sync", "push", "license", "wechat_token"} {
+		if _, ok := report.Checks[name]; !ok {
+			t.Errorf("缺少检查项%s", name)
+		}
+	}
+	if c := report.Checks["license"]; c.Status != services.HealthWarn {
+		t.Errorf("许可证密钥不存在时应告警: %+v", c)
+	}
+	if c := report.Checks["push"]; c.Status != services.HealthOK || c.Detail == nil {
+		t.Errorf("提交后应记录推送时间: %+v", c)
+	}
+	if report.Status != services.HealthWarn {
+		t.Errorf("只有警告时整体状态应为warn: %s", report.Status)
+	}
+
+	h.redis.Close()
+	if status := h.get("/readyz", &report); status != http.StatusServiceUnavailable || report.Checks["redis"].Status != services.HealthFail {
+		t.Fatalf("Redis不可用时应未就绪: %d, %+v", status, report.Checks["redis"])
+	}
+	if status := h.get("/healthz", &live); status != http.StatusOK {
+		t.Fatalf("依赖故障不应影响存活检查: %d", status)
+	}
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	config := &models.Config{}
+	config.Health.CheckTimeout = 50 * time.Millisecond
+	health := services.NewHealthService(config)
+	health.Register("slow", func(ctx context.Context) (interface{}, error) {
+		// 不响应ctx的检查也应按超时返回
+		time.Sleep(time.Second)
+		return nil, nil
+	})
+	health.Register("broken", func(ctx context.Context) (interface{}, error) {
+		return map[string]int{"attempts": 3}, errors.New("不可用")
+	})
+	health.Register("fine", func(ctx context.Context) (interface{}, error) { return nil, nil })
+
+	start := time.Now()
+	report := health.Check(context.Background())
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("检查应在超时后返回, 耗时%s", elapsed)
+	}
+	if report.Ready() || report.Checks["slow"].Status != services.HealthFail {
+		t.Fatalf("超时的检查应失败: %+v", report)
+	}
+	if c := report.Checks["broken"]; c.Status != services.HealthFail || c.Error != "不可用" || c.Detail == nil {
+		t.Errorf("失败的检查应包含错误和详情: %+v", c)
+	}
+	if report.Checks["fine"].Status != services.HealthOK {
+		t.Errorf("正常的检查不应受影响: %+v", report.Checks["fine"])
+	}
+}
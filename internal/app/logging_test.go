@@ -0,0 +1,86 @@
+package app_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"meea-icey/internal/logging"
+	"meea-icey/services"
+)
+
+// syncBuffer 后台任务和请求并发写日志，读写都需加锁
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLogs 以debug级别、JSON格式捕获所有日志，需在newHarness之前调用，保证服务关闭后才恢复
+func captureLogs(t *testing.T) *syncBuffer {
+	t.Helper()
+	buf := &syncBuffer{}
+	prev := slog.Default()
+	slog.SetDefault(logging.New("debug", "json", buf))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return buf
+}
+
+func TestLogsContainNoSecrets(t *testing.T) {
+	logs := captureLogs(t)
+	h := newHarness(t)
+	subject := services.SubjectHash("王五")
+	code := h.requestCode("王五")
+
+	status, resp := h.post("/commit", map[string]string{"subject": subject, "content": "欠款八千元未还", "category": "借贷", "code": code})
+	if status != http.StatusOK || !resp.Success {
+		t.Fatalf("提交失败: %d, %s", status, resp.Msg)
+	}
+	var committed struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(resp.Data, &committed)
+	if len(h.query(subject, code)) != 1 {
+		t.Fatal("应查到提交的记录")
+	}
+	h.post("/delete", map[string]string{"subject": subject, "code": code, "token": "x" + committed.Token[1:]})
+	if status, resp := h.post("/delete", map[string]string{"subject": subject, "code": code, "token": committed.Token}); status != http.StatusOK || !resp.Success {
+		t.Fatalf("删除失败: %d, %s", status, resp.Msg)
+	}
+	if status, _ := h.wechat.Handshake("echo-7f3a9c"); status != http.StatusOK {
+		t.Fatalf("服务器验证失败: %d", status)
+	}
+
+	out := logs.String()
+	if !strings.Contains(out, `"request_id"`) || !strings.Contains(out, subject[:8]) {
+		t.Fatalf("日志应包含请求ID和主题前缀: %s", out)
+	}
+	for name, secret := range map[string]string{
+		"验证码":     code,
+		"openid":  "oE2EUser",
+		"主题名称":    "王五",
+		"记录内容":    "欠款八千元未还",
+		"删除token": committed.Token[1:],
+		"echostr": "echo-7f3a9c",
+		"消息密钥":    testAccount.EncodingAESKey,
+	} {
+		if strings.Contains(out, secret) {
+			t.Errorf("日志中出现%s: %q", name, secret)
+		}
+	}
+}
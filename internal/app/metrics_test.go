@@ -0,0 +1,182 @@
+package app_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"meea-icey/internal/app"
+	"meea-icey/services"
+)
+
+const testMetricsToken = "metrics-token"
+
+// scrape 读取/metrics，返回"名称{标签}"到数值的映射
+func (h *harness) scrape() map[string]float64 {
+	h.t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+testMetricsToken)
+	h.router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		h.t.Fatalf("/metrics 返回%d", rec.Code)
+	}
+	return parseMetrics(rec.Body)
+}
+
+func parseMetrics(body io.Reader) map[string]float64 {
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(line[i+1:], 64); err == nil {
+			values[line[:i]] = v
+		}
+	}
+	return values
+}
+
+func TestMetrics(t *testing.T) {
+	h := newHarness(t)
+	// 指标是进程级的，其他测试也会累加，只比较本测试前后的差值
+	before := h.scrape()
+
+	repoRoot := h.commitRecord("张三")
+	subject := services.SubjectHash("张三")
+	code := h.requestCode("张三")
+	records := h.query(subject, code)
+	if len(records) != 1 {
+		t.Fatalf("查询结果不符: %+v", records)
+	}
+	if status, resp := h.post("/vote", map[string]interface{}{"subject": subject, "id": records[0].ID, "vote": 1, "code": code}); status != http.StatusOK || !resp.Success {
+		t.Fatalf("投票失败: %d, %s", status, resp.Msg)
+	}
+	h.post("/query", map[string]string{"subject": subject, "code": "000000"})
+
+	path := filepath.Join(repoRoot, "README.md")
+	if err := h.git.LockFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.git.LockFile(path); err == nil {
+		t.Fatal("重复加锁应失败")
+	}
+	h.git.UnlockFile(path)
+
+	after := h.scrape()
+	delta := func(key string) float64 { return after[key] - before[key] }
+	for key, want := range map[string]float64{
+		`icey_http_requests_total{method="POST",route="/commit",status="200"}`: 1,
+		`icey_http_requests_total{method="POST",route="/vote",status="200"}`:   1,
+		`icey_verifications_total{result="success"}`:                           3,
+		`icey_verifications_total{result="failure"}`:                           1,
+		`icey_git_operation_duration_seconds_count{op="commit"}`:               2,
+		`icey_git_operation_duration_seconds_count{op="push"}`:                 2,
+		`icey_votes_total{vote="true"}`:                                        1,
+		`icey_lock_contention_total`:                                           1,
+		`icey_lock_acquire_duration_seconds_count{result="contended"}`:         1,
+	} {
+		if got := delta(key); got != want {
+			t.Errorf("%s 增加了%v, 预期%v", key, got, want)
+		}
+	}
+	if delta(`icey_lock_acquire_duration_seconds_count{result="acquired"}`) < 2 {
+		t.Error("投票和手动加锁应记录加锁耗时")
+	}
+	if delta(`icey_git_operation_errors_total{op="push"}`) != 0 {
+		t.Error("推送不应失败")
+	}
+}
+
+func TestMetricsRequiresToken(t *testing.T) {
+	h := newHarness(t)
+	for _, header := range []string{"", "Bearer wrong-token", testMetricsToken} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		h.router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization=%q: /metrics 返回%d, 预期401", header, rec.Code)
+		}
+	}
+
+	// 既没有独立监听地址也没有令牌时不开放
+	h.config.Metrics.Token = ""
+	application, err := app.New(h.config, app.WithRedis(h.redis), app.WithGitService(h.git))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	application.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("未配置令牌时 /metrics 返回%d, 预期404", rec.Code)
+	}
+}
+
+func TestMetricsOnSeparateListener(t *testing.T) {
+	h := newHarness(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metricsAddr := ln.Addr().String()
+	ln.Close()
+	h.config.Server.Host = "127.0.0.1"
+	h.config.Server.Port = 0
+	h.config.Metrics.Listen = metricsAddr
+	h.config.Metrics.Token = ""
+	application, err := app.New(h.config, app.WithRedis(h.redis), app.WithGitService(h.git))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 业务端口不注册/metrics
+	rec := httptest.NewRecorder()
+	application.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("业务端口 /metrics 返回%d, 预期404", rec.Code)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- application.Run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("关闭失败: %v", err)
+		}
+	}()
+
+	var resp *http.Response
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if resp, err = http.Get("http://" + metricsAddr + "/metrics"); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("管理端口未启动: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("管理端口 /metrics 返回%d", resp.StatusCode)
+	}
+	if _, ok := parseMetrics(resp.Body)[`icey_lock_contention_total`]; !ok {
+		t.Error("管理端口应返回本服务的指标")
+	}
+}
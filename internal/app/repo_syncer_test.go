@@ -0,0 +1,117 @@
+package app_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"meea-icey/models"
+	"meea-icey/services"
+)
+
+// newSyncerHarness 只包含本地仓库的测试环境，不启动服务自带的后台同步
+func newSyncerHarness(t *testing.T) *harness {
+	t.Helper()
+	dir := t.TempDir()
+	remote := filepath.Join(dir, "remote.git")
+	initBareRepository(t, remote, filepath.Join(dir, "seed"))
+	cfg := &models.Config{}
+	cfg.Repository.URL = "file://" + remote
+	cfg.Repository.ClonePath = filepath.Join(dir, "clone")
+	gitService, err := services.NewGitService(cfg.Repository.ClonePath, cfg.Repository.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &harness{t: t, config: cfg, git: gitService, remote: remote}
+}
+
+func TestRepoSyncerStaleness(t *testing.T) {
+	h := newSyncerHarness(t)
+	cfg := *h.config
+	cfg.Repository.MaxStaleness = 200 * time.Millisecond
+	syncer := services.NewRepoSyncer(&cfg, h.git)
+	if err := syncer.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	before := syncer.Status().LastCommit
+	localFile := filepath.Join(h.config.Repository.ClonePath, "icey-storage", "remote.txt")
+
+	// 陈旧时间内读请求直接使用本地仓库
+	h.pushFromSeed("remote.txt")
+	if err := syncer.EnsureFresh(); err != nil {
+		t.Fatal(err)
+	}
+	if services.FileExists(localFile) || syncer.Status().LastCommit != before {
+		t.Fatal("陈旧时间内不应拉取")
+	}
+
+	// 超过陈旧时间后同步拉取
+	time.Sleep(250 * time.Millisecond)
+	if err := syncer.EnsureFresh(); err != nil {
+		t.Fatal(err)
+	}
+	if !services.FileExists(localFile) || syncer.Status().LastCommit == before {
+		t.Fatal("超过陈旧时间后应拉取远程变更")
+	}
+}
+
+func TestRepoSyncerLockBlocksPull(t *testing.T) {
+	h := newSyncerHarness(t)
+	syncer := services.NewRepoSyncer(h.config, h.git)
+	unlock, err := syncer.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 写请求持有仓库锁期间，后台拉取需等待
+	done := make(chan error, 1)
+	go func() { done <- syncer.Sync() }()
+	select {
+	case <-done:
+		t.Fatal("持有仓库锁时不应执行拉取")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("释放仓库锁后拉取应继续")
+	}
+}
+
+func TestRepoCheckerWaitsForRepoLock(t *testing.T) {
+	h := newSyncerHarness(t)
+	syncer := services.NewRepoSyncer(h.config, h.git)
+	checker := services.NewRepoChecker(h.config, h.git, syncer)
+	unlock, err := syncer.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 写请求持有仓库锁期间，检查需等待，不会把未提交的文件报告为工作区不干净
+	repoRoot := filepath.Join(h.config.Repository.ClonePath, "icey-storage")
+	if err := os.WriteFile(filepath.Join(repoRoot, "pending.txt"), []byte("pending"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan services.RepoHealth, 1)
+	go func() { done <- checker.Check() }()
+	select {
+	case <-done:
+		t.Fatal("持有仓库锁时不应执行检查")
+	case <-time.After(100 * time.Millisecond):
+	}
+	commitFile(t, repoRoot, "pending.txt", "pending", true)
+	unlock()
+	select {
+	case health := <-done:
+		if !health.Healthy {
+			t.Fatalf("写请求提交后检查应通过: %+v", health)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("释放仓库锁后检查应继续")
+	}
+}
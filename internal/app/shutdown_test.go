@@ -0,0 +1,108 @@
+package app_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"meea-icey/services"
+)
+
+// commitRecord 通过接口提交一条记录，返回本地仓库目录
+func (h *harness) commitRecord(name string) string {
+	h.t.Helper()
+	code := h.requestCode(name)
+	status, resp := h.post("/commit", map[string]string{"subject": services.SubjectHash(name), "content": "内容", "code": code})
+	if status != http.StatusOK || !resp.Success {
+		h.t.Fatalf("提交失败: status=%d, msg=%s", status, resp.Msg)
+	}
+	return filepath.Join(h.config.Repository.ClonePath, "icey-storage")
+}
+
+func TestDrainRejectsNewGitOperations(t *testing.T) {
+	h := newHarness(t)
+	repoRoot := h.commitRecord("张三")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := h.git.Drain(ctx); err != nil {
+		t.Fatalf("没有进行中的操作时应立即返回: %v", err)
+	}
+	if err := h.git.PullRepository(); !errors.Is(err, services.ErrGitDraining) {
+		t.Fatalf("关闭过程中拉取应被拒绝: %v", err)
+	}
+	os.WriteFile(filepath.Join(repoRoot, "late.txt"), []byte("x"), 0644)
+	if err := h.git.CommitChanges("icey-storage", []string{"late.txt"}, "late"); !errors.Is(err, services.ErrGitDraining) {
+		t.Fatalf("关闭过程中提交应被拒绝: %v", err)
+	}
+	if history := h.remoteHistory(); len(history) != 2 {
+		t.Fatalf("关闭过程中不应有新的推送: %q", history)
+	}
+}
+
+func TestReleaseLocks(t *testing.T) {
+	h := newHarness(t)
+	repoRoot := h.commitRecord("张三")
+	path := filepath.Join(repoRoot, "README.md")
+
+	if err := h.git.LockFile(path); err != nil {
+		t.Fatalf("加锁失败: %v", err)
+	}
+	if locked, _ := h.git.IsFileLocked(path); !locked {
+		t.Fatal("加锁后文件应处于锁定状态")
+	}
+	if failed := h.git.ReleaseLocks(); failed != 0 {
+		t.Fatalf("释放文件锁失败%d个", failed)
+	}
+	if locked, _ := h.git.IsFileLocked(path); locked {
+		t.Fatal("关闭时应释放持有的文件锁")
+	}
+	if err := h.git.LockFile(path); err != nil {
+		t.Fatalf("释放后应能重新加锁: %v", err)
+	}
+}
+
+func TestRecoverInterruptedWorktree(t *testing.T) {
+	h := newHarness(t)
+	repoRoot := h.commitRecord("张三")
+
+	// 模拟推送前被终止: 已提交未推送
+	r, err := git.PlainOpen(repoRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _ := r.Worktree()
+	os.WriteFile(filepath.Join(repoRoot, "pending.txt"), []byte("pending"), 0644)
+	w.Add("pending.txt")
+	if _, err := w.Commit("pending", &git.CommitOptions{Author: &object.Signature{Name: "t", Email: "t@example.com", When: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟写文件后、提交前被终止: 未跟踪文件和未提交的修改
+	os.MkdirAll(filepath.Join(repoRoot, "aa", "bb"), 0755)
+	os.WriteFile(filepath.Join(repoRoot, "aa", "bb", "orphan.sj"), []byte("orphan"), 0644)
+	os.WriteFile(filepath.Join(repoRoot, "README.md"), []byte("modified"), 0644)
+
+	if err := h.git.RecoverWorktree(); err != nil {
+		t.Fatalf("恢复工作区失败: %v", err)
+	}
+
+	status, _ := w.Status()
+	if !status.IsClean() {
+		t.Fatalf("恢复后工作区应干净: %v", status)
+	}
+	if _, err := os.Stat(filepath.Join(repoRoot, "aa", "bb", "orphan.sj")); !os.IsNotExist(err) {
+		t.Error("未提交的文件应被清理")
+	}
+	if data, _ := os.ReadFile(filepath.Join(repoRoot, "README.md")); string(data) != "icey-storage\n" {
+		t.Errorf("未提交的修改应被还原: %q", data)
+	}
+	if history := h.remoteHistory(); len(history) != 3 || history[0] != "pending" {
+		t.Fatalf("未推送的提交应被推送到远程: %q", history)
+	}
+}
@@ -0,0 +1,198 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"meea-icey/internal/logging"
+)
+
+var eventsLog = logging.Component("events")
+
+// Sink 事件接收端
+type Sink interface {
+	Name() string
+	Handle(ctx context.Context, e Event) error
+}
+
+// Persister 可以将未投递的事件持久化的接收端，队列已满或关闭时未投递的事件交由其保存，
+// 而不是丢弃
+type Persister interface {
+	Persist(e Event) error
+}
+
+// Runner 需要后台任务的接收端(如webhook重试队列)，随总线启动，ctx取消时退出
+type Runner interface {
+	Run(ctx context.Context)
+}
+
+// sinkQueue 每个接收端独立的投递队列，慢的接收端不影响其他接收端
+type sinkQueue struct {
+	sink  Sink
+	queue chan Event
+}
+
+// Bus 进程内事件总线，发布不阻塞请求，每个接收端由各自的后台任务按顺序投递
+type Bus struct {
+	buffer int
+
+	mu      sync.RWMutex
+	queues  []*sinkQueue
+	running bool
+	runCtx  context.Context
+	closed  bool
+
+	// deliverCtx 投递使用的上下文，关闭超时后取消，剩余事件转为持久化
+	deliverCtx    context.Context
+	cancelDeliver context.CancelFunc
+	workers       sync.WaitGroup // 各接收端的投递任务
+	runners       sync.WaitGroup // 接收端自身的后台任务
+	closeOnce     sync.Once
+}
+
+// NewBus 创建事件总线，buffer为每个接收端待投递事件的队列长度
+func NewBus(buffer int) *Bus {
+	if buffer <= 0 {
+		buffer = 1024
+	}
+	b := &Bus{buffer: buffer}
+	b.deliverCtx, b.cancelDeliver = context.WithCancel(context.Background())
+	return b
+}
+
+// Subscribe 注册接收端
+func (b *Bus) Subscribe(sink Sink) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	q := &sinkQueue{sink: sink, queue: make(chan Event, b.buffer)}
+	b.queues = append(b.queues, q)
+	if b.running {
+		b.start(q)
+	}
+}
+
+// Publish 发布事件到每个接收端的队列。某个接收端队列已满时，
+// 能持久化的接收端保存该事件，其余的丢弃并记录日志
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, q := range b.queues {
+		if b.closed {
+			b.persist(q.sink, e, "事件总线已关闭")
+			continue
+		}
+		select {
+		case q.queue <- e:
+		default:
+			b.persist(q.sink, e, "事件队列已满")
+		}
+	}
+}
+
+// Run 启动各接收端的投递任务，ctx取消后停止接收新事件，投递完已排队的事件后返回
+func (b *Bus) Run(ctx context.Context) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.running = true
+	b.runCtx = ctx
+	for _, q := range b.queues {
+		b.start(q)
+	}
+	b.mu.Unlock()
+
+	<-ctx.Done()
+	b.close()
+	b.workers.Wait()
+	b.runners.Wait()
+}
+
+// Shutdown 停止接收新事件并等待已排队的事件投递完成；
+// ctx到期时中止投递，剩余事件交由接收端持久化，无法持久化的记录日志
+func (b *Bus) Shutdown(ctx context.Context) error {
+	b.close()
+	done := make(chan struct{})
+	go func() {
+		b.workers.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		b.cancelDeliver()
+		<-done
+		err = fmt.Errorf("等待事件投递超时: %v", ctx.Err())
+	}
+
+	// 总线未运行时队列中的事件没有被消费
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, q := range b.queues {
+		for e := range q.queue {
+			b.persist(q.sink, e, "事件总线已关闭")
+		}
+	}
+	return err
+}
+
+func (b *Bus) close() {
+	b.closeOnce.Do(func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.closed = true
+		for _, q := range b.queues {
+			close(q.queue)
+		}
+	})
+}
+
+// start 启动接收端的投递任务和后台任务，调用方需持有mu
+func (b *Bus) start(q *sinkQueue) {
+	if r, ok := q.sink.(Runner); ok {
+		b.runners.Add(1)
+		go func() {
+			defer b.runners.Done()
+			r.Run(b.runCtx)
+		}()
+	}
+	b.workers.Add(1)
+	go func() {
+		defer b.workers.Done()
+		for e := range q.queue {
+			if b.deliverCtx.Err() != nil {
+				b.persist(q.sink, e, "关闭超时")
+				continue
+			}
+			if err := q.sink.Handle(b.deliverCtx, e); err != nil {
+				// 关闭超时中断的投递同样交由接收端持久化
+				if b.deliverCtx.Err() != nil {
+					b.persist(q.sink, e, "关闭超时")
+					continue
+				}
+				eventsLog.Warn("事件投递失败", "sink", q.sink.Name(), "type", e.Type, "id", e.ID, "error", err)
+			}
+		}
+	}()
+}
+
+// persist 无法投递的事件交由接收端持久化，不支持时丢弃
+func (b *Bus) persist(sink Sink, e Event, reason string) {
+	p, ok := sink.(Persister)
+	if !ok {
+		eventsLog.Warn("丢弃事件", "reason", reason, "sink", sink.Name(), "type", e.Type, "id", e.ID)
+		return
+	}
+	if err := p.Persist(e); err != nil {
+		eventsLog.Warn("持久化事件失败，丢弃事件", "reason", reason, "sink", sink.Name(), "type", e.Type, "id", e.ID, "error", err)
+	}
+}
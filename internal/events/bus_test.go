@@ -0,0 +1,146 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordSink 记录收到的事件，block非空时每次投递等待其关闭
+type recordSink struct {
+	name  string
+	block chan struct{}
+
+	mu        sync.Mutex
+	got       []string
+	persisted []string
+}
+
+func (s *recordSink) Name() string { return s.name }
+
+func (s *recordSink) Handle(ctx context.Context, e Event) error {
+	if s.block != nil {
+		select {
+		case <-s.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.got = append(s.got, e.ID)
+	return nil
+}
+
+func (s *recordSink) received() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.got)
+}
+
+// persistSink 支持持久化的接收端
+type persistSink struct{ *recordSink }
+
+func (s persistSink) Persist(e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.persisted = append(s.persisted, e.ID)
+	return nil
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("等待超时")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBusFanOut(t *testing.T) {
+	bus := NewBus(16)
+	slow := &recordSink{name: "slow", block: make(chan struct{})}
+	fast := &recordSink{name: "fast"}
+	bus.Subscribe(slow)
+	bus.Subscribe(fast)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { bus.Run(ctx); close(done) }()
+
+	// 慢的接收端不影响其他接收端
+	for i := 0; i < 5; i++ {
+		bus.Publish(New(RecordVoted, "subject", "r1", nil))
+	}
+	waitFor(t, func() bool { return fast.received() == 5 })
+	if slow.received() != 0 {
+		t.Fatal("慢接收端不应已收到事件")
+	}
+
+	// 退出时投递完已排队的事件
+	close(slow.block)
+	cancel()
+	<-done
+	if slow.received() != 5 {
+		t.Errorf("退出前应投递全部事件, 实际%d", slow.received())
+	}
+}
+
+func TestBusQueueFull(t *testing.T) {
+	bus := NewBus(1)
+	dropped := &recordSink{name: "dropped", block: make(chan struct{})}
+	kept := persistSink{&recordSink{name: "kept", block: make(chan struct{})}}
+	bus.Subscribe(dropped)
+	bus.Subscribe(kept)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bus.Run(ctx)
+
+	// 第一个事件被取出后阻塞在投递中，第二个占满队列，第三个溢出
+	bus.Publish(New(RecordCreated, "subject", "r1", nil))
+	time.Sleep(20 * time.Millisecond)
+	bus.Publish(New(RecordCreated, "subject", "r2", nil))
+	overflow := New(RecordCreated, "subject", "r3", nil)
+	bus.Publish(overflow)
+
+	kept.mu.Lock()
+	persisted := append([]string(nil), kept.persisted...)
+	kept.mu.Unlock()
+	if len(persisted) != 1 || persisted[0] != overflow.ID {
+		t.Fatalf("队列满时应持久化溢出的事件: %v", persisted)
+	}
+	close(dropped.block)
+	close(kept.block)
+}
+
+func TestBusShutdownPersistsPending(t *testing.T) {
+	bus := NewBus(16)
+	sink := persistSink{&recordSink{name: "webhook", block: make(chan struct{})}}
+	bus.Subscribe(sink)
+	go bus.Run(context.Background())
+	waitFor(t, func() bool {
+		bus.mu.RLock()
+		defer bus.mu.RUnlock()
+		return bus.running
+	})
+	for i := 0; i < 3; i++ {
+		bus.Publish(New(RecordDeleted, "subject", "r1", nil))
+	}
+
+	// 投递阻塞时关闭超时，未投递的事件全部交由接收端持久化
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := bus.Shutdown(ctx); err == nil {
+		t.Error("关闭超时应返回错误")
+	}
+	// 关闭后发布的事件直接持久化
+	bus.Publish(New(RecordCreated, "subject", "r2", nil))
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.got) != 0 || len(sink.persisted) != 4 {
+		t.Fatalf("未投递的事件应被持久化: got=%v persisted=%v", sink.got, sink.persisted)
+	}
+}
@@ -0,0 +1,45 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Type 事件类型
+type Type string
+
+const (
+	RecordCreated Type = "record.created"
+	RecordVoted   Type = "record.voted"
+	RecordEdited  Type = "record.edited" // 预留，当前没有编辑接口
+	RecordDeleted Type = "record.deleted"
+)
+
+// Event 记录生命周期事件
+type Event struct {
+	ID       string                 `json:"id"`
+	Type     Type                   `json:"type"`
+	Subject  string                 `json:"subject"`
+	RecordID string                 `json:"record_id"`
+	At       time.Time              `json:"at"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+}
+
+// New 创建事件并生成唯一ID
+func New(t Type, subject, recordID string, data map[string]interface{}) Event {
+	return Event{
+		ID:       newID(),
+		Type:     t,
+		Subject:  subject,
+		RecordID: recordID,
+		At:       time.Now().UTC(),
+		Data:     data,
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
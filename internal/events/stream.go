@@ -0,0 +1,51 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// StreamSink 将事件追加到Redis Stream，供下游以消费组方式读取
+type StreamSink struct {
+	redisClient *redis.Client
+	key         string
+	maxLen      int64
+}
+
+func NewStreamSink(redisClient *redis.Client, key string, maxLen int64) *StreamSink {
+	if key == "" {
+		key = "icey:events"
+	}
+	return &StreamSink{redisClient: redisClient, key: key, maxLen: maxLen}
+}
+
+func (s *StreamSink) Name() string { return "redis-stream" }
+
+func (s *StreamSink) Handle(ctx context.Context, e Event) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return fmt.Errorf("序列化事件数据失败: %v", err)
+	}
+	args := &redis.XAddArgs{
+		Stream: s.key,
+		Values: map[string]interface{}{
+			"id":        e.ID,
+			"type":      string(e.Type),
+			"subject":   e.Subject,
+			"record_id": e.RecordID,
+			"at":        e.At.UnixMilli(),
+			"data":      string(data),
+		},
+	}
+	if s.maxLen > 0 {
+		args.MaxLen = s.maxLen
+		args.Approx = true
+	}
+	if err := s.redisClient.XAdd(ctx, args).Err(); err != nil {
+		return fmt.Errorf("写入Redis Stream失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// WebhookOptions 出站webhook配置
+type WebhookOptions struct {
+	URLs          []string
+	Secret        string
+	MaxRetries    int
+	RetryBackoff  time.Duration
+	Timeout       time.Duration
+	DeadLetterKey string
+	RetryKey      string
+}
+
+// 重试队列的轮询间隔和每次处理的最大条数
+const (
+	retryPollInterval = time.Second
+	retryBatch        = 100
+)
+
+// deadLetter 投递失败的事件，写入Redis死信队列供人工重放
+type deadLetter struct {
+	Event    Event  `json:"event"`
+	URL      string `json:"url"`
+	Error    string `json:"error"`
+	FailedAt int64  `json:"failed_at"`
+}
+
+// retryItem 等待重试的投递，按到期时间存放在Redis有序集合中，多个实例共享
+type retryItem struct {
+	Event   Event  `json:"event"`
+	URL     string `json:"url"`
+	Attempt int    `json:"attempt"` // 已失败的次数
+}
+
+// WebhookSink 将事件以HMAC签名的JSON POST到配置的地址。投递时只请求一次，
+// 失败的投递写入Redis重试队列，由后台任务按指数退避重试，重试耗尽后写入死信队列
+type WebhookSink struct {
+	opts        WebhookOptions
+	client      *http.Client
+	redisClient *redis.Client
+}
+
+func NewWebhookSink(opts WebhookOptions, redisClient *redis.Client) *WebhookSink {
+	if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = 2 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.DeadLetterKey == "" {
+		opts.DeadLetterKey = "icey:events:dlq"
+	}
+	if opts.RetryKey == "" {
+		opts.RetryKey = "icey:events:retry"
+	}
+	return &WebhookSink{
+		opts:        opts,
+		client:      &http.Client{Timeout: opts.Timeout},
+		redisClient: redisClient,
+	}
+}
+
+func (s *WebhookSink) Name() string { return "webhook" }
+
+func (s *WebhookSink) Handle(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("序列化事件失败: %v", err)
+	}
+	var firstErr error
+	for _, url := range s.opts.URLs {
+		if err := s.post(ctx, url, e, body); err != nil {
+			// 总线关闭中断的投递由总线调用Persist保存
+			if ctx.Err() != nil {
+				return err
+			}
+			if qerr := s.fail(retryItem{Event: e, URL: url}, err); qerr != nil {
+				err = fmt.Errorf("%v; %v", err, qerr)
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// Persist 未能投递的事件直接写入重试队列，立即到期
+func (s *WebhookSink) Persist(e Event) error {
+	for _, url := range s.opts.URLs {
+		if err := s.schedule(retryItem{Event: e, URL: url}, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run 定期处理重试队列中到期的投递，ctx取消时退出
+func (s *WebhookSink) Run(ctx context.Context) {
+	if s.redisClient == nil {
+		return
+	}
+	ticker := time.NewTicker(retryPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.retryDue(ctx); err != nil {
+			eventsLog.Warn("处理webhook重试队列失败", "error", err)
+		}
+	}
+}
+
+// retryDue 取出到期的投递逐条重试；ZRem成功才算领取，多个实例不会重复投递
+func (s *WebhookSink) retryDue(ctx context.Context) error {
+	members, err := s.redisClient.ZRangeByScore(ctx, s.opts.RetryKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Count: retryBatch,
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("读取重试队列失败: %v", err)
+	}
+	for _, member := range members {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if n, err := s.redisClient.ZRem(ctx, s.opts.RetryKey, member).Result(); err != nil || n == 0 {
+			continue
+		}
+		var item retryItem
+		if err := json.Unmarshal([]byte(member), &item); err != nil {
+			eventsLog.Warn("丢弃无法解析的重试记录", "error", err)
+			continue
+		}
+		body, err := json.Marshal(item.Event)
+		if err != nil {
+			continue
+		}
+		err = s.post(ctx, item.URL, item.Event, body)
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			// 退出时中断的投递放回队列，不计入失败次数
+			err = s.schedule(item, time.Now())
+		} else {
+			err = s.fail(item, err)
+		}
+		if err != nil {
+			eventsLog.Warn("webhook投递失败", "type", item.Event.Type, "id", item.Event.ID, "error", err)
+		}
+	}
+	return nil
+}
+
+// fail 记录一次失败：未超过重试次数时按指数退避放入重试队列，否则写入死信队列
+func (s *WebhookSink) fail(item retryItem, cause error) error {
+	if s.redisClient == nil {
+		return nil
+	}
+	item.Attempt++
+	if item.Attempt > s.opts.MaxRetries {
+		if err := s.deadLetter(item.URL, item.Event, cause); err != nil {
+			return fmt.Errorf("写入死信队列失败: %v", err)
+		}
+		return nil
+	}
+	backoff := s.opts.RetryBackoff << (item.Attempt - 1)
+	if err := s.schedule(item, time.Now().Add(backoff)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// schedule 将投递放入重试队列，到期时间为at
+func (s *WebhookSink) schedule(item retryItem, at time.Time) error {
+	if s.redisClient == nil {
+		return fmt.Errorf("未配置Redis，无法保存重试记录")
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	err = s.redisClient.ZAdd(context.Background(), s.opts.RetryKey, &redis.Z{Score: float64(at.UnixMilli()), Member: data}).Err()
+	if err != nil {
+		return fmt.Errorf("写入重试队列失败: %v", err)
+	}
+	return nil
+}
+
+// Sign 计算请求体签名，接收方用相同密钥校验 X-Icey-Signature
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *WebhookSink) post(ctx context.Context, url string, e Event, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Icey-Event", string(e.Type))
+	req.Header.Set("X-Icey-Delivery", e.ID)
+	if s.opts.Secret != "" {
+		req.Header.Set("X-Icey-Signature", Sign(s.opts.Secret, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("响应状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *WebhookSink) deadLetter(url string, e Event, cause error) error {
+	data, err := json.Marshal(deadLetter{Event: e, URL: url, Error: cause.Error(), FailedAt: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+	return s.redisClient.RPush(context.Background(), s.opts.DeadLetterKey, data).Err()
+}
@@ -0,0 +1,78 @@
+package events
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestWebhookRetryQueue(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	var calls, failures atomic.Int32
+	failures.Store(2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Header.Get("X-Icey-Signature") == "" {
+			t.Error("请求应带签名")
+		}
+		if failures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(WebhookOptions{
+		URLs:         []string{server.URL},
+		Secret:       "secret",
+		MaxRetries:   2,
+		RetryBackoff: time.Millisecond,
+	}, client)
+	ctx := context.Background()
+
+	// 首次投递失败只请求一次，写入重试队列后立即返回
+	if err := sink.Handle(ctx, New(RecordVoted, "subject", "r1", nil)); err == nil {
+		t.Fatal("投递失败应返回错误")
+	}
+	if calls.Load() != 1 || client.ZCard(ctx, "icey:events:retry").Val() != 1 {
+		t.Fatalf("失败的投递应进入重试队列: calls=%d", calls.Load())
+	}
+
+	// 后台按退避重试直到成功
+	for i := 0; i < 2; i++ {
+		time.Sleep(5 * time.Millisecond)
+		if err := sink.retryDue(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls.Load() != 3 || client.ZCard(ctx, "icey:events:retry").Val() != 0 {
+		t.Fatalf("重试成功后应移出队列: calls=%d", calls.Load())
+	}
+
+	// 重试耗尽后写入死信队列
+	failures.Store(10)
+	sink.Handle(ctx, New(RecordDeleted, "subject", "r2", nil))
+	for i := 0; i < 3; i++ {
+		time.Sleep(5 * time.Millisecond)
+		sink.retryDue(ctx)
+	}
+	if client.ZCard(ctx, "icey:events:retry").Val() != 0 || client.LLen(ctx, "icey:events:dlq").Val() != 1 {
+		t.Fatalf("重试耗尽应写入死信队列: retry=%d dlq=%d", client.ZCard(ctx, "icey:events:retry").Val(), client.LLen(ctx, "icey:events:dlq").Val())
+	}
+
+	// 关闭时未投递的事件写入重试队列
+	if err := sink.Persist(New(RecordCreated, "subject", "r3", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if client.ZCard(ctx, "icey:events:retry").Val() != 1 {
+		t.Error("持久化的事件应进入重试队列")
+	}
+}
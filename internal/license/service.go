@@ -3,9 +3,11 @@ package license
 import (
 	"encoding/json"
 	"regexp"
+	"strings"
 	"time"
 
 	"meea-icey/internal/crypto"
+	"meea-icey/internal/metrics"
 	"meea-icey/internal/verification"
 )
 
@@ -21,7 +23,18 @@ func NewService(cryptoService *crypto.Service, verificationService verification.
 	}
 }
 
+// ProcessLicenseRequest 处理许可证申请，按结果计数(成功为issued，失败为错误码)
 func (s *Service) ProcessLicenseRequest(encryptedData string) *LicenseResponse {
+	resp := s.processLicenseRequest(encryptedData)
+	outcome := "issued"
+	if !resp.Success {
+		outcome = strings.ToLower(resp.Code)
+	}
+	metrics.License(outcome)
+	return resp
+}
+
+func (s *Service) processLicenseRequest(encryptedData string) *LicenseResponse {
 	// 1. 解密客户端数据
 	decryptedString, err := s.cryptoService.DecryptClientData(encryptedData)
 	if err != nil {
@@ -0,0 +1,161 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// New 根据级别(debug/info/warn/error)和格式(json/text)创建logger，
+// 日志中自动带上通过With/WithSubject附加到context的请求字段，所有字段和消息输出前经过Redact脱敏
+func New(level, format string, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: ParseLevel(level), ReplaceAttr: Redact}
+	var h slog.Handler
+	if strings.EqualFold(format, "json") {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(&contextHandler{Handler: h})
+}
+
+// Setup 创建输出到标准输出的logger并设为默认；标准库log的输出同时转到该logger(info级别)
+func Setup(level, format string) *slog.Logger {
+	logger := New(level, format, os.Stdout)
+	slog.SetDefault(logger)
+	log.SetFlags(0)
+	return logger
+}
+
+// ParseLevel 解析日志级别，无法识别时为info
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+type ctxKey struct{}
+
+// With 返回附加了请求字段的context，之后以该context记录的日志都会带上这些字段
+func With(ctx context.Context, args ...any) context.Context {
+	if len(args) == 0 {
+		return ctx
+	}
+	attrs := append(attrsFrom(ctx), argsToAttrs(args)...)
+	return context.WithValue(ctx, ctxKey{}, attrs)
+}
+
+// WithSubject 附加主题哈希前缀；日志中只保留前8位，足以定位记录又不暴露完整主题
+func WithSubject(ctx context.Context, subjectHash string) context.Context {
+	if len(subjectHash) > 8 {
+		subjectHash = subjectHash[:8]
+	}
+	if subjectHash == "" {
+		return ctx
+	}
+	return With(ctx, "subject", subjectHash)
+}
+
+func attrsFrom(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(ctxKey{}).([]slog.Attr)
+	// 复制一份，避免多个子context共享底层数组
+	return append([]slog.Attr(nil), attrs...)
+}
+
+func argsToAttrs(args []any) []slog.Attr {
+	var attrs []slog.Attr
+	for len(args) > 0 {
+		switch v := args[0].(type) {
+		case slog.Attr:
+			attrs = append(attrs, v)
+			args = args[1:]
+		case string:
+			if len(args) == 1 {
+				attrs = append(attrs, slog.String("!BADKEY", v))
+				args = nil
+				continue
+			}
+			attrs = append(attrs, slog.Any(v, args[1]))
+			args = args[2:]
+		default:
+			attrs = append(attrs, slog.Any("!BADKEY", v))
+			args = args[1:]
+		}
+	}
+	return attrs
+}
+
+// contextHandler 从context中取出请求字段附加到每条日志；
+// 消息文本不经过ReplaceAttr，在这里清理(标准库log转来的日志整行都在消息中)
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.Message = RedactString(r.Message)
+	if attrs := attrsFrom(ctx); len(attrs) > 0 {
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// Component 返回带component字段的logger；实际输出在记录时交给当前的默认logger，
+// 因此可以在包级变量中创建，不受Setup调用先后的影响
+func Component(name string) *slog.Logger {
+	return slog.New(&deferredHandler{}).With("component", name)
+}
+
+// deferredHandler 记录时才取slog.Default()的Handler
+type deferredHandler struct {
+	ops []func(slog.Handler) slog.Handler
+}
+
+func (h *deferredHandler) target() slog.Handler {
+	t := slog.Default().Handler()
+	for _, op := range h.ops {
+		t = op(t)
+	}
+	return t
+}
+
+func (h *deferredHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return slog.Default().Handler().Enabled(ctx, level)
+}
+
+func (h *deferredHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.target().Handle(ctx, r)
+}
+
+func (h *deferredHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(t slog.Handler) slog.Handler { return t.WithAttrs(attrs) })
+}
+
+func (h *deferredHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(t slog.Handler) slog.Handler { return t.WithGroup(name) })
+}
+
+func (h *deferredHandler) with(op func(slog.Handler) slog.Handler) slog.Handler {
+	ops := append(append([]func(slog.Handler) slog.Handler(nil), h.ops...), op)
+	return &deferredHandler{ops: ops}
+}
@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// capture 将默认logger替换为写入缓冲区的JSON logger，测试结束后恢复
+func capture(t *testing.T, level string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(New(level, "json", &buf))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+// entries 按行解析JSON日志
+func entries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var out []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("日志不是JSON: %s", line)
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+func TestContextFields(t *testing.T) {
+	buf := capture(t, "info")
+	ctx := With(context.Background(), "request_id", "abc")
+	ctx = WithSubject(ctx, "0123456789abcdef")
+	Component("sync").InfoContext(ctx, "测试")
+	Component("sync").Debug("低于配置级别")
+
+	logs := entries(t, buf)
+	if len(logs) != 1 {
+		t.Fatalf("应只输出一条日志: %s", buf.String())
+	}
+	entry := logs[0]
+	if entry["request_id"] != "abc" || entry["subject"] != "01234567" || entry["component"] != "sync" {
+		t.Errorf("缺少请求字段: %v", entry)
+	}
+}
+
+func TestMiddlewareRequestID(t *testing.T) {
+	buf := capture(t, "info")
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/items/:id", func(c *gin.Context) {
+		slog.InfoContext(c.Request.Context(), "处理中")
+		c.Status(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/items/1", nil)
+	req.Header.Set(RequestIDHeader, "from-proxy")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Header().Get(RequestIDHeader) != "from-proxy" {
+		t.Errorf("应沿用请求头中的请求ID: %q", rec.Header().Get(RequestIDHeader))
+	}
+
+	logs := entries(t, buf)
+	if len(logs) != 2 {
+		t.Fatalf("应输出处理日志和访问日志: %s", buf.String())
+	}
+	for _, entry := range logs {
+		if entry["request_id"] != "from-proxy" || entry["route"] != "/items/:id" {
+			t.Errorf("缺少请求字段: %v", entry)
+		}
+	}
+	if logs[1]["status"] != float64(http.StatusNoContent) {
+		t.Errorf("访问日志状态码不符: %v", logs[1])
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/items/2", nil))
+	if id := rec.Header().Get(RequestIDHeader); len(id) != 16 {
+		t.Errorf("未提供请求ID时应生成: %q", id)
+	}
+}
@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader 请求ID的请求头和响应头，反向代理已设置时沿用
+const RequestIDHeader = "X-Request-ID"
+
+// 探针和指标接口的访问日志降为debug，避免刷屏
+var quietRoutes = map[string]bool{"/health": true, "/healthz": true, "/readyz": true, "/metrics": true}
+
+// Middleware 为每个请求生成请求ID，将请求ID和路由附加到请求的context，请求结束后记录访问日志
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > 64 {
+			id = newRequestID()
+		}
+		c.Header(RequestIDHeader, id)
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx := With(c.Request.Context(), "request_id", id, "route", route)
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case quietRoutes[route]:
+			level = slog.LevelDebug
+		}
+		// 使用c.Request的context，处理函数附加的主题等字段也会记录
+		slog.LogAttrs(c.Request.Context(), level, "请求完成",
+			slog.String("method", c.Request.Method),
+			slog.Int("status", status),
+			slog.Int64("latency_ms", time.Since(start).Milliseconds()),
+			slog.String("client_ip", c.ClientIP()),
+		)
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,137 @@
+package logging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// 脱敏后的占位值
+const redacted = "[REDACTED]"
+
+type fieldKind int
+
+const (
+	fieldSecret  fieldKind = iota // 验证码、token、签名、密钥等，整体替换
+	fieldOpenID                   // 微信用户标识，替换为哈希前缀，仍可关联同一用户的日志
+	fieldContent                  // 记录内容、消息正文等，只保留长度
+)
+
+// sensitiveFields 按字段名(不区分大小写)确定脱敏方式；分组内的字段同样适用
+var sensitiveFields = map[string]fieldKind{
+	"code":             fieldSecret,
+	"verify_code":      fieldSecret,
+	"token":            fieldSecret,
+	"access_token":     fieldSecret,
+	"delete_token":     fieldSecret,
+	"secret":           fieldSecret,
+	"app_secret":       fieldSecret,
+	"password":         fieldSecret,
+	"signature":        fieldSecret,
+	"msg_signature":    fieldSecret,
+	"echostr":          fieldSecret,
+	"encoding_aes_key": fieldSecret,
+	"aes_key":          fieldSecret,
+	"private_key":      fieldSecret,
+	"ssh_key":          fieldSecret,
+	"key_path":         fieldSecret,
+	"authorization":    fieldSecret,
+	"totp_secret":      fieldSecret,
+
+	"openid":    fieldOpenID,
+	"from_user": fieldOpenID,
+	"to_user":   fieldOpenID,
+
+	"content":   fieldContent,
+	"body":      fieldContent,
+	"plaintext": fieldContent,
+	"payload":   fieldContent,
+}
+
+// sensitivePatterns 对所有字符串值、错误信息和日志消息生效，兜底字段名无法识别的情况
+var sensitivePatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// 验证码的Redis键 icey:subject:<主题哈希>:<验证码>
+	{regexp.MustCompile(`(icey:subject:[0-9a-fA-F]+:)[^\s:"']+`), "${1}" + redacted},
+	// 微信接口URL或JSON中的access_token
+	{regexp.MustCompile(`(access_token=)[^&\s"']+`), "${1}" + redacted},
+	{regexp.MustCompile(`("access_token"\s*:\s*")[^"]*`), "${1}" + redacted},
+	// PEM格式的私钥
+	{regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?(-----END [A-Z ]*PRIVATE KEY-----|$)`), redacted},
+	// ssh -i <密钥路径>
+	{regexp.MustCompile(`(ssh -i\s+)('[^']*'|\S+)`), "${1}" + redacted},
+}
+
+// Redact 对一个日志字段脱敏，用作slog.HandlerOptions.ReplaceAttr
+func Redact(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+		return a
+	}
+	if kind, ok := sensitiveFields[strings.ToLower(a.Key)]; ok {
+		return slog.Attr{Key: a.Key, Value: redactField(kind, a.Value)}
+	}
+	return slog.Attr{Key: a.Key, Value: scrubValue(a.Value)}
+}
+
+// RedactString 去除字符串中的验证码键、access_token、私钥等敏感片段
+func RedactString(s string) string {
+	for _, p := range sensitivePatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+func redactField(kind fieldKind, v slog.Value) slog.Value {
+	s := valueString(v)
+	if s == "" {
+		return v
+	}
+	switch kind {
+	case fieldOpenID:
+		sum := sha256.Sum256([]byte(s))
+		return slog.StringValue("#" + hex.EncodeToString(sum[:4]))
+	case fieldContent:
+		return slog.StringValue(fmt.Sprintf("[%d字节]", len(s)))
+	default:
+		return slog.StringValue(redacted)
+	}
+}
+
+// scrubValue 字符串和错误按敏感片段清理，其他类型的值原样保留
+func scrubValue(v slog.Value) slog.Value {
+	switch v.Kind() {
+	case slog.KindString:
+		if s := v.String(); s != RedactString(s) {
+			return slog.StringValue(RedactString(s))
+		}
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case error:
+			return slog.StringValue(RedactString(x.Error()))
+		case []byte:
+			return slog.StringValue(RedactString(string(x)))
+		case fmt.Stringer:
+			return slog.StringValue(RedactString(x.String()))
+		}
+	}
+	return v
+}
+
+func valueString(v slog.Value) string {
+	if v.Kind() == slog.KindAny {
+		switch x := v.Any().(type) {
+		case []byte:
+			return string(x)
+		case error:
+			return x.Error()
+		case nil:
+			return ""
+		}
+	}
+	return v.String()
+}
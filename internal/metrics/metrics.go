@@ -0,0 +1,204 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "icey"
+
+// Registry 本服务的指标注册表，不使用全局默认注册表，便于测试中重复创建App
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP请求数，按路由、方法和状态码区分",
+	}, []string{"route", "method", "status"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP请求耗时",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	verifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "verifications_total",
+		Help:      "验证码校验次数，result为success/failure/error",
+	}, []string{"result"})
+
+	gitDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "git_operation_duration_seconds",
+		Help:      "Git操作耗时，op为clone/pull/fetch/commit/push",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"op"})
+	gitErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "git_operation_errors_total",
+		Help:      "Git操作失败次数",
+	}, []string{"op"})
+
+	lockDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "lock_acquire_duration_seconds",
+		Help:      "文件加锁耗时，result为acquired/contended/error",
+		Buckets:   []float64{0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+	}, []string{"result"})
+	lockContention = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lock_contention_total",
+		Help:      "文件已被其他请求或实例锁定的次数",
+	})
+
+	votes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "votes_total",
+		Help:      "已提交的投票数，vote为true/false",
+	}, []string{"vote"})
+	voteMigrations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "vote_bitmap_migrations_total",
+		Help:      "投票时就地转换的旧版bitmap记录数",
+	})
+
+	licenses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "license_requests_total",
+		Help:      "许可证申请数，按结果区分",
+	}, []string{"outcome"})
+
+	wechatCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "wechat_api_calls_total",
+		Help:      "微信接口调用次数，result为ok/errcode/error",
+	}, []string{"api", "result"})
+	wechatErrcodes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "wechat_api_errcodes_total",
+		Help:      "微信接口返回的非零错误码",
+	}, []string{"api", "errcode"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration,
+		verifications,
+		gitDuration, gitErrors,
+		lockDuration, lockContention,
+		votes, voteMigrations,
+		licenses,
+		wechatCalls, wechatErrcodes,
+	)
+}
+
+// Handler 返回/metrics接口
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Protect token非空时要求请求携带 Authorization: Bearer <token>，否则返回401
+func Protect(h http.Handler, token string) http.Handler {
+	if token == "" {
+		return h
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Middleware 记录请求数和耗时；路由取注册的路径模板，未匹配的请求统一记为unmatched，避免标签基数失控
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequests.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(route, c.Request.Method).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Verification 记录一次验证码校验结果
+func Verification(ok bool, err error) {
+	switch {
+	case err != nil:
+		verifications.WithLabelValues("error").Inc()
+	case ok:
+		verifications.WithLabelValues("success").Inc()
+	default:
+		verifications.WithLabelValues("failure").Inc()
+	}
+}
+
+// GitOperation 记录一次Git操作的耗时和结果
+func GitOperation(op string, start time.Time, err error) {
+	gitDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil {
+		gitErrors.WithLabelValues(op).Inc()
+	}
+}
+
+// LockAcquire 记录一次加锁的耗时；contended表示文件已被占用
+func LockAcquire(start time.Time, contended bool, err error) {
+	result := "acquired"
+	switch {
+	case contended:
+		result = "contended"
+		lockContention.Inc()
+	case err != nil:
+		result = "error"
+	}
+	lockDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+}
+
+// Vote 记录一次成功的投票
+func Vote(value uint8) {
+	label := "false"
+	if value == 1 {
+		label = "true"
+	}
+	votes.WithLabelValues(label).Inc()
+}
+
+// VoteMigration 记录就地转换的旧版bitmap记录
+func VoteMigration() {
+	voteMigrations.Inc()
+}
+
+// License 记录一次许可证申请结果
+func License(outcome string) {
+	licenses.WithLabelValues(outcome).Inc()
+}
+
+// WechatAPI 记录一次微信接口调用；errcode非零表示接口返回了业务错误
+func WechatAPI(api string, errcode int, err error) {
+	switch {
+	case errcode != 0:
+		wechatCalls.WithLabelValues(api, "errcode").Inc()
+		wechatErrcodes.WithLabelValues(api, strconv.Itoa(errcode)).Inc()
+	case err != nil:
+		wechatCalls.WithLabelValues(api, "error").Inc()
+	default:
+		wechatCalls.WithLabelValues(api, "ok").Inc()
+	}
+}
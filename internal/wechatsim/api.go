@@ -0,0 +1,157 @@
+package wechatsim
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// CustomMessage 通过客服消息接口发送的消息
+type CustomMessage struct {
+	ToUser  string
+	MsgType string
+	Content string
+}
+
+// API 模拟微信公众平台的主动调用接口，将 wechat.api_base_url 指向 URL() 即可使用
+type API struct {
+	AppID     string
+	AppSecret string
+	// ExpiresIn 签发的access_token有效期(秒)
+	ExpiresIn int
+
+	server *httptest.Server
+
+	mu            sync.Mutex
+	token         string
+	tokenRequests int
+	messages      []CustomMessage
+	menus         [][]byte
+}
+
+// NewAPI 启动模拟接口服务，测试结束时调用Close
+func NewAPI(appID, appSecret string) *API {
+	a := &API{AppID: appID, AppSecret: appSecret, ExpiresIn: 7200}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cgi-bin/token", a.handleToken)
+	mux.HandleFunc("/cgi-bin/message/custom/send", a.handleCustomSend)
+	mux.HandleFunc("/cgi-bin/menu/create", a.handleMenuCreate)
+	a.server = httptest.NewServer(mux)
+	return a
+}
+
+// URL 模拟接口的地址
+func (a *API) URL() string {
+	return a.server.URL
+}
+
+// Close 关闭模拟接口服务
+func (a *API) Close() {
+	a.server.Close()
+}
+
+// TokenRequests 获取access_token接口被调用的次数
+func (a *API) TokenRequests() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.tokenRequests
+}
+
+// Messages 已收到的客服消息
+func (a *API) Messages() []CustomMessage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]CustomMessage(nil), a.messages...)
+}
+
+// Menus 已收到的菜单定义
+func (a *API) Menus() [][]byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([][]byte(nil), a.menus...)
+}
+
+// RevokeToken 使当前access_token失效，之后携带它的调用返回40001，模拟在其他地方被刷新
+func (a *API) RevokeToken() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.token = ""
+}
+
+func (a *API) handleToken(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("grant_type") != "client_credential" || q.Get("appid") != a.AppID || q.Get("secret") != a.AppSecret {
+		writeJSON(w, map[string]interface{}{"errcode": 40013, "errmsg": "invalid appid"})
+		return
+	}
+	a.mu.Lock()
+	a.tokenRequests++
+	// 每次获取都签发新token，旧token立即失效，与微信接口行为一致
+	a.token = fmt.Sprintf("sim-token-%d", a.tokenRequests)
+	token := a.token
+	a.mu.Unlock()
+	writeJSON(w, map[string]interface{}{"access_token": token, "expires_in": a.ExpiresIn})
+}
+
+func (a *API) handleCustomSend(w http.ResponseWriter, r *http.Request) {
+	body, ok := a.authorize(w, r)
+	if !ok {
+		return
+	}
+	var msg struct {
+		ToUser  string `json:"touser"`
+		MsgType string `json:"msgtype"`
+		Text    struct {
+			Content string `json:"content"`
+		} `json:"text"`
+	}
+	if err := json.Unmarshal(body, &msg); err != nil || msg.ToUser == "" {
+		writeJSON(w, map[string]interface{}{"errcode": 44002, "errmsg": "empty post data"})
+		return
+	}
+	a.mu.Lock()
+	a.messages = append(a.messages, CustomMessage{ToUser: msg.ToUser, MsgType: msg.MsgType, Content: msg.Text.Content})
+	a.mu.Unlock()
+	writeJSON(w, map[string]interface{}{"errcode": 0, "errmsg": "ok"})
+}
+
+func (a *API) handleMenuCreate(w http.ResponseWriter, r *http.Request) {
+	body, ok := a.authorize(w, r)
+	if !ok {
+		return
+	}
+	if !json.Valid(body) {
+		writeJSON(w, map[string]interface{}{"errcode": 40016, "errmsg": "invalid button size"})
+		return
+	}
+	a.mu.Lock()
+	a.menus = append(a.menus, body)
+	a.mu.Unlock()
+	writeJSON(w, map[string]interface{}{"errcode": 0, "errmsg": "ok"})
+}
+
+// authorize 校验access_token并读取请求体
+func (a *API) authorize(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	token := r.URL.Query().Get("access_token")
+	a.mu.Lock()
+	valid := token != "" && token == a.token
+	a.mu.Unlock()
+	if !valid {
+		writeJSON(w, map[string]interface{}{"errcode": 40001, "errmsg": "invalid credential, access_token is invalid or not latest"})
+		return nil, false
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	return body, true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,180 @@
+// Package wechatsim 在本地模拟微信公众平台，用于离线测试公众号消息接口
+//
+// Client 扮演微信服务器向 /wechat 推送消息：生成签名的验证请求，按安全/兼容/明文模式
+// 加密并签名消息，解密并校验被动回复；API 模拟 access_token 和客服消息等主动调用接口。
+package wechatsim
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"meea-icey/tools"
+)
+
+// 消息加解密方式，与 wechat.mode 配置一致
+const (
+	ModeSafe       = "safe"
+	ModeCompatible = "compatible"
+	ModePlaintext  = "plaintext"
+)
+
+// Account 公众号在开发者中心配置的服务器参数
+type Account struct {
+	Token          string
+	EncodingAESKey string
+	AppID          string
+	// OriginalID 公众号原始ID，即消息的ToUserName
+	OriginalID string
+}
+
+// Client 模拟微信服务器向开发者服务器推送消息
+type Client struct {
+	Account Account
+	Handler http.Handler
+	// Path 消息接口路径，默认 /wechat
+	Path string
+	// Mode 推送方式，默认安全模式；兼容模式与安全模式一样推送加密消息
+	Mode string
+	// Now 生成timestamp和CreateTime使用的时钟，默认time.Now
+	Now func() time.Time
+
+	seq uint64
+}
+
+// NewClient 创建模拟客户端，handler通常为gin路由
+func NewClient(handler http.Handler, account Account) *Client {
+	return &Client{Account: account, Handler: handler, Path: "/wechat", Mode: ModeSafe, Now: time.Now}
+}
+
+// Reply 开发者服务器的响应
+type Reply struct {
+	Status int
+	// Body 响应原文，安全模式下为加密回复包
+	Body []byte
+	// Message 解密后的被动回复，响应为"success"或空时为nil
+	Message *tools.DecryptedMessage
+}
+
+// Content 被动回复的文本内容
+func (r *Reply) Content() string {
+	if r.Message == nil {
+		return ""
+	}
+	return r.Message.Content
+}
+
+// Handshake 发送服务器配置验证请求，返回响应状态和内容(验证通过时为echostr)
+func (c *Client) Handshake(echostr string) (int, string) {
+	timestamp, nonce := c.stamp()
+	query := c.signedQuery(timestamp, nonce)
+	query.Set("echostr", echostr)
+	rec := httptest.NewRecorder()
+	c.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, c.Path+"?"+query.Encode(), nil))
+	return rec.Code, rec.Body.String()
+}
+
+// SendText 推送用户发送的文本消息
+func (c *Client) SendText(openid, content string) (*Reply, error) {
+	msg := fmt.Sprintf(`<xml><ToUserName><![CDATA[%s]]></ToUserName><FromUserName><![CDATA[%s]]></FromUserName><CreateTime>%d</CreateTime><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[%s]]></Content><MsgId>%d</MsgId></xml>`,
+		c.Account.OriginalID, openid, c.Now().Unix(), content, atomic.AddUint64(&c.seq, 1))
+	return c.Post([]byte(msg))
+}
+
+// SendEvent 推送事件消息，如 subscribe、unsubscribe、CLICK
+func (c *Client) SendEvent(openid, event, eventKey string) (*Reply, error) {
+	msg := fmt.Sprintf(`<xml><ToUserName><![CDATA[%s]]></ToUserName><FromUserName><![CDATA[%s]]></FromUserName><CreateTime>%d</CreateTime><MsgType><![CDATA[event]]></MsgType><Event><![CDATA[%s]]></Event><EventKey><![CDATA[%s]]></EventKey></xml>`,
+		c.Account.OriginalID, openid, c.Now().Unix(), event, eventKey)
+	return c.Post([]byte(msg))
+}
+
+// Post 按当前模式推送任意消息XML，并解析被动回复
+func (c *Client) Post(msgXML []byte) (*Reply, error) {
+	req, err := c.NewRequest(msgXML)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// NewRequest 构造带签名的推送请求，可用于重放等异常场景的测试
+func (c *Client) NewRequest(msgXML []byte) (*http.Request, error) {
+	timestamp, nonce := c.stamp()
+	query := c.signedQuery(timestamp, nonce)
+	body := msgXML
+	if c.Mode != ModePlaintext {
+		encrypt, err := tools.EncryptWechatMessage(msgXML, c.Account.EncodingAESKey, c.Account.AppID)
+		if err != nil {
+			return nil, fmt.Errorf("加密消息失败: %v", err)
+		}
+		query.Set("encrypt_type", "aes")
+		query.Set("msg_signature", tools.GenerateMsgSignature(c.Account.Token, timestamp, nonce, encrypt))
+		body = []byte(fmt.Sprintf(`<xml><ToUserName><![CDATA[%s]]></ToUserName><Encrypt><![CDATA[%s]]></Encrypt></xml>`, c.Account.OriginalID, encrypt))
+	}
+	req := httptest.NewRequest(http.MethodPost, c.Path+"?"+query.Encode(), bytes.NewReader(body))
+	req.Header.Set("Content-Type", "text/xml")
+	return req, nil
+}
+
+// Do 发送推送请求并解析被动回复，加密回复会校验签名后解密
+func (c *Client) Do(req *http.Request) (*Reply, error) {
+	rec := httptest.NewRecorder()
+	c.Handler.ServeHTTP(rec, req)
+	reply := &Reply{Status: rec.Code, Body: rec.Body.Bytes()}
+	if rec.Code != http.StatusOK {
+		return reply, nil
+	}
+	body := bytes.TrimSpace(reply.Body)
+	if len(body) == 0 || string(body) == "success" {
+		return reply, nil
+	}
+
+	plain := body
+	if req.URL.Query().Get("encrypt_type") == "aes" {
+		var enc struct {
+			Encrypt      string `xml:"Encrypt"`
+			MsgSignature string `xml:"MsgSignature"`
+			TimeStamp    string `xml:"TimeStamp"`
+			Nonce        string `xml:"Nonce"`
+		}
+		if err := xml.Unmarshal(body, &enc); err != nil {
+			return reply, fmt.Errorf("解析加密回复失败: %v", err)
+		}
+		if !tools.VerifyMsgSignature(c.Account.Token, enc.TimeStamp, enc.Nonce, enc.Encrypt, enc.MsgSignature) {
+			return reply, fmt.Errorf("加密回复的MsgSignature校验失败")
+		}
+		var err error
+		if plain, err = tools.DecryptWechatPayload(enc.Encrypt, c.Account.EncodingAESKey, c.Account.AppID); err != nil {
+			return reply, fmt.Errorf("解密回复失败: %v", err)
+		}
+	}
+	var msg tools.DecryptedMessage
+	if err := xml.Unmarshal(plain, &msg); err != nil {
+		return reply, fmt.Errorf("解析回复失败: %v", err)
+	}
+	reply.Message = &msg
+	return reply, nil
+}
+
+// signedQuery 生成URL中的signature、timestamp和nonce参数
+func (c *Client) signedQuery(timestamp, nonce string) url.Values {
+	query := url.Values{}
+	query.Set("signature", tools.GenerateSignature(c.Account.Token, timestamp, nonce))
+	query.Set("timestamp", timestamp)
+	query.Set("nonce", nonce)
+	return query
+}
+
+func (c *Client) stamp() (string, string) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return strconv.FormatInt(c.Now().Unix(), 10), hex.EncodeToString(b)
+}
@@ -0,0 +1,250 @@
+package wechatsim_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"meea-icey/controllers"
+	"meea-icey/internal/events"
+	"meea-icey/internal/wechatsim"
+	"meea-icey/models"
+	"meea-icey/services"
+)
+
+const (
+	testAppSecret = "sim-secret"
+	testOpenID    = "oSimUser0000000000000000001"
+)
+
+var testAccount = wechatsim.Account{
+	Token:          "simtoken",
+	EncodingAESKey: "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFG",
+	AppID:          "wx0123456789abcdef",
+	OriginalID:     "gh_0123456789ab",
+}
+
+var codePattern = regexp.MustCompile(`验证码是: (\d{6})`)
+
+type harness struct {
+	client        *wechatsim.Client
+	api           *wechatsim.API
+	redis         *redis.Client
+	verify        *services.VerifyService
+	subscriptions *services.SubscriptionService
+}
+
+func newHarness(t *testing.T, mode string) *harness {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+	api := wechatsim.NewAPI(testAccount.AppID, testAppSecret)
+	t.Cleanup(api.Close)
+
+	config := &models.Config{}
+	config.Wechat.AppID = testAccount.AppID
+	config.Wechat.AppSecret = testAppSecret
+	config.Wechat.Token = testAccount.Token
+	config.Wechat.EncodingAESKey = testAccount.EncodingAESKey
+	config.Wechat.Mode = mode
+	config.Wechat.APIBaseURL = api.URL()
+	config.Verification.MaxAttempts = 15
+	config.Subscriptions.Enabled = true
+
+	ctx := context.Background()
+	verifyService := services.NewVerifyService(redisClient, config)
+	wechatService := services.NewWechatService(config, redisClient, ctx)
+	subscriptions := services.NewSubscriptionService(config, redisClient, wechatService)
+	commands := services.NewWechatCommandRouter(config, redisClient, verifyService, subscriptions, nil, nil, nil, nil)
+	wechatController := controllers.NewWechatController(config, redisClient, ctx, wechatService, commands, subscriptions, verifyService, nil)
+
+	router := gin.New()
+	router.Any("/wechat", func(c *gin.Context) {
+		wechatController.HandleMessage(c.Writer, c.Request)
+	})
+
+	client := wechatsim.NewClient(router, testAccount)
+	if mode == wechatsim.ModePlaintext {
+		client.Mode = wechatsim.ModePlaintext
+	}
+	return &harness{client: client, api: api, redis: redisClient, verify: verifyService, subscriptions: subscriptions}
+}
+
+// requestCode 发送“<主题>验证码”并从回复中取出验证码
+func (h *harness) requestCode(t *testing.T, subject string) string {
+	t.Helper()
+	reply, err := h.client.SendText(testOpenID, subject+"验证码")
+	if err != nil {
+		t.Fatalf("发送消息失败: %v", err)
+	}
+	m := codePattern.FindStringSubmatch(reply.Content())
+	if reply.Status != http.StatusOK || m == nil {
+		t.Fatalf("未收到验证码: status=%d, content=%q", reply.Status, reply.Content())
+	}
+	if reply.Message.ToUserName != testOpenID || reply.Message.FromUserName != testAccount.OriginalID {
+		t.Errorf("回复的收发方不正确: %+v", reply.Message)
+	}
+	return m[1]
+}
+
+func TestHandshake(t *testing.T) {
+	h := newHarness(t, wechatsim.ModeSafe)
+	status, body := h.client.Handshake("echo-123")
+	if status != http.StatusOK || body != "echo-123" {
+		t.Fatalf("验证请求应返回echostr: status=%d, body=%q", status, body)
+	}
+
+	h.client.Account.Token = "wrong"
+	if status, _ := h.client.Handshake("echo-123"); status != http.StatusForbidden {
+		t.Fatalf("token不符时应返回403, 实际%d", status)
+	}
+}
+
+func TestCodeIssuance(t *testing.T) {
+	for _, mode := range []string{wechatsim.ModeSafe, wechatsim.ModeCompatible, wechatsim.ModePlaintext} {
+		t.Run(mode, func(t *testing.T) {
+			h := newHarness(t, mode)
+			code := h.requestCode(t, "张三")
+			ok, err := h.verify.VerifyCode(context.Background(), services.SubjectHash("张三"), code)
+			if err != nil || !ok {
+				t.Fatalf("签发的验证码应能通过校验: ok=%v, err=%v", ok, err)
+			}
+			owner := h.verify.CodeOwner(services.SubjectHash("张三"), code)
+			if owner != services.HashOpenID(testOpenID) {
+				t.Errorf("验证码所属用户不正确: %s", owner)
+			}
+		})
+	}
+}
+
+func TestSafeModeRejectsPlaintext(t *testing.T) {
+	h := newHarness(t, wechatsim.ModeSafe)
+	h.client.Mode = wechatsim.ModePlaintext
+	reply, err := h.client.SendText(testOpenID, "张三验证码")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Status != http.StatusBadRequest {
+		t.Fatalf("安全模式下明文消息应被拒绝, 实际%d", reply.Status)
+	}
+}
+
+func TestRejectsReplayAndStaleTimestamp(t *testing.T) {
+	h := newHarness(t, wechatsim.ModeSafe)
+	req, err := h.client.NewRequest([]byte(`<xml><ToUserName><![CDATA[gh_0123456789ab]]></ToUserName><FromUserName><![CDATA[` + testOpenID + `]]></FromUserName><CreateTime>1</CreateTime><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[帮助]]></Content></xml>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(req.Body)
+	target := req.URL.String()
+
+	first, err := h.client.Do(httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body)))
+	if err != nil || first.Status != http.StatusOK {
+		t.Fatalf("首次推送应成功: status=%d, err=%v", first.Status, err)
+	}
+	replay, _ := h.client.Do(httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body)))
+	if replay.Status != http.StatusForbidden {
+		t.Fatalf("重放的请求应被拒绝, 实际%d", replay.Status)
+	}
+
+	h.client.Now = func() time.Time { return time.Now().Add(-time.Hour) }
+	stale, err := h.client.SendText(testOpenID, "帮助")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale.Status != http.StatusForbidden {
+		t.Fatalf("过期的时间戳应被拒绝, 实际%d", stale.Status)
+	}
+}
+
+func TestTamperedSignatureRejected(t *testing.T) {
+	h := newHarness(t, wechatsim.ModeSafe)
+	req, err := h.client.NewRequest([]byte(`<xml><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[帮助]]></Content></xml>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := req.URL.Query()
+	q.Set("msg_signature", strings.Repeat("0", 40))
+	req.URL.RawQuery = q.Encode()
+	reply, _ := h.client.Do(req)
+	if reply.Status != http.StatusForbidden {
+		t.Fatalf("msg_signature不符时应返回403, 实际%d", reply.Status)
+	}
+}
+
+func TestMenuClickAndUnsubscribe(t *testing.T) {
+	h := newHarness(t, wechatsim.ModeSafe)
+
+	welcome, err := h.client.SendEvent(testOpenID, "subscribe", "")
+	if err != nil || !strings.Contains(welcome.Content(), "欢迎关注") {
+		t.Fatalf("关注事件应回复欢迎语: %q, %v", welcome.Content(), err)
+	}
+
+	click, err := h.client.SendEvent(testOpenID, "CLICK", "帮助")
+	if err != nil || !strings.Contains(click.Content(), "验证码") {
+		t.Fatalf("菜单点击应按指令回复: %q, %v", click.Content(), err)
+	}
+
+	code := h.requestCode(t, "李四")
+	if _, err := h.client.SendText(testOpenID, "李四订阅"); err != nil {
+		t.Fatal(err)
+	}
+
+	bye, err := h.client.SendEvent(testOpenID, "unsubscribe", "")
+	if err != nil || bye.Message != nil {
+		t.Fatalf("取消关注事件无需回复: %+v, %v", bye, err)
+	}
+	if ok, _ := h.verify.VerifyCode(context.Background(), services.SubjectHash("李四"), code); ok {
+		t.Error("取消关注后验证码应被作废")
+	}
+	subs, _ := h.subscriptions.Subscriptions(context.Background(), testOpenID)
+	if len(subs) != 0 {
+		t.Errorf("取消关注后订阅应被清理: %v", subs)
+	}
+}
+
+func TestSubscriptionNotifiedThroughCustomSend(t *testing.T) {
+	h := newHarness(t, wechatsim.ModeSafe)
+	ctx := context.Background()
+
+	for _, subject := range []string{"王五", "赵六"} {
+		reply, err := h.client.SendText(testOpenID, subject+"订阅")
+		if err != nil || reply.Status != http.StatusOK {
+			t.Fatalf("订阅失败: %+v, %v", reply, err)
+		}
+	}
+
+	if err := h.subscriptions.Handle(ctx, events.New(events.RecordCreated, services.SubjectHash("王五"), "r1", nil)); err != nil {
+		t.Fatalf("发送通知失败: %v", err)
+	}
+	// token在别处被刷新后，调用返回40001，服务应刷新token并重试
+	h.api.RevokeToken()
+	if err := h.subscriptions.Handle(ctx, events.New(events.RecordCreated, services.SubjectHash("赵六"), "r2", nil)); err != nil {
+		t.Fatalf("token失效后重试发送失败: %v", err)
+	}
+
+	messages := h.api.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("应收到2条客服消息, 实际%d: %+v", len(messages), messages)
+	}
+	for i, subject := range []string{"王五", "赵六"} {
+		if messages[i].ToUser != testOpenID || !strings.Contains(messages[i].Content, subject) {
+			t.Errorf("第%d条客服消息不正确: %+v", i+1, messages[i])
+		}
+	}
+	if n := h.api.TokenRequests(); n != 2 {
+		t.Errorf("应获取2次access_token, 实际%d", n)
+	}
+}
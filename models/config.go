@@ -1,5 +1,14 @@
 package models
 
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
 // Config 定义配置结构体
 type Config struct {
 	Wechat struct {
@@ -7,13 +16,31 @@ type Config struct {
 		AppSecret      string `yaml:"app_secret"`
 		Token          string `yaml:"token"`
 		EncodingAESKey string `yaml:"encoding_aes_key"`
+		// Mode 消息加解密方式: safe(安全模式) / compatible(兼容模式) / plaintext(明文模式)
+		Mode string `yaml:"mode"`
+		// TimestampSkew 允许的消息时间戳偏差，超出时拒绝
+		TimestampSkew time.Duration `yaml:"timestamp_skew"`
+		// APIBaseURL 微信接口地址，测试时可指向本地模拟服务
+		APIBaseURL string `yaml:"api_base_url"`
+		// TokenRefreshBefore access_token过期前多久主动刷新
+		TokenRefreshBefore time.Duration `yaml:"token_refresh_before"`
 	} `yaml:"wechat"`
 	Repository struct {
-		URL       string `yaml:"url"`
-		ClonePath string `yaml:"clone_path"`
-		SSHKey    string `yaml:"ssh_key"`
-		Username  string `yaml:"username"`
-		Password  string `yaml:"password"`
+		URL          string        `yaml:"url"`
+		ClonePath    string        `yaml:"clone_path"`
+		SSHKey       string        `yaml:"ssh_key"`
+		Username     string        `yaml:"username"`
+		Password     string        `yaml:"password"`
+		SyncInterval time.Duration `yaml:"sync_interval"`
+		MaxStaleness time.Duration `yaml:"max_staleness"`
+		// WebhookSecret 推送通知的HMAC密钥(GitLab为X-Gitlab-Token)
+		WebhookSecret string `yaml:"webhook_secret"`
+		// Branch 预期的工作分支，为空时不检查
+		Branch string `yaml:"branch"`
+		// HealthCheckInterval 定期检查本地仓库的间隔
+		HealthCheckInterval time.Duration `yaml:"health_check_interval"`
+		// RepairActions 检查发现问题时允许的修复动作: push / reset / reclone，为空时只报告
+		RepairActions []string `yaml:"repair_actions"`
 	} `yaml:"repository"`
 	Redis struct {
 		IP       string `yaml:"ip"`
@@ -22,11 +49,113 @@ type Config struct {
 	} `yaml:"redis"`
 	Verification struct {
 		MaxAttempts int `yaml:"max_attempts"`
+		// Email 邮件渠道
+		Email struct {
+			Enabled        bool          `yaml:"enabled"`
+			SMTPAddr       string        `yaml:"smtp_addr"`
+			Username       string        `yaml:"username"`
+			Password       string        `yaml:"password"`
+			From           string        `yaml:"from"`
+			ResendInterval time.Duration `yaml:"resend_interval"`
+			// PerIPLimit 同一来源IP在PerIPWindow内最多请求发送的次数
+			PerIPLimit  int           `yaml:"per_ip_limit"`
+			PerIPWindow time.Duration `yaml:"per_ip_window"`
+		} `yaml:"email"`
+		// Partners 合作方API渠道
+		Partners struct {
+			Enabled bool `yaml:"enabled"`
+			Keys    []struct {
+				Name string `yaml:"name"`
+				Key  string `yaml:"key"`
+			} `yaml:"keys"`
+		} `yaml:"partners"`
+		// TOTP 高级用户动态码渠道
+		TOTP struct {
+			Enabled bool   `yaml:"enabled"`
+			Issuer  string `yaml:"issuer"`
+			Skew    int    `yaml:"skew"`
+			// MaxFailures 动态码连续错误达到该次数后锁定用户Lockout时长
+			MaxFailures int           `yaml:"max_failures"`
+			Lockout     time.Duration `yaml:"lockout"`
+		} `yaml:"totp"`
 	} `yaml:"verification"`
+	Votes struct {
+		Window int `yaml:"window"`
+	} `yaml:"votes"`
+	Scoring struct {
+		Method      string        `yaml:"method"`
+		WilsonZ     float64       `yaml:"wilson_z"`
+		PriorMean   float64       `yaml:"prior_mean"`
+		PriorWeight float64       `yaml:"prior_weight"`
+		HalfLife    time.Duration `yaml:"half_life"`
+	} `yaml:"scoring"`
+	Reputation struct {
+		MinWeight        float64       `yaml:"min_weight"`
+		MaxWeight        float64       `yaml:"max_weight"`
+		AgreeReward      float64       `yaml:"agree_reward"`
+		DisagreePenalty  float64       `yaml:"disagree_penalty"`
+		UpvoteReward     float64       `yaml:"upvote_reward"`
+		HiddenPenalty    float64       `yaml:"hidden_penalty"`
+		MinVotes         int           `yaml:"min_votes"`
+		SettleAfter      time.Duration `yaml:"settle_after"`
+		SettleInterval   time.Duration `yaml:"settle_interval"`
+		PendingExpiry    time.Duration `yaml:"pending_expiry"`
+		SnapshotInterval time.Duration `yaml:"snapshot_interval"`
+		SnapshotPath     string        `yaml:"snapshot_path"`
+	} `yaml:"reputation"`
+	QueryCache struct {
+		Enabled bool          `yaml:"enabled"`
+		Size    int           `yaml:"size"`
+		TTL     time.Duration `yaml:"ttl"`
+	} `yaml:"query_cache"`
+	Events struct {
+		Buffer  int `yaml:"buffer"`
+		Webhook struct {
+			Enabled       bool          `yaml:"enabled"`
+			URLs          []string      `yaml:"urls"`
+			Secret        string        `yaml:"secret"`
+			MaxRetries    int           `yaml:"max_retries"`
+			RetryBackoff  time.Duration `yaml:"retry_backoff"`
+			Timeout       time.Duration `yaml:"timeout"`
+			DeadLetterKey string        `yaml:"dead_letter_key"`
+			RetryKey      string        `yaml:"retry_key"`
+		} `yaml:"webhook"`
+		Stream struct {
+			Enabled bool   `yaml:"enabled"`
+			Key     string `yaml:"key"`
+			MaxLen  int64  `yaml:"max_len"`
+		} `yaml:"stream"`
+	} `yaml:"events"`
+	Subscriptions struct {
+		Enabled        bool          `yaml:"enabled"`
+		Throttle       time.Duration `yaml:"throttle"`
+		ShiftThreshold int           `yaml:"shift_threshold"`
+		MinVotes       int           `yaml:"min_votes"`
+		MaxPerUser     int           `yaml:"max_per_user"`
+	} `yaml:"subscriptions"`
 	Server struct {
 		Port int    `yaml:"port"`
 		Host string `yaml:"host"`
+		// ShutdownTimeout 收到退出信号后等待处理中的请求和Git操作完成的最长时间
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+		// TrustedProxies 可信反向代理的IP或网段，只采信这些来源的X-Forwarded-For，为空时使用连接地址
+		TrustedProxies []string `yaml:"trusted_proxies"`
 	} `yaml:"server"`
+	Health struct {
+		// CheckTimeout 每项就绪检查的超时时间
+		CheckTimeout time.Duration `yaml:"check_timeout"`
+		// MaxSyncAge 距上次成功拉取远程仓库超过该时间时未就绪
+		MaxSyncAge time.Duration `yaml:"max_sync_age"`
+	} `yaml:"health"`
+	Metrics struct {
+		// Enabled 是否开放Prometheus /metrics 接口
+		Enabled bool `yaml:"enabled"`
+		// Listen 独立的管理监听地址，非空时/metrics只在该地址提供，不注册到业务端口
+		Listen string `yaml:"listen"`
+		// Token 访问令牌，非空时请求需携带 Authorization: Bearer <token>；
+		// 未配置Listen时必须配置，否则不开放/metrics
+		Token string `yaml:"token"`
+	} `yaml:"metrics"`
 	Logging struct {
 		Level  string `yaml:"level"`
 		Format string `yaml:"format"`
@@ -37,3 +166,31 @@ type Config struct {
 		DebugMode          bool   `yaml:"debugMode"`
 	} `yaml:"license"`
 }
+
+// LoadConfig 加载配置文件（支持环境变量渲染）
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+
+	// 环境变量渲染
+	content := os.Expand(string(data), expandEnvWithDefault)
+
+	var config Config
+	err = yaml.Unmarshal([]byte(content), &config)
+	if err != nil {
+		return nil, fmt.Errorf("解析配置文件失败: %v", err)
+	}
+
+	return &config, nil
+}
+
+// expandEnvWithDefault 支持 ${VAR:-default} 语法，变量未设置或为空时使用默认值
+func expandEnvWithDefault(key string) string {
+	name, def, hasDefault := strings.Cut(key, ":-")
+	if v := os.Getenv(name); v != "" || !hasDefault {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandEnvWithDefault(t *testing.T) {
+	t.Setenv("ICEY_TEST_SET", "value")
+	t.Setenv("ICEY_TEST_EMPTY", "")
+	for key, want := range map[string]string{
+		"ICEY_TEST_SET":             "value",
+		"ICEY_TEST_SET:-fallback":   "value",
+		"ICEY_TEST_EMPTY:-fallback": "fallback",
+		"ICEY_TEST_UNSET:-fallback": "fallback",
+		"ICEY_TEST_UNSET:-":         "",
+		"ICEY_TEST_UNSET":           "",
+		"ICEY_TEST_UNSET:-a:-b":     "a:-b",
+	} {
+		if got := expandEnvWithDefault(key); got != want {
+			t.Errorf("%s 展开为%q, 预期%q", key, got, want)
+		}
+	}
+}
+
+func TestLoadConfigExpandsEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "redis:\n  ip: \"${ICEY_TEST_REDIS:-localhost}\"\nlicense:\n  debugMode: ${ICEY_TEST_DEBUG:-false}\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Redis.IP != "localhost" || config.License.DebugMode {
+		t.Fatalf("未设置环境变量时应使用默认值: %q, %v", config.Redis.IP, config.License.DebugMode)
+	}
+
+	t.Setenv("ICEY_TEST_REDIS", "redis")
+	t.Setenv("ICEY_TEST_DEBUG", "true")
+	if config, err = LoadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	if config.Redis.IP != "redis" || !config.License.DebugMode {
+		t.Fatalf("应使用环境变量的值: %q, %v", config.Redis.IP, config.License.DebugMode)
+	}
+}
+
+// 随仓库发布的配置在未设置环境变量时不得开启许可证调试模式
+func TestShippedConfigDefaults(t *testing.T) {
+	t.Setenv("LICENSE_DEBUG_MODE", "")
+	config, err := LoadConfig("../config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.License.DebugMode {
+		t.Fatal("许可证调试模式默认应关闭")
+	}
+}
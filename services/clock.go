@@ -0,0 +1,19 @@
+package services
+
+import "time"
+
+// Clock 返回当前时间，测试时可注入固定或可调的时钟
+type Clock func() time.Time
+
+// SystemClock 系统时钟
+func SystemClock() time.Time {
+	return time.Now()
+}
+
+// OrSystem 未设置时钟时使用系统时钟
+func (c Clock) OrSystem() Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
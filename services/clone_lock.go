@@ -0,0 +1,37 @@
+//go:build unix
+
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// ErrCloneInUse 克隆目录已被其他进程(运行中的服务或管理命令)占用
+var ErrCloneInUse = errors.New("克隆目录已被其他进程使用")
+
+// cloneLockFile 克隆目录占用标记，位于仓库目录之外，reclone时不会被移走
+const cloneLockFile = ".icey.lock"
+
+// ClaimClone 独占克隆目录，返回释放函数。服务运行期间持有，直接改写仓库文件的管理命令
+// 需先取得，避免与服务的写请求同时改写同一份清单和投票文件；进程退出时由系统释放
+func ClaimClone(clonePath string) (func(), error) {
+	if err := os.MkdirAll(clonePath, 0755); err != nil {
+		return nil, fmt.Errorf("创建克隆目录失败: %v", err)
+	}
+	f, err := os.OpenFile(filepath.Join(clonePath, cloneLockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("打开克隆目录占用标记失败: %v", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: %s", ErrCloneInUse, clonePath)
+		}
+		return nil, fmt.Errorf("锁定克隆目录失败: %v", err)
+	}
+	return func() { f.Close() }, nil
+}
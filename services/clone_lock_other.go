@@ -0,0 +1,13 @@
+//go:build !unix
+
+package services
+
+import "errors"
+
+// ErrCloneInUse 克隆目录已被其他进程(运行中的服务或管理命令)占用
+var ErrCloneInUse = errors.New("克隆目录已被其他进程使用")
+
+// ClaimClone 非Unix平台不检查克隆目录占用，需自行保证管理命令运行时服务已停止
+func ClaimClone(clonePath string) (func(), error) {
+	return func() {}, nil
+}
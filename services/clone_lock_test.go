@@ -0,0 +1,25 @@
+//go:build unix
+
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClaimCloneIsExclusive(t *testing.T) {
+	dir := t.TempDir()
+	release, err := ClaimClone(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ClaimClone(dir); !errors.Is(err, ErrCloneInUse) {
+		t.Fatalf("已占用的克隆目录应返回ErrCloneInUse: %v", err)
+	}
+	release()
+	release, err = ClaimClone(dir)
+	if err != nil {
+		t.Fatalf("释放后应可再次占用: %v", err)
+	}
+	release()
+}
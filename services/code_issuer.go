@@ -0,0 +1,406 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"meea-icey/models"
+)
+
+// 验证码签发渠道
+const (
+	ChannelWechat  = "wechat"
+	ChannelEmail   = "email"
+	ChannelPartner = "partner"
+	ChannelTOTP    = "totp"
+)
+
+// ErrIssueDenied 身份校验未通过，不签发验证码
+var ErrIssueDenied = errors.New("身份验证失败")
+
+// CodeRequest 验证码签发请求
+type CodeRequest struct {
+	Subject string
+	// Recipient 渠道内的用户标识: 微信openid / 邮箱地址 / 合作方用户标识 / TOTP用户名
+	Recipient string
+	// Credential 渠道凭证: 合作方API Key / TOTP动态码，微信和邮件渠道为空
+	Credential string
+	// ClientIP 请求来源IP，用于按来源限制发送频率，微信渠道为空
+	ClientIP string
+}
+
+// IssuedCode 签发结果
+type IssuedCode struct {
+	SubjectHash string
+	Code        string
+	ExpiresIn   time.Duration
+	// Delivered 为true时验证码已由渠道送达用户，不应在接口响应中返回
+	Delivered bool
+}
+
+// CodeIssuer 验证码签发渠道
+// 各渠道只负责校验身份和送达方式，验证码统一通过VerifyService写入 icey:subject:* 记录
+type CodeIssuer interface {
+	Channel() string
+	Issue(ctx context.Context, req *CodeRequest) (*IssuedCode, error)
+}
+
+// issueFor 以渠道内的用户身份签发验证码
+func issueFor(ctx context.Context, verifyService *VerifyService, subject, owner string) (*IssuedCode, error) {
+	subjectHash, code, err := verifyService.IssueCode(ctx, subject, owner)
+	if err != nil {
+		return nil, err
+	}
+	return &IssuedCode{SubjectHash: subjectHash, Code: code, ExpiresIn: verifyService.CodeTTL()}, nil
+}
+
+// channelOwner 非微信渠道的用户身份哈希，加渠道前缀避免与openid哈希冲突
+func channelOwner(channel, id string) string {
+	return HashOpenID(channel + ":" + id)
+}
+
+// WechatIssuer 微信公众号渠道，验证码通过被动回复送达
+type WechatIssuer struct {
+	verifyService *VerifyService
+}
+
+func NewWechatIssuer(verifyService *VerifyService) *WechatIssuer {
+	return &WechatIssuer{verifyService: verifyService}
+}
+
+func (i *WechatIssuer) Channel() string { return ChannelWechat }
+
+func (i *WechatIssuer) Issue(ctx context.Context, req *CodeRequest) (*IssuedCode, error) {
+	return issueFor(ctx, i.verifyService, req.Subject, HashOpenID(req.Recipient))
+}
+
+// 邮件渠道的Redis Key
+const (
+	emailThrottleKey = "icey:email-code:throttle:%s" // 同一邮箱的重发间隔
+	emailIPCountKey  = "icey:email-code:ip:%s"       // 来源IP在时间窗口内的请求次数
+)
+
+// EmailIssuer 邮件渠道，验证码通过SMTP发送到用户邮箱
+type EmailIssuer struct {
+	verifyService  *VerifyService
+	redisClient    *redis.Client
+	addr           string
+	from           string
+	auth           smtp.Auth
+	resendInterval time.Duration
+	perIPLimit     int
+	perIPWindow    time.Duration
+}
+
+func NewEmailIssuer(config *models.Config, verifyService *VerifyService, redisClient *redis.Client) *EmailIssuer {
+	cfg := config.Verification.Email
+	i := &EmailIssuer{
+		verifyService:  verifyService,
+		redisClient:    redisClient,
+		addr:           cfg.SMTPAddr,
+		from:           cfg.From,
+		resendInterval: cfg.ResendInterval,
+		perIPLimit:     cfg.PerIPLimit,
+		perIPWindow:    cfg.PerIPWindow,
+	}
+	if cfg.Username != "" {
+		host := cfg.SMTPAddr
+		if h, _, ok := strings.Cut(cfg.SMTPAddr, ":"); ok {
+			host = h
+		}
+		i.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+	if i.resendInterval <= 0 {
+		i.resendInterval = time.Minute
+	}
+	if i.perIPLimit <= 0 {
+		i.perIPLimit = 10
+	}
+	if i.perIPWindow <= 0 {
+		i.perIPWindow = time.Hour
+	}
+	return i
+}
+
+func (i *EmailIssuer) Channel() string { return ChannelEmail }
+
+func (i *EmailIssuer) Issue(ctx context.Context, req *CodeRequest) (*IssuedCode, error) {
+	addr, err := mail.ParseAddress(req.Recipient)
+	if err != nil || addr.Name != "" {
+		return nil, fmt.Errorf("无效的邮箱地址")
+	}
+	email := strings.ToLower(addr.Address)
+
+	// 同一来源IP在时间窗口内的请求次数有上限，防止轮换邮箱地址批量发信
+	if req.ClientIP != "" {
+		ipKey := fmt.Sprintf(emailIPCountKey, req.ClientIP)
+		count, err := i.redisClient.Incr(ctx, ipKey).Result()
+		if err != nil {
+			return nil, fmt.Errorf("检查发送频率失败: %v", err)
+		}
+		if count == 1 {
+			i.redisClient.Expire(ctx, ipKey, i.perIPWindow)
+		}
+		if count > int64(i.perIPLimit) {
+			return nil, fmt.Errorf("发送过于频繁，请稍后再试")
+		}
+	}
+
+	// 同一邮箱在间隔内只发送一次
+	throttleKey := fmt.Sprintf(emailThrottleKey, email)
+	first, err := i.redisClient.SetNX(ctx, throttleKey, 1, i.resendInterval).Result()
+	if err != nil {
+		return nil, fmt.Errorf("检查发送频率失败: %v", err)
+	}
+	if !first {
+		return nil, fmt.Errorf("发送过于频繁，请稍后再试")
+	}
+
+	issued, err := issueFor(ctx, i.verifyService, req.Subject, channelOwner(ChannelEmail, email))
+	if err != nil {
+		return nil, err
+	}
+	if err := i.send(email, req.Subject, issued); err != nil {
+		// 发送失败不占用重发间隔
+		i.redisClient.Del(ctx, throttleKey)
+		return nil, err
+	}
+	issued.Delivered = true
+	return issued, nil
+}
+
+func (i *EmailIssuer) send(to, subject string, issued *IssuedCode) error {
+	body := fmt.Sprintf("您查询「%s」的验证码是: %s\r\n验证码 %d 小时内有效，请勿告诉他人。\r\n",
+		subject, issued.Code, int(issued.ExpiresIn.Hours()))
+	msg := strings.Join([]string{
+		"From: " + i.from,
+		"To: " + to,
+		"Subject: =?UTF-8?B?" + base64.StdEncoding.EncodeToString([]byte("验证码")) + "?=",
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"Content-Transfer-Encoding: base64",
+		"",
+		base64.StdEncoding.EncodeToString([]byte(body)),
+	}, "\r\n")
+	if err := smtp.SendMail(i.addr, i.auth, i.from, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("发送邮件失败: %v", err)
+	}
+	return nil
+}
+
+// PartnerIssuer 合作方API渠道，合作方以API Key认证后代其用户获取验证码
+type PartnerIssuer struct {
+	verifyService *VerifyService
+	// API Key的SHA256 -> 合作方名称
+	keys map[string]string
+}
+
+func NewPartnerIssuer(config *models.Config, verifyService *VerifyService) *PartnerIssuer {
+	i := &PartnerIssuer{verifyService: verifyService, keys: make(map[string]string)}
+	for _, p := range config.Verification.Partners.Keys {
+		if p.Name == "" || p.Key == "" {
+			continue
+		}
+		sum := sha256.Sum256([]byte(p.Key))
+		i.keys[hex.EncodeToString(sum[:])] = p.Name
+	}
+	return i
+}
+
+func (i *PartnerIssuer) Channel() string { return ChannelPartner }
+
+// Authenticate 校验API Key，返回合作方名称
+func (i *PartnerIssuer) Authenticate(apiKey string) (string, bool) {
+	if apiKey == "" {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	digest := hex.EncodeToString(sum[:])
+	for k, name := range i.keys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(digest)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (i *PartnerIssuer) Issue(ctx context.Context, req *CodeRequest) (*IssuedCode, error) {
+	partner, ok := i.Authenticate(req.Credential)
+	if !ok {
+		return nil, ErrIssueDenied
+	}
+	if req.Recipient == "" {
+		return nil, fmt.Errorf("user不能为空")
+	}
+	return issueFor(ctx, i.verifyService, req.Subject, channelOwner(ChannelPartner, partner+":"+req.Recipient))
+}
+
+// TOTP相关的Redis Key
+const (
+	totpUsersKey    = "icey:totp:users"       // 用户名 -> Base32密钥
+	totpUsedKey     = "icey:totp:used:%s"     // 已使用的时间步，防止动态码重放
+	totpFailuresKey = "icey:totp:failures:%s" // 未成功的尝试次数，过期后解除锁定
+)
+
+// TOTP参数(RFC 6238默认值，与常见验证器App一致)
+const (
+	totpPeriod = 30
+	totpDigits = 6
+)
+
+// TOTPIssuer 为登记过的高级用户提供TOTP渠道，用户以验证器App的动态码换取验证码
+type TOTPIssuer struct {
+	verifyService *VerifyService
+	redisClient   *redis.Client
+	issuer        string
+	skew          int
+	maxFailures   int
+	lockout       time.Duration
+}
+
+func NewTOTPIssuer(config *models.Config, verifyService *VerifyService, redisClient *redis.Client) *TOTPIssuer {
+	cfg := config.Verification.TOTP
+	i := &TOTPIssuer{
+		verifyService: verifyService,
+		redisClient:   redisClient,
+		issuer:        cfg.Issuer,
+		skew:          cfg.Skew,
+		maxFailures:   cfg.MaxFailures,
+		lockout:       cfg.Lockout,
+	}
+	if i.issuer == "" {
+		i.issuer = "meea-icey"
+	}
+	if i.skew < 0 {
+		i.skew = 0
+	}
+	if i.maxFailures <= 0 {
+		i.maxFailures = 5
+	}
+	if i.lockout <= 0 {
+		i.lockout = 15 * time.Minute
+	}
+	return i
+}
+
+func (i *TOTPIssuer) Channel() string { return ChannelTOTP }
+
+// Register 为用户生成TOTP密钥并返回otpauth URI，已登记的用户会更换密钥
+func (i *TOTPIssuer) Register(ctx context.Context, user string) (string, error) {
+	if user == "" || strings.ContainsAny(user, ":/") {
+		return "", fmt.Errorf("无效的用户名")
+	}
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("生成密钥失败: %v", err)
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+	if err := i.redisClient.HSet(ctx, totpUsersKey, user, secret).Err(); err != nil {
+		return "", fmt.Errorf("保存密钥失败: %v", err)
+	}
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", i.issuer)
+	q.Set("period", fmt.Sprint(totpPeriod))
+	q.Set("digits", fmt.Sprint(totpDigits))
+	return fmt.Sprintf("otpauth://totp/%s:%s?%s", url.PathEscape(i.issuer), url.PathEscape(user), q.Encode()), nil
+}
+
+// Remove 注销用户的TOTP密钥
+func (i *TOTPIssuer) Remove(ctx context.Context, user string) error {
+	return i.redisClient.HDel(ctx, totpUsersKey, user).Err()
+}
+
+func (i *TOTPIssuer) Issue(ctx context.Context, req *CodeRequest) (*IssuedCode, error) {
+	// 校验前先计入尝试次数，并发请求也无法超过上限，防止暴力尝试6位动态码；成功后清零
+	failuresKey := fmt.Sprintf(totpFailuresKey, req.Recipient)
+	attempts, err := i.attempt(ctx, failuresKey)
+	if err != nil {
+		return nil, err
+	}
+	if attempts > int64(i.maxFailures) {
+		return nil, fmt.Errorf("%w: 错误次数过多，请稍后再试", ErrIssueDenied)
+	}
+
+	secret, err := i.redisClient.HGet(ctx, totpUsersKey, req.Recipient).Result()
+	if err == redis.Nil {
+		return nil, ErrIssueDenied
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取TOTP密钥失败: %v", err)
+	}
+	step, ok := VerifyTOTP(secret, req.Credential, time.Now(), i.skew)
+	if !ok {
+		return nil, ErrIssueDenied
+	}
+	// 每个时间步的动态码只能使用一次
+	usedKey := fmt.Sprintf(totpUsedKey, req.Recipient)
+	ttl := time.Duration((2*i.skew+2)*totpPeriod) * time.Second
+	first, err := i.redisClient.SetNX(ctx, usedKey+":"+fmt.Sprint(step), 1, ttl).Result()
+	if err != nil {
+		return nil, fmt.Errorf("检查动态码失败: %v", err)
+	}
+	if !first {
+		return nil, ErrIssueDenied
+	}
+	i.redisClient.Del(ctx, failuresKey)
+	return issueFor(ctx, i.verifyService, req.Subject, channelOwner(ChannelTOTP, req.Recipient))
+}
+
+// attempt 原子地增加尝试次数并返回增加后的值，锁定时长从最近一次尝试起算
+func (i *TOTPIssuer) attempt(ctx context.Context, failuresKey string) (int64, error) {
+	pipe := i.redisClient.TxPipeline()
+	incr := pipe.Incr(ctx, failuresKey)
+	pipe.Expire(ctx, failuresKey, i.lockout)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("记录TOTP尝试次数失败: %v", err)
+	}
+	return incr.Val(), nil
+}
+
+// VerifyTOTP 校验动态码，允许前后skew个时间步的偏差，返回匹配的时间步
+func VerifyTOTP(secret, code string, now time.Time, skew int) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for d := -skew; d <= skew; d++ {
+		step := current + int64(d)
+		if subtle.ConstantTimeCompare([]byte(TOTPCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPCode 计算指定时间步的动态码(HOTP, SHA1, 6位)
+func TOTPCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
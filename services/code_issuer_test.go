@@ -0,0 +1,327 @@
+package services
+
+import (
+	"context"
+	"encoding/base32"
+	"errors"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"meea-icey/models"
+)
+
+func newTestIssuerDeps(t *testing.T) (*models.Config, *VerifyService, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	config := &models.Config{}
+	config.Verification.MaxAttempts = 3
+	return config, NewVerifyService(client, config), client
+}
+
+// fakeSMTP 只实现SendMail用到的命令，记录收到的邮件
+type fakeSMTP struct {
+	addr string
+
+	mu   sync.Mutex
+	rcpt []string
+	data []string
+}
+
+func startFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &fakeSMTP{addr: ln.Addr().String()}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 localhost")
+		case "MAIL":
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			s.mu.Lock()
+			s.rcpt = append(s.rcpt, strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>"))
+			s.mu.Unlock()
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			lines, err := tp.ReadDotLines()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.data = append(s.data, strings.Join(lines, "\n"))
+			s.mu.Unlock()
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func (s *fakeSMTP) messages() ([]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.rcpt...), append([]string(nil), s.data...)
+}
+
+func TestEmailIssuerSendsCode(t *testing.T) {
+	config, verify, _ := newTestIssuerDeps(t)
+	server := startFakeSMTP(t)
+	config.Verification.Email.SMTPAddr = server.addr
+	config.Verification.Email.From = "noreply@example.com"
+	issuer := NewEmailIssuer(config, verify, verify.redisClient)
+	ctx := context.Background()
+
+	issued, err := issuer.Issue(ctx, &CodeRequest{Subject: "张三", Recipient: "User@Example.com", ClientIP: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !issued.Delivered {
+		t.Error("邮件渠道的验证码应标记为已送达")
+	}
+	rcpt, data := server.messages()
+	if len(rcpt) != 1 || rcpt[0] != "user@example.com" {
+		t.Fatalf("收件人 = %v", rcpt)
+	}
+	if len(data) != 1 || !strings.Contains(data[0], "To: user@example.com") {
+		t.Fatalf("邮件内容 = %v", data)
+	}
+	if ok, err := verify.VerifyCode(ctx, issued.SubjectHash, issued.Code); err != nil || !ok {
+		t.Errorf("邮件中的验证码应可通过校验: ok=%v err=%v", ok, err)
+	}
+
+	// 重发间隔内同一邮箱不再发送
+	if _, err := issuer.Issue(ctx, &CodeRequest{Subject: "张三", Recipient: "user@example.com", ClientIP: "10.0.0.2"}); err == nil {
+		t.Error("重发间隔内应拒绝")
+	}
+	if _, data := server.messages(); len(data) != 1 {
+		t.Errorf("重发间隔内不应发送邮件，已发送 %d 封", len(data))
+	}
+}
+
+func TestEmailIssuerLimitsPerIP(t *testing.T) {
+	config, verify, _ := newTestIssuerDeps(t)
+	server := startFakeSMTP(t)
+	config.Verification.Email.SMTPAddr = server.addr
+	config.Verification.Email.From = "noreply@example.com"
+	config.Verification.Email.PerIPLimit = 2
+	issuer := NewEmailIssuer(config, verify, verify.redisClient)
+	ctx := context.Background()
+
+	for _, to := range []string{"a@example.com", "b@example.com"} {
+		if _, err := issuer.Issue(ctx, &CodeRequest{Subject: "张三", Recipient: to, ClientIP: "10.0.0.1"}); err != nil {
+			t.Fatalf("%s: %v", to, err)
+		}
+	}
+	// 轮换邮箱地址也受来源IP的次数限制
+	if _, err := issuer.Issue(ctx, &CodeRequest{Subject: "张三", Recipient: "c@example.com", ClientIP: "10.0.0.1"}); err == nil {
+		t.Error("超过来源IP的次数上限应拒绝")
+	}
+	if _, err := issuer.Issue(ctx, &CodeRequest{Subject: "张三", Recipient: "c@example.com", ClientIP: "10.0.0.2"}); err != nil {
+		t.Errorf("其他来源IP不受影响: %v", err)
+	}
+	if _, data := server.messages(); len(data) != 3 {
+		t.Errorf("应发送 3 封邮件，实际 %d 封", len(data))
+	}
+}
+
+func TestPartnerIssuer(t *testing.T) {
+	config, verify, _ := newTestIssuerDeps(t)
+	config.Verification.Partners.Keys = append(config.Verification.Partners.Keys, struct {
+		Name string `yaml:"name"`
+		Key  string `yaml:"key"`
+	}{Name: "acme", Key: "secret-key"})
+	issuer := NewPartnerIssuer(config, verify)
+	ctx := context.Background()
+
+	if name, ok := issuer.Authenticate("secret-key"); !ok || name != "acme" {
+		t.Errorf("Authenticate = %q, %v", name, ok)
+	}
+	for _, key := range []string{"", "wrong-key"} {
+		if _, err := issuer.Issue(ctx, &CodeRequest{Subject: "张三", Recipient: "u1", Credential: key}); !errors.Is(err, ErrIssueDenied) {
+			t.Errorf("API Key %q: err = %v, 应为ErrIssueDenied", key, err)
+		}
+	}
+	if _, err := issuer.Issue(ctx, &CodeRequest{Subject: "张三", Credential: "secret-key"}); err == nil {
+		t.Error("缺少user应拒绝")
+	}
+
+	issued, err := issuer.Issue(ctx, &CodeRequest{Subject: "张三", Recipient: "u1", Credential: "secret-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issued.Delivered || issued.Code == "" {
+		t.Error("合作方渠道应在响应中返回验证码")
+	}
+}
+
+func registerTestTOTP(t *testing.T, issuer *TOTPIssuer, user string) []byte {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := issuer.Register(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	secret := issuer.redisClient.HGet(ctx, totpUsersKey, user).Val()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestTOTPIssuerRejectsReplay(t *testing.T) {
+	config, verify, client := newTestIssuerDeps(t)
+	issuer := NewTOTPIssuer(config, verify, client)
+	key := registerTestTOTP(t, issuer, "alice")
+	ctx := context.Background()
+
+	if uri, _ := issuer.Register(ctx, "a:b"); uri != "" {
+		t.Error("用户名包含冒号时应拒绝登记")
+	}
+
+	code := TOTPCode(key, time.Now().Unix()/totpPeriod)
+	if _, err := issuer.Issue(ctx, &CodeRequest{Subject: "张三", Recipient: "alice", Credential: code}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issuer.Issue(ctx, &CodeRequest{Subject: "张三", Recipient: "alice", Credential: code}); !errors.Is(err, ErrIssueDenied) {
+		t.Errorf("重放动态码: err = %v, 应为ErrIssueDenied", err)
+	}
+	if _, err := issuer.Issue(ctx, &CodeRequest{Subject: "张三", Recipient: "bob", Credential: code}); !errors.Is(err, ErrIssueDenied) {
+		t.Errorf("未登记用户: err = %v, 应为ErrIssueDenied", err)
+	}
+}
+
+func TestTOTPIssuerLocksOutAfterFailures(t *testing.T) {
+	config, verify, client := newTestIssuerDeps(t)
+	config.Verification.TOTP.MaxFailures = 3
+	issuer := NewTOTPIssuer(config, verify, client)
+	key := registerTestTOTP(t, issuer, "alice")
+	ctx := context.Background()
+	step := time.Now().Unix() / totpPeriod
+	wrong := TOTPCode(key, step+100)
+
+	// 错误次数未达上限时，成功一次即清零
+	for n := 0; n < 2; n++ {
+		issuer.Issue(ctx, &CodeRequest{Subject: "张三", Recipient: "alice", Credential: wrong})
+	}
+	if _, err := issuer.Issue(ctx, &CodeRequest{Subject: "张三", Recipient: "alice", Credential: TOTPCode(key, step)}); err != nil {
+		t.Fatal(err)
+	}
+	if client.Exists(ctx, "icey:totp:failures:alice").Val() != 0 {
+		t.Error("校验成功后应清除错误次数")
+	}
+
+	for n := 0; n < 3; n++ {
+		if _, err := issuer.Issue(ctx, &CodeRequest{Subject: "张三", Recipient: "alice", Credential: wrong}); !errors.Is(err, ErrIssueDenied) {
+			t.Fatalf("第 %d 次错误: err = %v", n+1, err)
+		}
+	}
+	// 锁定期间正确的动态码同样被拒绝
+	_, err := issuer.Issue(ctx, &CodeRequest{Subject: "张三", Recipient: "alice", Credential: TOTPCode(key, step+1)})
+	if !errors.Is(err, ErrIssueDenied) || !strings.Contains(err.Error(), "错误次数过多") {
+		t.Errorf("锁定期间: err = %v", err)
+	}
+	if ttl := client.TTL(ctx, "icey:totp:failures:alice").Val(); ttl <= 0 || ttl > 15*time.Minute {
+		t.Errorf("锁定时长 = %v, 应为默认的15分钟", ttl)
+	}
+}
+
+func TestTOTPIssuerLimitsConcurrentGuesses(t *testing.T) {
+	config, verify, client := newTestIssuerDeps(t)
+	config.Verification.TOTP.MaxFailures = 3
+	issuer := NewTOTPIssuer(config, verify, client)
+	key := registerTestTOTP(t, issuer, "alice")
+	wrong := TOTPCode(key, time.Now().Unix()/totpPeriod+100)
+
+	// 被锁定的请求不校验动态码，其余请求都校验过动态码
+	var verified, locked int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for n := 0; n < 20; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := issuer.Issue(context.Background(), &CodeRequest{Subject: "张三", Recipient: "alice", Credential: wrong})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && strings.Contains(err.Error(), "错误次数过多") {
+				locked++
+			} else {
+				verified++
+			}
+		}()
+	}
+	wg.Wait()
+	if verified > 3 || verified+locked != 20 {
+		t.Errorf("并发尝试中校验了 %d 次动态码，锁定 %d 次，最多只应校验3次", verified, locked)
+	}
+}
+
+// RFC 6238 附录B的SHA1测试向量(8位)，取末6位与本实现比较
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key)
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, c := range cases {
+		want := c.code[len(c.code)-totpDigits:]
+		if got := TOTPCode(key, c.unix/totpPeriod); got != want {
+			t.Errorf("T=%d: TOTPCode = %s, want %s", c.unix, got, want)
+		}
+		step, ok := VerifyTOTP(secret, want, time.Unix(c.unix, 0), 0)
+		if !ok || step != c.unix/totpPeriod {
+			t.Errorf("T=%d: VerifyTOTP = %d, %v", c.unix, step, ok)
+		}
+	}
+	if _, ok := VerifyTOTP(secret, "287082", time.Unix(59+totpPeriod, 0), 0); ok {
+		t.Error("skew为0时不应接受上一个时间步的动态码")
+	}
+	if _, ok := VerifyTOTP(secret, "287082", time.Unix(59+totpPeriod, 0), 1); !ok {
+		t.Error("skew为1时应接受上一个时间步的动态码")
+	}
+}
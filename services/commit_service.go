@@ -1,12 +1,14 @@
 package services
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"meea-icey/internal/events"
 	"meea-icey/models"
 )
 
@@ -14,24 +16,34 @@ type CommitService struct {
 	config        *models.Config
 	verifyService *VerifyService
 	gitService    *GitService
+	syncer        *RepoSyncer
+	voteStore     *VoteStore
+	reputation    *ReputationService
+	index         *SubjectIndex
+	events        *events.Bus
 }
 
-func NewCommitService(config *models.Config, verifyService *VerifyService, gitService *GitService) *CommitService {
+func NewCommitService(config *models.Config, verifyService *VerifyService, gitService *GitService, syncer *RepoSyncer, voteStore *VoteStore, reputation *ReputationService, index *SubjectIndex, bus *events.Bus) *CommitService {
 	return &CommitService{
 		config:        config,
 		verifyService: verifyService,
 		gitService:    gitService,
+		syncer:        syncer,
+		voteStore:     voteStore,
+		reputation:    reputation,
+		index:         index,
+		events:        bus,
 	}
 }
 
-func (c *CommitService) ProcessCommit(subject, content, code string) (string, error) {
+func (c *CommitService) ProcessCommit(ctx context.Context, subject, content, category, code string) (string, error) {
 	// 验证subject长度
 	if len(subject) < 6 {
 		return "", fmt.Errorf("subject必须至少包含6个字符")
 	}
 
 	// 验证验证码
-	valid, err := c.verifyService.VerifyCode(subject, code)
+	valid, err := c.verifyService.VerifyCode(ctx, subject, code)
 	if err != nil {
 		return "", fmt.Errorf("验证码验证失败: %v", err)
 	}
@@ -39,10 +51,22 @@ func (c *CommitService) ProcessCommit(subject, content, code string) (string, er
 		return "", fmt.Errorf("验证码无效或已过期")
 	}
 
-	// 验证码验证通过，先拉取最新代码
-	if err := c.gitService.PullRepository("icey-storage"); err != nil {
+	// 构建目录路径
+	dirPath, relativePath := BuildSubjectPath(c.config.Repository.ClonePath, subject)
+	if dirPath == "" {
+		return "", fmt.Errorf("无效的subject格式")
+	}
+
+	// 锁定主题清单和本地仓库直到推送完成，加锁时拉取最新代码
+	if err := c.index.Lock(dirPath); err != nil {
+		return "", err
+	}
+	defer c.index.Unlock(dirPath)
+	unlock, err := c.syncer.Lock()
+	if err != nil {
 		return "", fmt.Errorf("拉取仓库失败: %v", err)
 	}
+	defer unlock()
 
 	// 生成文件名前缀
 	fileNamePrefix, err := GenerateFileNamePrefix()
@@ -50,12 +74,6 @@ func (c *CommitService) ProcessCommit(subject, content, code string) (string, er
 		return "", fmt.Errorf("生成文件名前缀失败: %v", err)
 	}
 
-	// 构建目录路径
-	dirPath, relativePath := BuildSubjectPath(c.config.Repository.ClonePath, subject)
-	if dirPath == "" {
-		return "", fmt.Errorf("无效的subject格式")
-	}
-
 	// 创建目录
 	if err := os.MkdirAll(dirPath, 0755); err != nil {
 		return "", fmt.Errorf("创建目录失败: %v", err)
@@ -67,18 +85,10 @@ func (c *CommitService) ProcessCommit(subject, content, code string) (string, er
 		return "", fmt.Errorf("写入SJ文件失败: %v", err)
 	}
 
-	// 创建512字节的bitmap文件 (.bm)
-	bmFilePath := filepath.Join(dirPath, fileNamePrefix+".bm")
-	bmData := make([]byte, 512)
-	if err := CreateFileWithContent(bmFilePath, bmData, 0644); err != nil {
-		return "", fmt.Errorf("写入BM文件失败: %v", err)
-	}
-
-	// 创建512字节的bitmap文件 (.bmi)
-	bmiFilePath := filepath.Join(dirPath, fileNamePrefix+".bmi")
-	bmiData := make([]byte, 512)
-	if err := CreateFileWithContent(bmiFilePath, bmiData, 0644); err != nil {
-		return "", fmt.Errorf("写入BMI文件失败: %v", err)
+	// 创建空的投票文件 (.vt)
+	vtFilePath := filepath.Join(dirPath, fileNamePrefix+VoteFileExt)
+	if err := c.voteStore.Create(vtFilePath); err != nil {
+		return "", fmt.Errorf("写入VT文件失败: %v", err)
 	}
 
 	// 生成36位随机token
@@ -99,42 +109,37 @@ func (c *CommitService) ProcessCommit(subject, content, code string) (string, er
 		return "", fmt.Errorf("写入DT文件失败: %v", err)
 	}
 
+	// 登记到主题清单
+	if err := c.index.OnCommit(dirPath, fileNamePrefix, category); err != nil {
+		return "", fmt.Errorf("更新主题清单失败: %v", err)
+	}
+
 	// 提交到Git仓库 - 确保所有文件都被提交
 	commitMsg := fmt.Sprintf("%s-%s", subject, id)
 	filesToCommit := []string{
 		filepath.Join(relativePath, fileNamePrefix+".sj"),
-		filepath.Join(relativePath, fileNamePrefix+".bm"),
-		filepath.Join(relativePath, fileNamePrefix+".bmi"),
+		filepath.Join(relativePath, fileNamePrefix+VoteFileExt),
 		filepath.Join(relativePath, fileNamePrefix+".dt"),
+		filepath.Join(relativePath, ManifestFileName),
+		filepath.Join(relativePath, SummaryFileName),
 	}
 
-	// 调试日志：打印完整文件路径和内容
-	log.SetOutput(os.Stdout)
-	logger := log.New(os.Stdout, "", log.LstdFlags)
-	logger.Println("===== 提交前文件状态 =====")
+	// 调试日志：提交前的文件状态
 	for _, file := range filesToCommit {
 		fullPath := filepath.Join(c.config.Repository.ClonePath, "icey-storage", file)
-		logger.Printf("文件路径: %s\n存在状态: %v\n大小: %d bytes\n",
-			fullPath, FileExists(fullPath), GetFileSize(fullPath))
-
-		// 检查文件内容
-		if FileExists(fullPath) {
-			content, _ := os.ReadFile(fullPath)
-			logger.Printf("文件内容(前64字节): %x\n", content[:Min(len(content), 64)])
-		}
+		slog.DebugContext(ctx, "提交前文件状态", "file", file, "exists", FileExists(fullPath), "size", GetFileSize(fullPath))
 	}
 
 	if err := c.gitService.CommitChanges("icey-storage", filesToCommit, commitMsg); err != nil {
+		slog.ErrorContext(ctx, "Git提交失败", "error", err)
 		return "", fmt.Errorf("Git提交失败: %v", err)
 	}
+	c.reputation.RecordSubmission(subject, fileNamePrefix, c.verifyService.CodeOwner(subject, code))
+	c.events.Publish(events.New(events.RecordCreated, subject, fileNamePrefix, map[string]interface{}{
+		"category": category,
+	}))
 
-	// 提交后验证文件状态
-	logger.Println("===== 提交后文件状态 =====")
-	for _, file := range filesToCommit {
-		fullPath := filepath.Join(c.config.Repository.ClonePath, "icey-storage", file)
-		logger.Printf("文件路径: %s\n存在状态: %v\n大小: %d bytes\n",
-			fullPath, FileExists(fullPath), GetFileSize(fullPath))
-	}
+	slog.InfoContext(ctx, "记录已提交", "id", fileNamePrefix, "category", category)
 
 	// 返回拼接后的完整token
 	return fullToken, nil
@@ -1,12 +1,13 @@
 package services
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"strings"
 
+	"meea-icey/internal/events"
 	"meea-icey/models"
 )
 
@@ -14,129 +15,130 @@ type DeleteService struct {
 	config        *models.Config
 	verifyService *VerifyService
 	gitService    *GitService
+	syncer        *RepoSyncer
+	index         *SubjectIndex
+	events        *events.Bus
 }
 
-func NewDeleteService(config *models.Config, verifyService *VerifyService, gitService *GitService) *DeleteService {
+func NewDeleteService(config *models.Config, verifyService *VerifyService, gitService *GitService, syncer *RepoSyncer, index *SubjectIndex, bus *events.Bus) *DeleteService {
 	return &DeleteService{
 		config:        config,
 		verifyService: verifyService,
 		gitService:    gitService,
+		syncer:        syncer,
+		index:         index,
+		events:        bus,
 	}
 }
 
-func (d *DeleteService) ProcessDelete(subject string, code string, token string) error {
-	log.SetOutput(os.Stdout)
-	logger := log.New(os.Stdout, "[DELETE] ", log.LstdFlags)
-	logger.Printf("开始处理删除请求: subject=%s, code=%s", subject, code)
-	
+func (d *DeleteService) ProcessDelete(ctx context.Context, subject string, code string, token string) error {
+	slog.DebugContext(ctx, "开始处理删除请求")
+
 	// 1. 验证验证码
-	valid, err := d.verifyService.VerifyCode(subject, code)
+	valid, err := d.verifyService.VerifyCode(ctx, subject, code)
 	if err != nil {
 		return fmt.Errorf("验证码验证失败: %v", err)
 	}
 	if !valid {
 		return fmt.Errorf("验证码无效或已过期")
 	}
-	logger.Printf("验证码验证通过")
 
 	// 2. 从token中分割出文件id
 	tokenStr, fileId, err := ParseTokenAndID(token)
 	if err != nil {
 		return fmt.Errorf("解析token失败: %v", err)
 	}
-	logger.Printf("解析文件ID: %s", fileId)
 
 	// 3. 构建目录路径
 	dirPath, relativePath := BuildSubjectPath(d.config.Repository.ClonePath, subject)
 	if dirPath == "" {
 		return fmt.Errorf("无效的subject格式")
 	}
-	logger.Printf("目标目录路径: %s", dirPath)
 
-	// 4. 拉取仓库（如果本地没有仓库就clone，有就pull）
-	if _, err := os.Stat(d.config.Repository.ClonePath); os.IsNotExist(err) {
-		logger.Printf("本地仓库不存在，开始克隆")
-		if err := d.gitService.CloneRepository(); err != nil {
-			return fmt.Errorf("仓库克隆失败: %v", err)
-		}
-		logger.Printf("仓库克隆成功")
-	} else {
-		logger.Printf("本地仓库存在，开始拉取最新代码")
-		// 注意：这里传入的是仓库名称，不是完整路径
-		if err := d.gitService.PullRepository("icey-storage"); err != nil {
-			return fmt.Errorf("git pull失败: %v", err)
-		}
-		logger.Printf("git pull成功")
+	// 4. 锁定主题清单和本地仓库直到推送完成，加锁时同步拉取仓库（本地没有仓库时会先clone）
+	if err := d.index.Lock(dirPath); err != nil {
+		return err
 	}
+	defer d.index.Unlock(dirPath)
+	unlock, err := d.syncer.Lock()
+	if err != nil {
+		slog.ErrorContext(ctx, "拉取仓库失败", "error", err)
+		return fmt.Errorf("git pull失败: %v", err)
+	}
+	defer unlock()
 
-	// 5. 查找.dt文件
-	dtFilePath := filepath.Join(dirPath, "*-"+fileId+".dt")
-	dtFiles, err := filepath.Glob(dtFilePath)
+	// 5. 通过主题清单查找记录
+	rec, err := d.index.Lookup(dirPath, fileId)
 	if err != nil {
-		return fmt.Errorf("查找文件失败: %v", err)
+		return fmt.Errorf("读取主题清单失败: %v", err)
 	}
-	if len(dtFiles) == 0 {
-		logger.Printf("未找到匹配的.dt文件: %s", dtFilePath)
+	if rec == nil {
+		slog.InfoContext(ctx, "清单中未找到记录", "id", fileId)
 		return fmt.Errorf("未找到对应的文件记录")
 	}
-	logger.Printf("找到.dt文件: %s", dtFiles[0])
+	filePrefix := rec.Prefix
+	dtFile := filepath.Join(dirPath, filePrefix+".dt")
 
 	// 6. 读取.dt文件内容并验证token
-	hashedToken, err := os.ReadFile(dtFiles[0])
+	hashedToken, err := os.ReadFile(dtFile)
 	if err != nil {
 		return fmt.Errorf("读取token文件失败: %v", err)
 	}
 
 	// 验证token
 	if err := ValidateToken(hashedToken, subject, tokenStr); err != nil {
+		slog.WarnContext(ctx, "删除token验证失败", "id", filePrefix)
 		return fmt.Errorf("token验证失败")
 	}
-	logger.Printf("token验证通过")
 
 	// 7. 删除相关文件
-	filePrefix := strings.TrimSuffix(filepath.Base(dtFiles[0]), ".dt")
 	filesToDelete := []string{
 		filepath.Join(dirPath, filePrefix+".sj"),
-		filepath.Join(dirPath, filePrefix+".bm"),
-		filepath.Join(dirPath, filePrefix+".bmi"),
-		dtFiles[0],
+		filepath.Join(dirPath, filePrefix+VoteFileExt),
+		filepath.Join(dirPath, filePrefix+legacyBitmapExt),
+		filepath.Join(dirPath, filePrefix+legacyBitmapIdxExt),
+		dtFile,
+	}
+
+	// 在主题清单中标记删除
+	if err := d.index.OnDelete(dirPath, filePrefix); err != nil {
+		return fmt.Errorf("更新主题清单失败: %v", err)
 	}
 
 	// 记录实际删除的文件
 	var deletedFiles []string
 	for _, file := range filesToDelete {
 		if _, err := os.Stat(file); os.IsNotExist(err) {
-			logger.Printf("文件不存在，跳过: %s", file)
 			continue
 		}
-		logger.Printf("正在删除文件: %s", file)
 		if err := os.Remove(file); err != nil {
+			slog.ErrorContext(ctx, "删除文件失败", "file", filepath.Base(file), "error", err)
 			return fmt.Errorf("删除文件失败: %v", err)
 		}
-		logger.Printf("文件删除成功: %s", file)
+		slog.DebugContext(ctx, "文件已删除", "file", filepath.Base(file))
 		deletedFiles = append(deletedFiles, file)
 	}
 
 	// 8. 提交删除到远程仓库
 	if len(deletedFiles) > 0 {
-		filesToCommit := []string{
-			filepath.Join(relativePath, filePrefix+".sj"),
-			filepath.Join(relativePath, filePrefix+".bm"),
-			filepath.Join(relativePath, filePrefix+".bmi"),
-			filepath.Join(relativePath, filePrefix+".dt"),
+		var filesToCommit []string
+		for _, file := range deletedFiles {
+			filesToCommit = append(filesToCommit, filepath.Join(relativePath, filepath.Base(file)))
 		}
+		filesToCommit = append(filesToCommit,
+			filepath.Join(relativePath, ManifestFileName),
+			filepath.Join(relativePath, SummaryFileName),
+		)
 
-		logger.Printf("准备提交删除的文件: %v", filesToCommit)
 		commitMsg := fmt.Sprintf("delete %s - %s", subject, filePrefix)
 		if err := d.gitService.CommitChanges("icey-storage", filesToCommit, commitMsg); err != nil {
-			logger.Printf("git提交失败: %v", err)
+			slog.ErrorContext(ctx, "Git提交失败", "error", err)
 			return fmt.Errorf("git提交失败: %v", err)
 		}
-		logger.Printf("git提交成功: %s", commitMsg)
+		d.events.Publish(events.New(events.RecordDeleted, subject, filePrefix, nil))
+		slog.InfoContext(ctx, "记录已删除", "id", filePrefix, "files", len(deletedFiles))
 	} else {
-		logger.Printf("没有文件被删除")
+		slog.InfoContext(ctx, "没有文件被删除", "id", filePrefix)
 	}
-
-	logger.Printf("删除操作完成")
 	return nil
 }
\ No newline at end of file
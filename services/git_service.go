@@ -8,19 +8,48 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	cryptossh "golang.org/x/crypto/ssh"
+	"meea-icey/internal/logging"
+	"meea-icey/internal/metrics"
 )
 
+var gitLog = logging.Component("git")
+
+// ErrFileLocked 文件已被其他请求或实例锁定
+var ErrFileLocked = errors.New("锁定文件失败: 已被他人占用，请稍后重试")
+
 // GitService 封装Git相关操作
 type GitService struct {
 	clonePath     string
 	repositoryURL string
 	sshKey        string
+
+	listenersMu sync.RWMutex
+	listeners   []func(paths []string)
+
+	// 本地仓库不支持Git LFS锁，改用进程内的文件锁
+	localLocksMu sync.Mutex
+	localLocks   map[string]bool
+
+	// 进行中的拉取/提交/推送，关闭服务时等待其完成
+	opsMu    sync.Mutex
+	ops      sync.WaitGroup
+	draining bool
+	// 本进程持有的文件锁，关闭服务时释放
+	heldMu sync.Mutex
+	held   map[string]bool
+
+	// 最近一次成功推送的时间，供就绪检查使用
+	pushMu     sync.RWMutex
+	lastPushAt time.Time
 }
 
 // NewGitService 创建GitService实例
@@ -28,22 +57,42 @@ type GitService struct {
 //
 //	clonePath: 仓库克隆目标路径
 //	repositoryURL: 远程仓库URL
-//	sshKey: SSH私钥内容
+//	sshKey: SSH私钥内容，远程仓库为本地路径(file://)时可为空
 //
 // 返回:
 //
-//	初始化成功的GitService实例和nil错误；若SSH密钥为空则返回nil和错误信息
+//	初始化成功的GitService实例和nil错误；若远程仓库需要SSH而密钥为空则返回nil和错误信息
 func NewGitService(clonePath, repositoryURL, sshKey string) (*GitService, error) {
-	if sshKey == "" {
+	if sshKey == "" && !IsLocalRepository(repositoryURL) {
 		return nil, fmt.Errorf("SSH密钥不能为空")
 	}
 	return &GitService{
 		clonePath:     clonePath,
 		repositoryURL: repositoryURL,
 		sshKey:        sshKey,
+		localLocks:    make(map[string]bool),
+		held:          make(map[string]bool),
 	}, nil
 }
 
+// IsLocalRepository 判断远程仓库是否为本地路径(file://或绝对路径)，常用于测试和单机部署
+func IsLocalRepository(repositoryURL string) bool {
+	return strings.HasPrefix(repositoryURL, "file://") || filepath.IsAbs(repositoryURL)
+}
+
+// isLocal 远程仓库是否为本地路径
+func (g *GitService) isLocal() bool {
+	return IsLocalRepository(g.repositoryURL)
+}
+
+// transportAuth 返回访问远程仓库的认证方式，本地仓库无需认证
+func (g *GitService) transportAuth() (transport.AuthMethod, error) {
+	if g.isLocal() {
+		return nil, nil
+	}
+	return g.getSSHAuth()
+}
+
 // getSSHAuth 获取SSH认证配置
 func (g *GitService) getSSHAuth() (*gitssh.PublicKeys, error) {
 	if g.sshKey == "" {
@@ -119,7 +168,14 @@ func (g *GitService) BuildSubjectPath(subject string) (string, string) {
 //
 //	克隆成功返回nil；若SSH密钥未配置或克隆失败则返回相应错误
 func (g *GitService) CloneRepository() error {
-	if g.sshKey == "" {
+	start := time.Now()
+	err := g.cloneRepository()
+	metrics.GitOperation("clone", start, err)
+	return err
+}
+
+func (g *GitService) cloneRepository() error {
+	if g.sshKey == "" && !g.isLocal() {
 		return errors.New("SSH密钥未配置")
 	}
 
@@ -138,7 +194,7 @@ func (g *GitService) CloneRepository() error {
 	}
 
 	// 获取SSH认证
-	auth, err := g.getSSHAuth()
+	auth, err := g.transportAuth()
 	if err != nil {
 		return err
 	}
@@ -155,16 +211,23 @@ func (g *GitService) CloneRepository() error {
 	return nil
 }
 
-// PullRepository 拉取Git仓库最新代码
-// 参数:
-//
-//	dirName: 本地仓库目录名称
-//
+// PullRepository 拉取Git仓库最新代码，本地仓库不存在时先克隆
 // 返回:
 //
-//	拉取成功返回nil；若目录不存在、仓库验证失败或拉取失败则返回相应错误
-func (g *GitService) PullRepository(dirName string) error {
-	if g.sshKey == "" {
+//	拉取成功返回nil；若仓库验证失败或拉取失败则返回相应错误
+func (g *GitService) PullRepository() error {
+	if err := g.beginOp(); err != nil {
+		return err
+	}
+	defer g.ops.Done()
+	start := time.Now()
+	err := g.pullRepository()
+	metrics.GitOperation("pull", start, err)
+	return err
+}
+
+func (g *GitService) pullRepository() error {
+	if g.sshKey == "" && !g.isLocal() {
 		return fmt.Errorf("SSH密钥未配置")
 	}
 	// 统一仓库目录为 clonePath/icey-storage
@@ -202,11 +265,17 @@ func (g *GitService) PullRepository(dirName string) error {
 	}
 
 	// 获取SSH认证
-	auth, err := g.getSSHAuth()
+	auth, err := g.transportAuth()
 	if err != nil {
 		return err
 	}
 
+	// 记录拉取前的HEAD，用于计算本次拉取变更的文件
+	var oldHead plumbing.Hash
+	if ref, err := r.Head(); err == nil {
+		oldHead = ref.Hash()
+	}
+
 	// 拉取最新代码
 	err = worktree.PullContext(context.Background(), &git.PullOptions{
 		RemoteName: "origin",
@@ -215,30 +284,146 @@ func (g *GitService) PullRepository(dirName string) error {
 	if err != nil && err != git.NoErrAlreadyUpToDate {
 		return fmt.Errorf("拉取代码失败: %v", err)
 	}
+	if err == git.NoErrAlreadyUpToDate || oldHead.IsZero() {
+		return nil
+	}
 
+	ref, err := r.Head()
+	if err != nil || ref.Hash() == oldHead {
+		return nil
+	}
+	paths, err := changedPaths(r, oldHead, ref.Hash())
+	if err != nil {
+		gitLog.Warn("计算变更文件失败", "error", err)
+		return nil
+	}
+	g.notifyChanged(paths)
 	return nil
 }
 
+// OnChange 注册仓库文件变更回调，本地提交和拉取到远程变更时调用，paths为仓库内相对路径
+func (g *GitService) OnChange(fn func(paths []string)) {
+	g.listenersMu.Lock()
+	defer g.listenersMu.Unlock()
+	g.listeners = append(g.listeners, fn)
+}
+
+func (g *GitService) notifyChanged(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	g.listenersMu.RLock()
+	defer g.listenersMu.RUnlock()
+	for _, fn := range g.listeners {
+		fn(paths)
+	}
+}
+
+// changedPaths 返回两个提交之间变更的文件路径
+func changedPaths(r *git.Repository, from, to plumbing.Hash) ([]string, error) {
+	fromCommit, err := r.CommitObject(from)
+	if err != nil {
+		return nil, err
+	}
+	toCommit, err := r.CommitObject(to)
+	if err != nil {
+		return nil, err
+	}
+	fromTree, err := fromCommit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := toCommit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, c := range changes {
+		if c.From.Name != "" {
+			paths = append(paths, c.From.Name)
+		}
+		if c.To.Name != "" && c.To.Name != c.From.Name {
+			paths = append(paths, c.To.Name)
+		}
+	}
+	return paths, nil
+}
+
+// LastPushAt 返回本进程最近一次成功推送的时间，尚未推送过时为零值
+func (g *GitService) LastPushAt() time.Time {
+	g.pushMu.RLock()
+	defer g.pushMu.RUnlock()
+	return g.lastPushAt
+}
+
+func (g *GitService) markPushed() {
+	g.pushMu.Lock()
+	g.lastPushAt = time.Now()
+	g.pushMu.Unlock()
+}
+
+// HeadCommit 返回本地仓库当前HEAD的提交哈希
+func (g *GitService) HeadCommit() (string, error) {
+	r, err := git.PlainOpen(g.getRepoRoot())
+	if err != nil {
+		return "", fmt.Errorf("打开仓库失败: %v", err)
+	}
+	ref, err := r.Head()
+	if err != nil {
+		return "", fmt.Errorf("读取HEAD失败: %v", err)
+	}
+	return ref.Hash().String(), nil
+}
+
 // getRepoRoot 获取仓库根目录
 func (g *GitService) getRepoRoot() string {
 	return filepath.Join(g.clonePath, "icey-storage")
 }
 
-// LockFile 锁定Git LFS文件
+// LockFile 锁定文件，成功后记录为本进程持有
 func (g *GitService) LockFile(filePath string) error {
+	start := time.Now()
+	err := g.lockFile(filePath)
+	metrics.LockAcquire(start, errors.Is(err, ErrFileLocked), err)
+	if err != nil {
+		return err
+	}
+	g.heldMu.Lock()
+	g.held[filePath] = true
+	g.heldMu.Unlock()
+	return nil
+}
+
+// UnlockFile 解锁文件
+func (g *GitService) UnlockFile(filePath string) error {
+	if err := g.unlockFile(filePath); err != nil {
+		return err
+	}
+	g.heldMu.Lock()
+	delete(g.held, filePath)
+	g.heldMu.Unlock()
+	return nil
+}
+
+// lockFile 锁定Git LFS文件
+func (g *GitService) lockFile(filePath string) error {
 	repoRoot := g.getRepoRoot()
 	relPath := filePath
 	if filepath.IsAbs(filePath) {
 		var err error
 		relPath, err = filepath.Rel(repoRoot, filePath)
 		if err != nil {
-			fmt.Printf("[LockFile] 路径转换失败: repoRoot=%s, filePath=%s, err=%v\n", repoRoot, filePath, err)
 			return fmt.Errorf("转换为相对路径失败: %v", err)
 		}
 	}
-	fmt.Printf("[LockFile] repoRoot=%s\n", repoRoot)
-	fmt.Printf("[LockFile] filePath=%s\n", filePath)
-	fmt.Printf("[LockFile] relPath=%s\n", relPath)
+	if g.isLocal() {
+		return g.lockLocal(relPath)
+	}
 	cmd := exec.Command("git", "lfs", "lock", relPath)
 	cmd.Dir = repoRoot
 	sshKeyPath := g.sshKey
@@ -248,9 +433,9 @@ func (g *GitService) LockFile(filePath string) error {
 	cmd.Env = append(os.Environ(),
 		"GIT_SSH_COMMAND=ssh -i '"+sshKeyPath+"' -o IdentitiesOnly=yes -o UserKnownHostsFile=/app/.ssh/known_hosts",
 	)
-	fmt.Printf("[LockFile] 执行命令: cd %s && GIT_SSH_COMMAND=ssh -i '%s' -o IdentitiesOnly=yes git lfs lock %s\n", repoRoot, sshKeyPath, relPath)
+	gitLog.Debug("执行git lfs lock", "file", relPath, "ssh_key", sshKeyPath)
 	output, err := cmd.CombinedOutput()
-	fmt.Printf("[LockFile] 命令输出: %s\n", string(output))
+	gitLog.Debug("git lfs lock输出", "file", relPath, "output", string(output))
 	if err != nil && string(output) != "" && strings.Contains(string(output), "Lock exists") {
 		// 检查锁 owner
 		owner := ""
@@ -287,7 +472,7 @@ func (g *GitService) LockFile(filePath string) error {
 				"GIT_SSH_COMMAND=ssh -i '"+sshKeyPath+"' -o IdentitiesOnly=yes -o UserKnownHostsFile=/app/.ssh/known_hosts",
 			)
 			unlockOut, unlockErr := unlockCmd.CombinedOutput()
-			fmt.Printf("[LockFile] unlock输出: %s\n", string(unlockOut))
+			gitLog.Debug("git lfs unlock输出", "file", relPath, "output", string(unlockOut))
 			if unlockErr != nil {
 				return fmt.Errorf("自动解锁失败: %v, 输出: %s", unlockErr, string(unlockOut))
 			}
@@ -298,23 +483,23 @@ func (g *GitService) LockFile(filePath string) error {
 				"GIT_SSH_COMMAND=ssh -i '"+sshKeyPath+"' -o IdentitiesOnly=yes -o UserKnownHostsFile=/app/.ssh/known_hosts",
 			)
 			output2, err2 := cmd2.CombinedOutput()
-			fmt.Printf("[LockFile] retry lock输出: %s\n", string(output2))
+			gitLog.Debug("重试git lfs lock输出", "file", relPath, "output", string(output2))
 			if err2 != nil {
 				return fmt.Errorf("重试加锁失败: %v, 输出: %s", err2, string(output2))
 			}
 			return nil
 		}
-		return fmt.Errorf("锁定文件失败: 已被他人占用，请稍后重试")
+		return ErrFileLocked
 	}
 	if err != nil {
-		fmt.Printf("[LockFile] 错误: %v\n", err)
+		gitLog.Warn("git lfs lock失败", "file", relPath, "error", err)
 		return fmt.Errorf("锁定文件失败: %v, 输出: %s", err, string(output))
 	}
 	return nil
 }
 
-// UnlockFile 解锁Git LFS文件
-func (g *GitService) UnlockFile(filePath string) error {
+// unlockFile 解锁Git LFS文件
+func (g *GitService) unlockFile(filePath string) error {
 	repoRoot := g.getRepoRoot()
 	relPath := filePath
 	if filepath.IsAbs(filePath) {
@@ -324,6 +509,10 @@ func (g *GitService) UnlockFile(filePath string) error {
 			return fmt.Errorf("转换为相对路径失败: %v", err)
 		}
 	}
+	if g.isLocal() {
+		g.unlockLocal(relPath)
+		return nil
+	}
 	cmd := exec.Command("git", "lfs", "unlock", relPath)
 	cmd.Dir = repoRoot
 	sshKeyPath := g.sshKey
@@ -353,6 +542,23 @@ func (g *GitService) UnlockFile(filePath string) error {
 	return nil
 }
 
+// lockLocal 进程内加锁，已被锁定时返回错误，与LFS锁被他人占用时的行为一致
+func (g *GitService) lockLocal(relPath string) error {
+	g.localLocksMu.Lock()
+	defer g.localLocksMu.Unlock()
+	if g.localLocks[relPath] {
+		return ErrFileLocked
+	}
+	g.localLocks[relPath] = true
+	return nil
+}
+
+func (g *GitService) unlockLocal(relPath string) {
+	g.localLocksMu.Lock()
+	defer g.localLocksMu.Unlock()
+	delete(g.localLocks, relPath)
+}
+
 // CommitFile 提交单个文件变更
 func (g *GitService) CommitFile(filePath, message string) error {
 	// 获取仓库目录和相对路径
@@ -363,7 +569,11 @@ func (g *GitService) CommitFile(filePath, message string) error {
 
 // CommitChanges stages, commits and pushes changes to the Git repository
 func (g *GitService) CommitChanges(repoDir string, files []string, commitMsg string) error {
-	if g.sshKey == "" {
+	if err := g.beginOp(); err != nil {
+		return err
+	}
+	defer g.ops.Done()
+	if g.sshKey == "" && !g.isLocal() {
 		return errors.New("SSH密钥未配置")
 	}
 
@@ -382,6 +592,7 @@ func (g *GitService) CommitChanges(repoDir string, files []string, commitMsg str
 	}
 
 	// 处理文件变更（添加新文件或删除已删除的文件）
+	commitStart := time.Now()
 	for _, file := range files {
 		fullPath := filepath.Join(fullRepoPath, file)
 
@@ -389,12 +600,16 @@ func (g *GitService) CommitChanges(repoDir string, files []string, commitMsg str
 		if _, err := os.Stat(fullPath); os.IsNotExist(err) {
 			// 文件不存在，说明是删除操作，使用git rm
 			if _, err := w.Remove(file); err != nil {
-				return fmt.Errorf("从Git中移除文件失败: %v", err)
+				err = fmt.Errorf("从Git中移除文件失败: %v", err)
+				metrics.GitOperation("commit", commitStart, err)
+				return err
 			}
 		} else {
 			// 文件存在，添加到暂存区
 			if _, err := w.Add(file); err != nil {
-				return fmt.Errorf("添加文件到暂存区失败: %v", err)
+				err = fmt.Errorf("添加文件到暂存区失败: %v", err)
+				metrics.GitOperation("commit", commitStart, err)
+				return err
 			}
 		}
 	}
@@ -407,23 +622,31 @@ func (g *GitService) CommitChanges(repoDir string, files []string, commitMsg str
 			When:  time.Now(),
 		},
 	})
+	metrics.GitOperation("commit", commitStart, err)
 	if err != nil {
 		return fmt.Errorf("提交变更失败: %v", err)
 	}
+	g.notifyChanged(files)
 
 	// 获取SSH认证
-	auth, err := g.getSSHAuth()
+	auth, err := g.transportAuth()
 	if err != nil {
 		return err
 	}
 
 	// 推送变更
+	pushStart := time.Now()
 	err = r.Push(&git.PushOptions{
 		Auth: auth,
 	})
-	if err != nil && err != git.NoErrAlreadyUpToDate {
+	if err == git.NoErrAlreadyUpToDate {
+		err = nil
+	}
+	metrics.GitOperation("push", pushStart, err)
+	if err != nil {
 		return fmt.Errorf("推送变更失败: %v", err)
 	}
+	g.markPushed()
 
 	return nil
 }
@@ -439,6 +662,11 @@ func (g *GitService) IsFileLocked(filePath string) (bool, error) {
 			return false, fmt.Errorf("转换为相对路径失败: %v", err)
 		}
 	}
+	if g.isLocal() {
+		g.localLocksMu.Lock()
+		defer g.localLocksMu.Unlock()
+		return g.localLocks[relPath], nil
+	}
 	cmd := exec.Command("git", "lfs", "locks")
 	cmd.Dir = repoRoot
 	sshKeyPath := g.sshKey
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"meea-icey/internal/metrics"
+)
+
+// ErrGitDraining 服务正在关闭，不再接受新的Git操作
+var ErrGitDraining = errors.New("服务正在关闭，请稍后重试")
+
+// beginOp 登记一个进行中的Git操作，关闭过程中返回ErrGitDraining
+func (g *GitService) beginOp() error {
+	g.opsMu.Lock()
+	defer g.opsMu.Unlock()
+	if g.draining {
+		return ErrGitDraining
+	}
+	g.ops.Add(1)
+	return nil
+}
+
+// Draining 是否正在关闭，关闭过程中不再接受新的Git操作
+func (g *GitService) Draining() bool {
+	g.opsMu.Lock()
+	defer g.opsMu.Unlock()
+	return g.draining
+}
+
+// Drain 拒绝新的Git操作并等待进行中的拉取、提交和推送完成，ctx到期时返回错误
+func (g *GitService) Drain(ctx context.Context) error {
+	g.opsMu.Lock()
+	g.draining = true
+	g.opsMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		g.ops.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("等待Git操作完成超时: %v", ctx.Err())
+	}
+}
+
+// ReleaseLocks 释放本进程仍持有的文件锁，返回释放失败的数量
+func (g *GitService) ReleaseLocks() int {
+	g.heldMu.Lock()
+	paths := make([]string, 0, len(g.held))
+	for p := range g.held {
+		paths = append(paths, p)
+	}
+	g.heldMu.Unlock()
+
+	failed := 0
+	for _, p := range paths {
+		if err := g.UnlockFile(p); err != nil {
+			gitLog.Warn("释放文件锁失败", "file", p, "error", err)
+			failed++
+			continue
+		}
+		gitLog.Info("已释放文件锁", "file", p)
+	}
+	return failed
+}
+
+// RecoverWorktree 启动时清理上次异常退出留下的工作区：
+// 丢弃未提交的文件(对应的请求未成功，不应保留)，推送已提交但未推送的记录
+func (g *GitService) RecoverWorktree() error {
+	repoRoot := g.getRepoRoot()
+	if _, err := os.Stat(filepath.Join(repoRoot, ".git")); os.IsNotExist(err) {
+		return nil
+	}
+	r, err := git.PlainOpen(repoRoot)
+	if err != nil {
+		return fmt.Errorf("打开仓库失败: %v", err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return fmt.Errorf("获取工作区失败: %v", err)
+	}
+	head, err := r.Head()
+	if err != nil {
+		return fmt.Errorf("读取HEAD失败: %v", err)
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return fmt.Errorf("读取工作区状态失败: %v", err)
+	}
+	if !status.IsClean() {
+		gitLog.Warn("工作区有未提交的文件，恢复到HEAD", "files", len(status), "head", head.Hash().String()[:7])
+		if err := w.Reset(&git.ResetOptions{Mode: git.HardReset, Commit: head.Hash()}); err != nil {
+			return fmt.Errorf("重置工作区失败: %v", err)
+		}
+		if err := w.Clean(&git.CleanOptions{Dir: true}); err != nil {
+			return fmt.Errorf("清理未跟踪文件失败: %v", err)
+		}
+	}
+
+	ahead, err := unpushed(r, head)
+	if err != nil || !ahead {
+		return err
+	}
+	gitLog.Warn("存在未推送的提交，重新推送")
+	auth, err := g.transportAuth()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = r.Push(&git.PushOptions{Auth: auth})
+	if err == git.NoErrAlreadyUpToDate {
+		err = nil
+	}
+	metrics.GitOperation("push", start, err)
+	if err != nil {
+		return fmt.Errorf("推送未推送的提交失败: %v", err)
+	}
+	g.markPushed()
+	return nil
+}
+
+// unpushed 判断HEAD是否领先于远程跟踪分支(远程分支是HEAD的祖先)
+func unpushed(r *git.Repository, head *plumbing.Reference) (bool, error) {
+	if !head.Name().IsBranch() {
+		return false, nil
+	}
+	remoteRef, err := r.Reference(plumbing.NewRemoteReferenceName("origin", head.Name().Short()), true)
+	if err == plumbing.ErrReferenceNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("读取远程分支失败: %v", err)
+	}
+	if remoteRef.Hash() == head.Hash() {
+		return false, nil
+	}
+	local, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return false, fmt.Errorf("读取本地提交失败: %v", err)
+	}
+	remote, err := r.CommitObject(remoteRef.Hash())
+	if err != nil {
+		return false, fmt.Errorf("读取远程提交失败: %v", err)
+	}
+	return remote.IsAncestor(local)
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"meea-icey/models"
+)
+
+// 检查结果状态
+const (
+	HealthOK   = "ok"
+	HealthWarn = "warn"
+	HealthFail = "fail"
+)
+
+// 默认每项检查的超时时间
+const defaultHealthCheckTimeout = 2 * time.Second
+
+// HealthCheckFunc 执行一项检查，返回的detail原样写入JSON；
+// 返回Warning包装的错误时结果为warn，不影响就绪状态
+type HealthCheckFunc func(ctx context.Context) (detail interface{}, err error)
+
+// healthWarning 不影响就绪状态的异常
+type healthWarning struct{ msg string }
+
+func (w *healthWarning) Error() string { return w.msg }
+
+// Warning 返回只产生警告的检查错误
+func Warning(format string, args ...interface{}) error {
+	return &healthWarning{msg: fmt.Sprintf(format, args...)}
+}
+
+// CheckResult 单项检查结果
+type CheckResult struct {
+	Status     string      `json:"status"`
+	Error      string      `json:"error,omitempty"`
+	Detail     interface{} `json:"detail,omitempty"`
+	DurationMS int64       `json:"duration_ms"`
+}
+
+// HealthReport 全部检查的汇总
+type HealthReport struct {
+	Status    string                 `json:"status"`
+	CheckedAt time.Time              `json:"checked_at"`
+	Checks    map[string]CheckResult `json:"checks"`
+}
+
+// Ready 没有失败的检查
+func (r HealthReport) Ready() bool {
+	return r.Status != HealthFail
+}
+
+type namedCheck struct {
+	name string
+	fn   HealthCheckFunc
+}
+
+// HealthService 并发执行已注册的就绪检查，每项检查有独立的超时
+type HealthService struct {
+	timeout time.Duration
+	started time.Time
+
+	mu     sync.RWMutex
+	checks []namedCheck
+}
+
+// NewHealthService 根据配置创建HealthService实例
+func NewHealthService(config *models.Config) *HealthService {
+	s := &HealthService{timeout: config.Health.CheckTimeout, started: time.Now()}
+	if s.timeout <= 0 {
+		s.timeout = defaultHealthCheckTimeout
+	}
+	return s
+}
+
+// Register 注册一项就绪检查
+func (s *HealthService) Register(name string, fn HealthCheckFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks = append(s.checks, namedCheck{name: name, fn: fn})
+}
+
+// Uptime 返回服务已运行的时间
+func (s *HealthService) Uptime() time.Duration {
+	return time.Since(s.started)
+}
+
+// Check 并发执行全部检查；任一检查失败或超时时整体为fail，只有警告时为warn
+func (s *HealthService) Check(ctx context.Context) HealthReport {
+	s.mu.RLock()
+	checks := append([]namedCheck(nil), s.checks...)
+	s.mu.RUnlock()
+
+	report := HealthReport{Status: HealthOK, CheckedAt: time.Now(), Checks: make(map[string]CheckResult, len(checks))}
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c namedCheck) {
+			defer wg.Done()
+			results[i] = s.run(ctx, c.fn)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for i, c := range checks {
+		r := results[i]
+		report.Checks[c.name] = r
+		switch {
+		case r.Status == HealthFail:
+			report.Status = HealthFail
+		case r.Status == HealthWarn && report.Status == HealthOK:
+			report.Status = HealthWarn
+		}
+	}
+	return report
+}
+
+// run 在超时内执行一项检查；检查函数未响应ctx时也按超时返回
+func (s *HealthService) run(ctx context.Context, fn HealthCheckFunc) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	type outcome struct {
+		detail interface{}
+		err    error
+	}
+	start := time.Now()
+	done := make(chan outcome, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- outcome{err: fmt.Errorf("检查异常: %v", p)}
+			}
+		}()
+		detail, err := fn(ctx)
+		done <- outcome{detail, err}
+	}()
+
+	var o outcome
+	select {
+	case o = <-done:
+	case <-ctx.Done():
+		o.err = fmt.Errorf("检查超时(%s)", s.timeout)
+	}
+
+	r := CheckResult{Status: HealthOK, Detail: o.detail, DurationMS: time.Since(start).Milliseconds()}
+	var warning *healthWarning
+	switch {
+	case o.err == nil:
+	case errors.As(o.err, &warning):
+		r.Status, r.Error = HealthWarn, o.err.Error()
+	default:
+		r.Status, r.Error = HealthFail, o.err.Error()
+	}
+	return r
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"container/list"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"meea-icey/models"
+)
+
+// QueryCacheStats 查询缓存命中统计
+type QueryCacheStats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+	Size          int    `json:"size"`
+}
+
+// queryCacheEntry 一个主题的缓存，不同评分方法/排序方式的结果分别保存
+type queryCacheEntry struct {
+	subject  string
+	variants map[string]interface{}
+	storedAt time.Time
+}
+
+// QueryCache 按主题缓存渲染后的查询结果(进程内LRU)
+// 提交/投票/删除以及拉取到的远程变更涉及某主题时，该主题的缓存整体失效
+type QueryCache struct {
+	enabled bool
+	size    int
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	// 失效代数：每次失效时主题记录递增的序号，Purge后所有主题的代数不低于purgedAt。
+	// 查询前读取代数，写入时代数已变化说明查询期间发生了失效，结果可能已过期
+	seq         uint64
+	generations map[string]uint64
+	purgedAt    uint64
+
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	evictions     atomic.Uint64
+	invalidations atomic.Uint64
+}
+
+// NewQueryCache 根据配置创建QueryCache实例
+func NewQueryCache(config *models.Config) *QueryCache {
+	cfg := config.QueryCache
+	c := &QueryCache{
+		enabled:     cfg.Enabled,
+		size:        cfg.Size,
+		ttl:         cfg.TTL,
+		order:       list.New(),
+		entries:     make(map[string]*list.Element),
+		generations: make(map[string]uint64),
+	}
+	if c.size <= 0 {
+		c.size = 1024
+	}
+	if c.ttl <= 0 {
+		c.ttl = 30 * time.Second
+	}
+	return c
+}
+
+// Get 读取缓存，过期的条目视为未命中
+func (c *QueryCache) Get(subject, variant string) (interface{}, bool) {
+	if !c.enabled {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[subject]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	entry := el.Value.(*queryCacheEntry)
+	if time.Since(entry.storedAt) > c.ttl {
+		c.removeElement(el)
+		c.misses.Add(1)
+		return nil, false
+	}
+	value, ok := entry.variants[variant]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	c.hits.Add(1)
+	return value, true
+}
+
+// Generation 返回主题当前的失效代数，需在执行查询之前读取并传给Put
+func (c *QueryCache) Generation(subject string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation(subject)
+}
+
+// Put 写入缓存，超出容量时淘汰最久未使用的主题。
+// gen与当前代数不一致时说明查询期间主题已失效，丢弃该结果
+func (c *QueryCache) Put(subject, variant string, gen uint64, value interface{}) {
+	if !c.enabled {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation(subject) != gen {
+		return
+	}
+
+	if el, ok := c.entries[subject]; ok {
+		entry := el.Value.(*queryCacheEntry)
+		if time.Since(entry.storedAt) > c.ttl {
+			entry.variants = make(map[string]interface{})
+			entry.storedAt = time.Now()
+		}
+		entry.variants[variant] = value
+		c.order.MoveToFront(el)
+		return
+	}
+
+	entry := &queryCacheEntry{
+		subject:  subject,
+		variants: map[string]interface{}{variant: value},
+		storedAt: time.Now(),
+	}
+	c.entries[subject] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// Invalidate 使指定主题的缓存失效
+func (c *QueryCache) Invalidate(subject string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	if len(c.generations) >= c.size*4 {
+		// 代数表过大时整体重置，进行中的查询结果全部丢弃
+		c.generations = make(map[string]uint64)
+		c.purgedAt = c.seq
+	} else {
+		c.generations[subject] = c.seq
+	}
+	if el, ok := c.entries[subject]; ok {
+		c.removeElement(el)
+		c.invalidations.Add(1)
+	}
+}
+
+// Purge 清空全部缓存
+func (c *QueryCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := c.order.Len()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.seq++
+	c.generations = make(map[string]uint64)
+	c.purgedAt = c.seq
+	c.invalidations.Add(uint64(n))
+}
+
+// InvalidatePaths 根据仓库内变更文件的相对路径使对应主题的缓存失效
+func (c *QueryCache) InvalidatePaths(paths []string) {
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		subject := SubjectFromPath(p)
+		if subject == "" || seen[subject] {
+			continue
+		}
+		seen[subject] = true
+		c.Invalidate(subject)
+	}
+}
+
+// Stats 返回缓存统计
+func (c *QueryCache) Stats() QueryCacheStats {
+	c.mu.Lock()
+	size := c.order.Len()
+	c.mu.Unlock()
+	return QueryCacheStats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Evictions:     c.evictions.Load(),
+		Invalidations: c.invalidations.Load(),
+		Size:          size,
+	}
+}
+
+func (c *QueryCache) generation(subject string) uint64 {
+	if gen, ok := c.generations[subject]; ok && gen > c.purgedAt {
+		return gen
+	}
+	return c.purgedAt
+}
+
+func (c *QueryCache) removeElement(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*queryCacheEntry).subject)
+}
+
+// SubjectFromPath 从仓库内相对路径 aa/bb/cc/<subject>/... 中提取subject
+func SubjectFromPath(p string) string {
+	parts := strings.Split(filepath.ToSlash(p), "/")
+	if len(parts) < 4 {
+		return ""
+	}
+	subject := parts[3]
+	if len(subject) < 6 || !strings.HasPrefix(subject, parts[0]+parts[1]+parts[2]) {
+		return ""
+	}
+	return subject
+}
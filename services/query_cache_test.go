@@ -0,0 +1,75 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"meea-icey/models"
+)
+
+func newTestCache(size int) *QueryCache {
+	config := &models.Config{}
+	config.QueryCache.Enabled = true
+	config.QueryCache.Size = size
+	config.QueryCache.TTL = time.Minute
+	return NewQueryCache(config)
+}
+
+func TestQueryCacheInvalidate(t *testing.T) {
+	c := newTestCache(2)
+	c.Put("subjectA", "wilson|", c.Generation("subjectA"), 1)
+	c.Put("subjectB", "wilson|", c.Generation("subjectB"), 2)
+
+	if v, ok := c.Get("subjectA", "wilson|"); !ok || v != 1 {
+		t.Fatalf("应命中缓存: %v, %v", v, ok)
+	}
+	if _, ok := c.Get("subjectA", "raw|"); ok {
+		t.Error("不同评分方法应分别缓存")
+	}
+
+	c.InvalidatePaths([]string{"su/bj/ec/subjectA/manifest.idx", "README.md"})
+	if _, ok := c.Get("subjectA", "wilson|"); ok {
+		t.Error("变更后主题缓存应失效")
+	}
+	if _, ok := c.Get("subjectB", "wilson|"); !ok {
+		t.Error("其他主题的缓存不应受影响")
+	}
+
+	// 超出容量时淘汰最久未使用的主题
+	c.Put("subjectC", "wilson|", c.Generation("subjectC"), 3)
+	c.Put("subjectD", "wilson|", c.Generation("subjectD"), 4)
+	if _, ok := c.Get("subjectB", "wilson|"); ok {
+		t.Error("最久未使用的主题应被淘汰")
+	}
+	if st := c.Stats(); st.Size != 2 || st.Evictions != 1 || st.Invalidations != 1 {
+		t.Errorf("缓存统计不符: %+v", st)
+	}
+}
+
+func TestQueryCacheDropsStalePut(t *testing.T) {
+	c := newTestCache(16)
+
+	// 查询期间主题被失效，查询结果不应写入缓存
+	gen := c.Generation("subjectA")
+	c.Invalidate("subjectA")
+	c.Put("subjectA", "wilson|", gen, "stale")
+	if _, ok := c.Get("subjectA", "wilson|"); ok {
+		t.Fatal("失效前开始的查询结果不应被缓存")
+	}
+
+	// 其他主题的失效不影响
+	gen = c.Generation("subjectA")
+	c.Invalidate("subjectB")
+	c.Put("subjectA", "wilson|", gen, "fresh")
+	if v, ok := c.Get("subjectA", "wilson|"); !ok || v != "fresh" {
+		t.Fatalf("应缓存最新结果: %v, %v", v, ok)
+	}
+
+	// 清空全部缓存同样使进行中的查询失效
+	gen = c.Generation("subjectC")
+	c.Purge()
+	c.Put("subjectC", "wilson|", gen, "stale")
+	if _, ok := c.Get("subjectC", "wilson|"); ok {
+		t.Error("清空前开始的查询结果不应被缓存")
+	}
+}
@@ -0,0 +1,474 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"meea-icey/internal/logging"
+	"meea-icey/internal/metrics"
+	"meea-icey/models"
+)
+
+var checkerLog = logging.Component("repo_checker")
+
+// 仓库修复动作，通过 repository.repair_actions 配置启用
+const (
+	// RepairPush 推送本地领先的提交
+	RepairPush = "push"
+	// RepairReset 丢弃未提交的修改，切回预期分支并重置到远程(本地与远程分叉时丢弃本地提交)
+	RepairReset = "reset"
+	// RepairReclone 仓库损坏或远程地址不符时，将原目录改名保留后重新克隆
+	RepairReclone = "reclone"
+)
+
+// 计算领先/落后提交数时最多遍历的提交数
+const maxAheadBehindWalk = 10000
+
+// RepoHealth 本地仓库的检查结果，通过未鉴权的/health返回：
+// 远程地址去掉用户信息，错误原文只记录日志，不序列化
+type RepoHealth struct {
+	CheckedAt      time.Time `json:"checked_at"`
+	Healthy        bool      `json:"healthy"`
+	Exists         bool      `json:"exists"`
+	IsRepo         bool      `json:"is_repo"`
+	RemoteURL      string    `json:"remote_url,omitempty"`
+	RemoteMatches  bool      `json:"remote_matches"`
+	Branch         string    `json:"branch,omitempty"`
+	ExpectedBranch string    `json:"expected_branch,omitempty"`
+	Head           string    `json:"head,omitempty"`
+	Clean          bool      `json:"clean"`
+	Dirty          int       `json:"dirty"`
+	Ahead          int       `json:"ahead"`
+	Behind         int       `json:"behind"`
+	FetchFailed    bool      `json:"fetch_failed,omitempty"`
+	FetchError     string    `json:"-"`
+	Problems       []string  `json:"problems,omitempty"`
+	Repaired       []string  `json:"repaired,omitempty"`
+	RepairFailed   []string  `json:"repair_failed,omitempty"`
+	RepairErrors   []string  `json:"-"`
+}
+
+// RepoChecker 启动时和定期检查本地仓库状态，并按配置执行修复；
+// 检查和修复都持有RepoSyncer的仓库锁，不会看到写请求尚未提交的文件
+type RepoChecker struct {
+	gitService     *GitService
+	syncer         *RepoSyncer
+	expectedURL    string
+	expectedBranch string
+	interval       time.Duration
+	repairs        map[string]bool
+
+	mu   sync.RWMutex
+	last RepoHealth
+}
+
+// NewRepoChecker 根据配置创建RepoChecker实例
+func NewRepoChecker(config *models.Config, gitService *GitService, syncer *RepoSyncer) *RepoChecker {
+	c := &RepoChecker{
+		gitService:     gitService,
+		syncer:         syncer,
+		expectedURL:    config.Repository.URL,
+		expectedBranch: config.Repository.Branch,
+		interval:       config.Repository.HealthCheckInterval,
+		repairs:        make(map[string]bool),
+	}
+	for _, action := range config.Repository.RepairActions {
+		switch action {
+		case RepairPush, RepairReset, RepairReclone:
+			c.repairs[action] = true
+		default:
+			checkerLog.Warn("忽略未知的修复动作", "action", action)
+		}
+	}
+	if c.interval <= 0 {
+		c.interval = 10 * time.Minute
+	}
+	return c
+}
+
+// Status 返回最近一次检查结果
+func (c *RepoChecker) Status() RepoHealth {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.last
+}
+
+// Run 定期检查仓库，ctx取消时退出
+// 运行期间不执行reset修复：处理中的请求会在提交前写入文件，重置工作区会破坏这些请求
+func (c *RepoChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		c.check(false)
+	}
+}
+
+// Check 检查仓库并执行全部已启用的修复，用于启动时
+func (c *RepoChecker) Check() RepoHealth {
+	return c.check(true)
+}
+
+func (c *RepoChecker) check(startup bool) RepoHealth {
+	unlock := c.syncer.Hold()
+	defer unlock()
+
+	h := c.inspect()
+	if len(h.Problems) > 0 {
+		c.repair(&h, startup)
+		if len(h.Repaired) > 0 {
+			// 修复后重新检查，保留本次的修复记录
+			after := c.inspect()
+			after.Repaired, after.RepairFailed, after.RepairErrors = h.Repaired, h.RepairFailed, h.RepairErrors
+			h = after
+		}
+	}
+	h.Healthy = len(h.Problems) == 0
+
+	for _, p := range h.Problems {
+		checkerLog.Warn("仓库异常", "problem", p)
+	}
+	c.mu.Lock()
+	c.last = h
+	c.mu.Unlock()
+	return h
+}
+
+// inspect 只读检查，不修改仓库(会fetch远程分支以计算落后数)
+func (c *RepoChecker) inspect() RepoHealth {
+	h := RepoHealth{CheckedAt: time.Now(), ExpectedBranch: c.expectedBranch}
+	repoRoot := c.gitService.getRepoRoot()
+
+	if _, err := os.Stat(repoRoot); os.IsNotExist(err) {
+		h.Problems = append(h.Problems, "本地仓库不存在")
+		return h
+	}
+	h.Exists = true
+
+	r, err := git.PlainOpen(repoRoot)
+	if err != nil {
+		h.Problems = append(h.Problems, fmt.Sprintf("不是有效的Git仓库: %v", err))
+		return h
+	}
+	head, err := r.Head()
+	if err != nil {
+		h.Problems = append(h.Problems, fmt.Sprintf("读取HEAD失败: %v", err))
+		return h
+	}
+	if _, err := r.CommitObject(head.Hash()); err != nil {
+		h.Problems = append(h.Problems, fmt.Sprintf("HEAD提交损坏: %v", err))
+		return h
+	}
+	h.IsRepo = true
+	h.Head = head.Hash().String()
+
+	var remoteURL string
+	if remote, err := r.Remote("origin"); err == nil && len(remote.Config().URLs) > 0 {
+		remoteURL = remote.Config().URLs[0]
+	}
+	h.RemoteURL = redactURL(remoteURL)
+	h.RemoteMatches = sameRepositoryURL(remoteURL, c.expectedURL)
+	if !h.RemoteMatches {
+		h.Problems = append(h.Problems, fmt.Sprintf("远程地址不符: %s", h.RemoteURL))
+		return h
+	}
+
+	if head.Name().IsBranch() {
+		h.Branch = head.Name().Short()
+	}
+	if c.expectedBranch != "" && h.Branch != c.expectedBranch {
+		h.Problems = append(h.Problems, fmt.Sprintf("当前分支为%q，预期%q", h.Branch, c.expectedBranch))
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		h.Problems = append(h.Problems, fmt.Sprintf("获取工作区失败: %v", err))
+		return h
+	}
+	status, err := w.Status()
+	if err != nil {
+		h.Problems = append(h.Problems, fmt.Sprintf("读取工作区状态失败: %v", err))
+		return h
+	}
+	h.Clean = status.IsClean()
+	h.Dirty = len(status)
+	if !h.Clean {
+		h.Problems = append(h.Problems, fmt.Sprintf("工作区有%d个未提交的文件", h.Dirty))
+	}
+
+	if err := c.fetch(r); err != nil {
+		h.FetchFailed = true
+		h.FetchError = err.Error()
+		checkerLog.Warn("检查时获取远程更新失败", "error", err)
+	}
+	branch := h.Branch
+	if c.expectedBranch != "" {
+		branch = c.expectedBranch
+	}
+	if branch == "" {
+		return h
+	}
+	remoteRef, err := r.Reference(plumbing.NewRemoteReferenceName("origin", branch), true)
+	if err != nil {
+		h.Problems = append(h.Problems, fmt.Sprintf("远程分支origin/%s不存在", branch))
+		return h
+	}
+	h.Ahead, h.Behind, err = aheadBehind(r, head.Hash(), remoteRef.Hash())
+	if err != nil {
+		h.Problems = append(h.Problems, fmt.Sprintf("计算领先/落后提交失败: %v", err))
+		return h
+	}
+	switch {
+	case h.Ahead > 0 && h.Behind > 0:
+		h.Problems = append(h.Problems, fmt.Sprintf("本地与远程分叉: 领先%d，落后%d", h.Ahead, h.Behind))
+	case h.Ahead > 0:
+		h.Problems = append(h.Problems, fmt.Sprintf("有%d个未推送的提交", h.Ahead))
+	}
+	// 仅落后于远程由RepoSyncer正常拉取，不视为异常
+	return h
+}
+
+// repair 按检查结果执行已启用的修复动作
+func (c *RepoChecker) repair(h *RepoHealth, startup bool) {
+	record := func(action string, err error) {
+		if err != nil {
+			h.RepairFailed = append(h.RepairFailed, action)
+			h.RepairErrors = append(h.RepairErrors, fmt.Sprintf("%s: %v", action, err))
+			checkerLog.Error("修复失败", "action", action, "error", err)
+			return
+		}
+		h.Repaired = append(h.Repaired, action)
+		checkerLog.Info("已修复", "action", action)
+	}
+
+	if !h.Exists {
+		// 首次启动，正常克隆
+		record("clone", c.gitService.CloneRepository())
+		return
+	}
+	if !h.IsRepo || !h.RemoteMatches {
+		if c.repairs[RepairReclone] && startup {
+			record(RepairReclone, c.reclone())
+		}
+		return
+	}
+
+	diverged := h.Ahead > 0 && h.Behind > 0
+	wrongBranch := c.expectedBranch != "" && h.Branch != c.expectedBranch
+	if (!h.Clean || diverged || wrongBranch) && c.repairs[RepairReset] && startup {
+		record(RepairReset, c.resetToRemote(h.Branch))
+		return
+	}
+	if h.Ahead > 0 && !diverged && c.repairs[RepairPush] {
+		record(RepairPush, c.push())
+	}
+}
+
+func (c *RepoChecker) fetch(r *git.Repository) error {
+	auth, err := c.gitService.transportAuth()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = r.Fetch(&git.FetchOptions{RemoteName: "origin", Auth: auth})
+	if err == git.NoErrAlreadyUpToDate {
+		err = nil
+	}
+	metrics.GitOperation("fetch", start, err)
+	if err != nil {
+		return fmt.Errorf("获取远程更新失败: %v", err)
+	}
+	return nil
+}
+
+func (c *RepoChecker) push() error {
+	if err := c.gitService.beginOp(); err != nil {
+		return err
+	}
+	defer c.gitService.ops.Done()
+
+	r, err := git.PlainOpen(c.gitService.getRepoRoot())
+	if err != nil {
+		return fmt.Errorf("打开仓库失败: %v", err)
+	}
+	auth, err := c.gitService.transportAuth()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = r.Push(&git.PushOptions{Auth: auth})
+	if err == git.NoErrAlreadyUpToDate {
+		err = nil
+	}
+	metrics.GitOperation("push", start, err)
+	if err != nil {
+		return fmt.Errorf("推送失败: %v", err)
+	}
+	c.gitService.markPushed()
+	return nil
+}
+
+// resetToRemote 切换到预期分支并硬重置到远程分支，清理未跟踪文件
+func (c *RepoChecker) resetToRemote(current string) error {
+	if err := c.gitService.beginOp(); err != nil {
+		return err
+	}
+	defer c.gitService.ops.Done()
+
+	branch := c.expectedBranch
+	if branch == "" {
+		branch = current
+	}
+	if branch == "" {
+		return fmt.Errorf("无法确定要重置的分支")
+	}
+	r, err := git.PlainOpen(c.gitService.getRepoRoot())
+	if err != nil {
+		return fmt.Errorf("打开仓库失败: %v", err)
+	}
+	remoteRef, err := r.Reference(plumbing.NewRemoteReferenceName("origin", branch), true)
+	if err != nil {
+		return fmt.Errorf("远程分支origin/%s不存在: %v", branch, err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return fmt.Errorf("获取工作区失败: %v", err)
+	}
+
+	localName := plumbing.NewBranchReferenceName(branch)
+	if err := r.Storer.SetReference(plumbing.NewHashReference(localName, remoteRef.Hash())); err != nil {
+		return fmt.Errorf("更新本地分支失败: %v", err)
+	}
+	if err := w.Checkout(&git.CheckoutOptions{Branch: localName, Force: true}); err != nil {
+		return fmt.Errorf("切换分支失败: %v", err)
+	}
+	if err := w.Reset(&git.ResetOptions{Mode: git.HardReset, Commit: remoteRef.Hash()}); err != nil {
+		return fmt.Errorf("重置工作区失败: %v", err)
+	}
+	if err := w.Clean(&git.CleanOptions{Dir: true}); err != nil {
+		return fmt.Errorf("清理未跟踪文件失败: %v", err)
+	}
+	// 保证之后的pull/push使用该分支的上游
+	if err := r.CreateBranch(&gitconfig.Branch{Name: branch, Remote: "origin", Merge: localName}); err != nil && err != git.ErrBranchExists {
+		return fmt.Errorf("设置上游分支失败: %v", err)
+	}
+	return nil
+}
+
+// reclone 将现有目录改名保留，然后重新克隆
+func (c *RepoChecker) reclone() error {
+	if err := c.gitService.beginOp(); err != nil {
+		return err
+	}
+	defer c.gitService.ops.Done()
+
+	repoRoot := c.gitService.getRepoRoot()
+	backup := fmt.Sprintf("%s.broken-%s", repoRoot, time.Now().Format("20060102150405"))
+	if err := os.Rename(repoRoot, backup); err != nil {
+		return fmt.Errorf("备份原仓库目录失败: %v", err)
+	}
+	checkerLog.Warn("原仓库目录已移走", "backup", backup)
+	if err := c.gitService.CloneRepository(); err != nil {
+		return err
+	}
+	if c.expectedBranch == "" {
+		return nil
+	}
+	// 克隆得到的是远程默认分支，切换到预期分支
+	return c.resetToRemote("")
+}
+
+// aheadBehind 计算local相对remote领先和落后的提交数
+func aheadBehind(r *git.Repository, local, remote plumbing.Hash) (int, int, error) {
+	if local == remote {
+		return 0, 0, nil
+	}
+	localCommit, err := r.CommitObject(local)
+	if err != nil {
+		return 0, 0, err
+	}
+	remoteCommit, err := r.CommitObject(remote)
+	if err != nil {
+		return 0, 0, err
+	}
+	bases, err := localCommit.MergeBase(remoteCommit)
+	if err != nil {
+		return 0, 0, err
+	}
+	stop := make(map[plumbing.Hash]bool, len(bases))
+	for _, b := range bases {
+		stop[b.Hash] = true
+	}
+	ahead, err := countCommits(localCommit, stop)
+	if err != nil {
+		return 0, 0, err
+	}
+	behind, err := countCommits(remoteCommit, stop)
+	return ahead, behind, err
+}
+
+// countCommits 统计从from出发、不经过stop中提交及其祖先的提交数
+func countCommits(from *object.Commit, stop map[plumbing.Hash]bool) (int, error) {
+	// 合并基础的祖先都不计入
+	excluded := make(map[plumbing.Hash]bool)
+	for h := range stop {
+		excluded[h] = true
+	}
+	n := 0
+	seen := map[plumbing.Hash]bool{}
+	queue := []*object.Commit{from}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		if seen[c.Hash] || excluded[c.Hash] {
+			continue
+		}
+		seen[c.Hash] = true
+		n++
+		if n > maxAheadBehindWalk {
+			return n, nil
+		}
+		err := c.Parents().ForEach(func(p *object.Commit) error {
+			queue = append(queue, p)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// redactURL 去掉地址中的用户信息，https地址的用户信息中可能包含访问令牌
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	u.User = nil
+	return u.String()
+}
+
+// sameRepositoryURL 比较远程地址，忽略末尾的/和.git
+func sameRepositoryURL(a, b string) bool {
+	norm := func(s string) string {
+		s = strings.TrimSuffix(strings.TrimRight(s, "/"), ".git")
+		s = strings.TrimPrefix(s, "file://")
+		return filepath.ToSlash(s)
+	}
+	return a != "" && norm(a) == norm(b)
+}
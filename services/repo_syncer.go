@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"meea-icey/internal/logging"
+	"meea-icey/models"
+)
+
+var syncLog = logging.Component("repo_syncer")
+
+// SyncStatus 仓库同步状态，通过未鉴权的/health返回，错误原文只记录日志
+type SyncStatus struct {
+	LastCommit   string    `json:"last_commit"`
+	LastSyncAt   time.Time `json:"last_sync_at"`
+	Staleness    string    `json:"staleness"`
+	LastError    string    `json:"-"`
+	LastFailedAt time.Time `json:"last_failed_at,omitempty"`
+}
+
+// RepoSyncer 在后台定期拉取远程仓库，读请求直接使用本地仓库，
+// 只有本地仓库陈旧超过maxStaleness时才同步拉取；写请求通过Lock在写入前同步拉取，
+// 并持有仓库锁直到提交推送完成
+type RepoSyncer struct {
+	gitService   *GitService
+	interval     time.Duration
+	maxStaleness time.Duration
+	trigger      chan struct{}
+
+	// repoMu 仓库锁：拉取以及写请求从拉取、写文件到提交推送的整个过程都需持有，
+	// 避免后台拉取在写请求写入文件后、提交前改动工作区
+	repoMu sync.Mutex
+
+	mu           sync.RWMutex
+	lastCommit   string
+	lastSyncAt   time.Time
+	lastError    string
+	lastFailedAt time.Time
+}
+
+// NewRepoSyncer 根据配置创建RepoSyncer实例
+func NewRepoSyncer(config *models.Config, gitService *GitService) *RepoSyncer {
+	s := &RepoSyncer{
+		gitService:   gitService,
+		interval:     config.Repository.SyncInterval,
+		maxStaleness: config.Repository.MaxStaleness,
+		trigger:      make(chan struct{}, 1),
+	}
+	if s.interval <= 0 {
+		s.interval = time.Minute
+	}
+	if s.maxStaleness <= 0 {
+		s.maxStaleness = 5 * time.Minute
+	}
+	return s
+}
+
+// Sync 立即拉取远程仓库并记录同步状态
+func (s *RepoSyncer) Sync() error {
+	s.repoMu.Lock()
+	defer s.repoMu.Unlock()
+	return s.pull()
+}
+
+// Lock 获取仓库锁并拉取最新代码，返回释放锁的函数。
+// 写请求需在写入文件之前调用，提交推送完成后释放；拉取失败时不持有锁
+func (s *RepoSyncer) Lock() (func(), error) {
+	s.repoMu.Lock()
+	if err := s.pull(); err != nil {
+		s.repoMu.Unlock()
+		return nil, err
+	}
+	return s.repoMu.Unlock, nil
+}
+
+// Hold 获取仓库锁但不拉取，返回释放锁的函数；用于检查和修复本地仓库，
+// 避免与拉取和处理中的写请求同时操作工作区
+func (s *RepoSyncer) Hold() func() {
+	s.repoMu.Lock()
+	return s.repoMu.Unlock
+}
+
+// EnsureFresh 本地仓库在允许的陈旧时间内时直接返回，否则同步拉取
+func (s *RepoSyncer) EnsureFresh() error {
+	if s.fresh() {
+		return nil
+	}
+	s.repoMu.Lock()
+	defer s.repoMu.Unlock()
+	// 等待锁期间可能已有其他请求完成拉取
+	if s.fresh() {
+		return nil
+	}
+	return s.pull()
+}
+
+// Trigger 通知后台尽快同步，已有待处理的通知时直接忽略
+func (s *RepoSyncer) Trigger() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Status 返回当前同步状态
+func (s *RepoSyncer) Status() SyncStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status := SyncStatus{
+		LastCommit:   s.lastCommit,
+		LastSyncAt:   s.lastSyncAt,
+		LastError:    s.lastError,
+		LastFailedAt: s.lastFailedAt,
+	}
+	if !s.lastSyncAt.IsZero() {
+		status.Staleness = time.Since(s.lastSyncAt).Round(time.Second).String()
+	}
+	return status
+}
+
+// Run 启动时同步一次，之后按间隔或收到通知时同步，ctx取消时退出
+func (s *RepoSyncer) Run(ctx context.Context) {
+	if err := s.Sync(); err != nil {
+		syncLog.Warn("初始同步失败", "error", err)
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.trigger:
+		}
+		if err := s.Sync(); err != nil {
+			syncLog.Warn("同步失败", "error", err)
+		}
+	}
+}
+
+func (s *RepoSyncer) fresh() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.lastSyncAt.IsZero() && time.Since(s.lastSyncAt) <= s.maxStaleness
+}
+
+// pull 调用方需持有repoMu
+func (s *RepoSyncer) pull() error {
+	err := s.gitService.PullRepository()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.lastError = err.Error()
+		s.lastFailedAt = time.Now()
+		return err
+	}
+	s.lastSyncAt = time.Now()
+	s.lastError = ""
+	if head, err := s.gitService.HeadCommit(); err == nil {
+		s.lastCommit = head
+	}
+	return nil
+}
@@ -0,0 +1,435 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"meea-icey/internal/logging"
+	"meea-icey/models"
+)
+
+var reputationLog = logging.Component("reputation")
+
+// 信誉相关的Redis Key
+const (
+	reputationKey        = "icey:reputation"
+	reputationPendingKey = "icey:reputation:pending"
+	reputationHiddenKey  = "icey:reputation:hidden:%s:%s"
+	recordOwnerKey       = "icey:record-owner:%s:%s"
+	recordVotersKey      = "icey:record-voters:%s:%s"
+	ownerRecordsKey      = "icey:owner-records:%s"
+)
+
+// 每个用户保留的最近提交记录数
+const ownerRecordsLimit = 50
+
+// 共识判定阈值：加权可信率不低于consensusHigh视为可信，不高于consensusLow视为不可信(记录被隐藏)
+const (
+	consensusHigh = 0.7
+	consensusLow  = 0.3
+)
+
+// SubjectHash 计算主题原文的SHA256，与验证码和存储路径使用的哈希一致
+func SubjectHash(subject string) string {
+	hash := sha256.Sum256([]byte(subject))
+	return hex.EncodeToString(hash[:])
+}
+
+// HashOpenID 计算openid的哈希，信誉和投票记录中只保存哈希
+func HashOpenID(openid string) string {
+	hash := sha256.Sum256([]byte(openid))
+	return hex.EncodeToString(hash[:])
+}
+
+// pendingVote 等待共识结算的投票
+type pendingVote struct {
+	Voter    string `json:"voter"`
+	Subject  string `json:"subject"`
+	RecordID string `json:"record_id"`
+	Value    uint8  `json:"value"`
+	At       int64  `json:"at"`
+}
+
+// reputationSnapshot 信誉快照文件格式
+type reputationSnapshot struct {
+	TakenAt int64              `json:"taken_at"`
+	Scores  map[string]float64 `json:"scores"`
+}
+
+// ReputationService 维护投票人信誉，信誉值直接作为投票权重
+type ReputationService struct {
+	config      *models.Config
+	redisClient *redis.Client
+	voteStore   *VoteStore
+
+	minWeight        float64
+	maxWeight        float64
+	agreeReward      float64
+	disagreePenalty  float64
+	upvoteReward     float64
+	hiddenPenalty    float64
+	minVotes         int
+	settleAfter      time.Duration
+	settleInterval   time.Duration
+	pendingExpiry    time.Duration
+	snapshotInterval time.Duration
+}
+
+// NewReputationService 创建ReputationService实例，未配置的参数使用默认值
+func NewReputationService(config *models.Config, redisClient *redis.Client, voteStore *VoteStore) *ReputationService {
+	cfg := config.Reputation
+	s := &ReputationService{
+		config:           config,
+		redisClient:      redisClient,
+		voteStore:        voteStore,
+		minWeight:        orDefault(cfg.MinWeight, 0.2),
+		maxWeight:        orDefault(cfg.MaxWeight, 5),
+		agreeReward:      orDefault(cfg.AgreeReward, 0.05),
+		disagreePenalty:  orDefault(cfg.DisagreePenalty, 0.1),
+		upvoteReward:     orDefault(cfg.UpvoteReward, 0.02),
+		hiddenPenalty:    orDefault(cfg.HiddenPenalty, 0.5),
+		minVotes:         cfg.MinVotes,
+		settleAfter:      cfg.SettleAfter,
+		settleInterval:   cfg.SettleInterval,
+		pendingExpiry:    cfg.PendingExpiry,
+		snapshotInterval: cfg.SnapshotInterval,
+	}
+	if s.minVotes <= 0 {
+		s.minVotes = 10
+	}
+	if s.settleAfter <= 0 {
+		s.settleAfter = 24 * time.Hour
+	}
+	if s.settleInterval <= 0 {
+		s.settleInterval = 10 * time.Minute
+	}
+	if s.pendingExpiry <= 0 {
+		s.pendingExpiry = 30 * 24 * time.Hour
+	}
+	if s.snapshotInterval <= 0 {
+		s.snapshotInterval = time.Hour
+	}
+	return s
+}
+
+// Weight 返回投票人的当前权重，未知投票人权重为1
+func (s *ReputationService) Weight(voter string) float64 {
+	if voter == "" {
+		return 1
+	}
+	score, err := s.redisClient.HGet(context.Background(), reputationKey, voter).Float64()
+	if err == redis.Nil {
+		return 1
+	}
+	if err != nil {
+		reputationLog.Warn("读取信誉失败", "error", err)
+		return 1
+	}
+	return score
+}
+
+// RecordSubmission 记录提交人，供后续按点赞和隐藏调整信誉
+func (s *ReputationService) RecordSubmission(subject, recordID, owner string) {
+	if owner == "" {
+		return
+	}
+	ctx := context.Background()
+	key := fmt.Sprintf(recordOwnerKey, subject, recordID)
+	if err := s.redisClient.Set(ctx, key, owner, 0).Err(); err != nil {
+		reputationLog.Warn("记录提交人失败", "error", err)
+	}
+	listKey := fmt.Sprintf(ownerRecordsKey, owner)
+	pipe := s.redisClient.TxPipeline()
+	pipe.LPush(ctx, listKey, subject+"/"+recordID)
+	pipe.LTrim(ctx, listKey, 0, ownerRecordsLimit-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		reputationLog.Warn("记录用户提交列表失败", "error", err)
+	}
+}
+
+// Submissions 返回用户最近提交的记录，元素格式为"subject哈希/记录前缀"
+func (s *ReputationService) Submissions(ctx context.Context, owner string, limit int) ([]string, error) {
+	items, err := s.redisClient.LRange(ctx, fmt.Sprintf(ownerRecordsKey, owner), 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("读取提交记录失败: %v", err)
+	}
+	return items, nil
+}
+
+// RecordVote 记录一次投票：可信票立即奖励提交人，投票本身等待共识形成后结算。
+// 投票人与投票值保存在Redis中；同一投票人对同一记录只计一次，重复投票只更新投票值
+func (s *ReputationService) RecordVote(subject, recordID, voter string, value uint8) {
+	if voter == "" {
+		// 无法识别投票人时无法去重，不参与信誉调整
+		return
+	}
+	ctx := context.Background()
+	votersKey := fmt.Sprintf(recordVotersKey, subject, recordID)
+	first, err := s.redisClient.HSetNX(ctx, votersKey, voter, value).Result()
+	if err != nil {
+		reputationLog.Warn("记录投票人失败", "error", err)
+		return
+	}
+	if !first {
+		if err := s.redisClient.HSet(ctx, votersKey, voter, value).Err(); err != nil {
+			reputationLog.Warn("更新投票值失败", "error", err)
+		}
+		return
+	}
+
+	if value == 1 {
+		owner, err := s.redisClient.Get(ctx, fmt.Sprintf(recordOwnerKey, subject, recordID)).Result()
+		if err == nil && owner != "" && owner != voter {
+			s.adjust(ctx, owner, s.upvoteReward)
+		}
+	}
+
+	data, _ := json.Marshal(pendingVote{
+		Voter:    voter,
+		Subject:  subject,
+		RecordID: recordID,
+		Value:    value,
+		At:       time.Now().Unix(),
+	})
+	if err := s.redisClient.RPush(ctx, reputationPendingKey, data).Err(); err != nil {
+		reputationLog.Warn("记录待结算投票失败", "error", err)
+	}
+}
+
+// Settle 结算超过settleAfter的投票：与共识一致加分，相反扣分；
+// 共识为不可信的记录视为被隐藏，提交人扣分(每条记录只扣一次)。
+// 尚未形成共识的投票放回队列等待下次结算，超过pendingExpiry仍无共识时丢弃。
+// 每次最多处理调用时队列中已有的投票，放回的投票不会在本次重复处理。
+func (s *ReputationService) Settle(ctx context.Context) (int, error) {
+	n, err := s.redisClient.LLen(ctx, reputationPendingKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("读取待结算投票失败: %v", err)
+	}
+	settled, expired := 0, 0
+	now := time.Now()
+	cutoff := now.Add(-s.settleAfter).Unix()
+	expireBefore := now.Add(-s.pendingExpiry).Unix()
+	requeue := func(data []byte) error {
+		if err := s.redisClient.RPush(ctx, reputationPendingKey, data).Err(); err != nil {
+			return fmt.Errorf("放回待结算投票失败: %v", err)
+		}
+		return nil
+	}
+
+	for i := int64(0); i < n; i++ {
+		data, err := s.redisClient.LPop(ctx, reputationPendingKey).Bytes()
+		if err == redis.Nil {
+			break
+		}
+		if err != nil {
+			return settled, fmt.Errorf("读取待结算投票失败: %v", err)
+		}
+
+		var pv pendingVote
+		if err := json.Unmarshal(data, &pv); err != nil {
+			reputationLog.Warn("丢弃无法解析的待结算投票", "error", err)
+			continue
+		}
+		if pv.At > cutoff {
+			if err := requeue(data); err != nil {
+				return settled, err
+			}
+			continue
+		}
+
+		consensus, ok := s.consensus(pv.Subject, pv.RecordID)
+		if !ok {
+			if pv.At <= expireBefore {
+				expired++
+				continue
+			}
+			if err := requeue(data); err != nil {
+				return settled, err
+			}
+			continue
+		}
+		// 以投票人最后一次的投票值结算
+		value := pv.Value
+		if v, err := s.redisClient.HGet(ctx, fmt.Sprintf(recordVotersKey, pv.Subject, pv.RecordID), pv.Voter).Int(); err == nil {
+			value = uint8(v)
+		}
+		if (consensus == 1) == (value == 1) {
+			s.adjust(ctx, pv.Voter, s.agreeReward)
+		} else {
+			s.adjust(ctx, pv.Voter, -s.disagreePenalty)
+		}
+		if consensus == 0 {
+			s.penalizeHidden(ctx, pv.Subject, pv.RecordID)
+		}
+		settled++
+	}
+	if expired > 0 {
+		reputationLog.Info("丢弃长期未形成共识的投票", "count", expired)
+	}
+	return settled, nil
+}
+
+// consensus 返回记录的共识结果(1可信/0不可信)，票数不足或尚无明确共识时ok为false
+func (s *ReputationService) consensus(subject, recordID string) (uint8, bool) {
+	dirPath, _ := BuildSubjectPath(s.config.Repository.ClonePath, subject)
+	if dirPath == "" {
+		return 0, false
+	}
+	vtFile := filepath.Join(dirPath, recordID+VoteFileExt)
+	if !FileExists(vtFile) {
+		return 0, false
+	}
+	stats, err := s.voteStore.GetStats(vtFile)
+	if err != nil || stats.Total < s.minVotes || stats.WeightedTotal <= 0 {
+		return 0, false
+	}
+
+	ratio := stats.WeightedTrue / stats.WeightedTotal
+	switch {
+	case ratio >= consensusHigh:
+		return 1, true
+	case ratio <= consensusLow:
+		return 0, true
+	}
+	return 0, false
+}
+
+// penalizeHidden 记录被隐藏时扣除提交人信誉
+func (s *ReputationService) penalizeHidden(ctx context.Context, subject, recordID string) {
+	owner, err := s.redisClient.Get(ctx, fmt.Sprintf(recordOwnerKey, subject, recordID)).Result()
+	if err != nil || owner == "" {
+		return
+	}
+	first, err := s.redisClient.SetNX(ctx, fmt.Sprintf(reputationHiddenKey, subject, recordID), 1, 0).Result()
+	if err != nil || !first {
+		return
+	}
+	s.adjust(ctx, owner, -s.hiddenPenalty)
+}
+
+// adjust 调整信誉并限制在[minWeight, maxWeight]范围内
+func (s *ReputationService) adjust(ctx context.Context, voter string, delta float64) {
+	if _, err := s.redisClient.HSetNX(ctx, reputationKey, voter, 1).Result(); err != nil {
+		reputationLog.Warn("初始化信誉失败", "error", err)
+		return
+	}
+	score, err := s.redisClient.HIncrByFloat(ctx, reputationKey, voter, delta).Result()
+	if err != nil {
+		reputationLog.Warn("调整信誉失败", "error", err)
+		return
+	}
+	if score < s.minWeight || score > s.maxWeight {
+		clamped := s.minWeight
+		if score > s.maxWeight {
+			clamped = s.maxWeight
+		}
+		if err := s.redisClient.HSet(ctx, reputationKey, voter, clamped).Err(); err != nil {
+			reputationLog.Warn("修正信誉失败", "error", err)
+		}
+	}
+}
+
+// Snapshot 将信誉数据写入快照文件
+func (s *ReputationService) Snapshot(ctx context.Context) error {
+	path := s.config.Reputation.SnapshotPath
+	if path == "" {
+		return nil
+	}
+	all, err := s.redisClient.HGetAll(ctx, reputationKey).Result()
+	if err != nil {
+		return fmt.Errorf("读取信誉数据失败: %v", err)
+	}
+
+	snap := reputationSnapshot{TakenAt: time.Now().Unix(), Scores: make(map[string]float64, len(all))}
+	for voter, v := range all {
+		score, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			continue
+		}
+		snap.Scores[voter] = score
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("序列化信誉快照失败: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("创建快照目录失败: %v", err)
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// Restore Redis中没有信誉数据时从快照文件恢复
+func (s *ReputationService) Restore(ctx context.Context) error {
+	path := s.config.Reputation.SnapshotPath
+	if path == "" || !FileExists(path) {
+		return nil
+	}
+	n, err := s.redisClient.HLen(ctx, reputationKey).Result()
+	if err != nil {
+		return fmt.Errorf("检查信誉数据失败: %v", err)
+	}
+	if n > 0 {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取信誉快照失败: %v", err)
+	}
+	var snap reputationSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("解析信誉快照失败: %v", err)
+	}
+	if len(snap.Scores) == 0 {
+		return nil
+	}
+	values := make(map[string]interface{}, len(snap.Scores))
+	for voter, score := range snap.Scores {
+		values[voter] = score
+	}
+	if err := s.redisClient.HSet(ctx, reputationKey, values).Err(); err != nil {
+		return fmt.Errorf("恢复信誉数据失败: %v", err)
+	}
+	reputationLog.Info("已从快照恢复信誉数据", "count", len(snap.Scores))
+	return nil
+}
+
+// Run 定期结算投票并写入快照，ctx取消时退出
+func (s *ReputationService) Run(ctx context.Context) {
+	settleTicker := time.NewTicker(s.settleInterval)
+	snapshotTicker := time.NewTicker(s.snapshotInterval)
+	defer settleTicker.Stop()
+	defer snapshotTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-settleTicker.C:
+			if n, err := s.Settle(ctx); err != nil {
+				reputationLog.Warn("结算失败", "error", err)
+			} else if n > 0 {
+				reputationLog.Info("已结算投票", "count", n)
+			}
+		case <-snapshotTicker.C:
+			if err := s.Snapshot(ctx); err != nil {
+				reputationLog.Warn("写入快照失败", "error", err)
+			}
+		}
+	}
+}
+
+func orDefault(v, def float64) float64 {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"meea-icey/models"
+)
+
+func newTestReputation(t *testing.T) (*ReputationService, *redis.Client, *models.Config) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	config := &models.Config{}
+	config.Repository.ClonePath = t.TempDir()
+	config.Reputation.MinVotes = 3
+	config.Reputation.SettleAfter = time.Hour
+	config.Reputation.PendingExpiry = 48 * time.Hour
+	return NewReputationService(config, client, NewVoteStore(nil, 0)), client, config
+}
+
+func score(t *testing.T, client *redis.Client, voter string) float64 {
+	t.Helper()
+	v, err := client.HGet(context.Background(), reputationKey, voter).Float64()
+	if err == redis.Nil {
+		return 1
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// pushPending 直接写入指定时间的待结算投票
+func pushPending(t *testing.T, client *redis.Client, pv pendingVote) {
+	t.Helper()
+	data, _ := json.Marshal(pv)
+	if err := client.RPush(context.Background(), reputationPendingKey, data).Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeVotes 为记录写入投票文件
+func writeVotes(t *testing.T, config *models.Config, subject, recordID string, values ...uint8) {
+	t.Helper()
+	dir, _ := BuildSubjectPath(config.Repository.ClonePath, subject)
+	os.MkdirAll(dir, 0755)
+	vf := &VoteFile{Version: voteFileVersion, Window: DefaultVoteWindow}
+	for _, v := range values {
+		vf.add(VoteEntry{At: time.Now(), Value: v, Weight: 1})
+	}
+	if err := os.WriteFile(filepath.Join(dir, recordID+VoteFileExt), EncodeVoteFile(vf), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordVoteCountsVoterOnce(t *testing.T) {
+	s, client, _ := newTestReputation(t)
+	ctx := context.Background()
+	subject := SubjectHash("张三")
+	s.RecordSubmission(subject, "r1", "owner")
+
+	for i := 0; i < 5; i++ {
+		s.RecordVote(subject, "r1", "voterA", 1)
+	}
+	s.RecordVote(subject, "r1", "voterB", 1)
+	s.RecordVote(subject, "r1", "owner", 1)
+	s.RecordVote(subject, "r1", "", 1)
+
+	if got := score(t, client, "owner"); !near(got, 1.04) {
+		t.Errorf("每个投票人只应奖励一次提交人: %v", got)
+	}
+	if n, _ := client.LLen(ctx, reputationPendingKey).Result(); n != 3 {
+		t.Errorf("每个投票人只应有一条待结算投票, 实际%d", n)
+	}
+
+	// 改票只更新Redis中的投票值
+	s.RecordVote(subject, "r1", "voterA", 0)
+	if v, _ := client.HGet(ctx, "icey:record-voters:"+subject+":r1", "voterA").Result(); v != "0" {
+		t.Errorf("应记录最后一次投票值: %q", v)
+	}
+}
+
+func TestSettleWaitsForConsensus(t *testing.T) {
+	s, client, config := newTestReputation(t)
+	ctx := context.Background()
+	subject := SubjectHash("李四")
+	old := time.Now().Add(-2 * time.Hour).Unix()
+
+	pushPending(t, client, pendingVote{Voter: "agree", Subject: subject, RecordID: "r1", Value: 1, At: old})
+	pushPending(t, client, pendingVote{Voter: "disagree", Subject: subject, RecordID: "r1", Value: 0, At: old})
+	pushPending(t, client, pendingVote{Voter: "fresh", Subject: subject, RecordID: "r1", Value: 1, At: time.Now().Unix()})
+	pushPending(t, client, pendingVote{Voter: "stale", Subject: subject, RecordID: "r2", Value: 1, At: time.Now().Add(-72 * time.Hour).Unix()})
+
+	// 票数不足时不结算，放回队列；超过期限仍无共识的丢弃
+	writeVotes(t, config, subject, "r1", 1, 0)
+	if n, err := s.Settle(ctx); err != nil || n != 0 {
+		t.Fatalf("没有共识时不应结算: %d, %v", n, err)
+	}
+	if n, _ := client.LLen(ctx, reputationPendingKey).Result(); n != 3 {
+		t.Fatalf("未形成共识的投票应保留, 剩余%d", n)
+	}
+
+	// 共识形成后结算到期的投票
+	writeVotes(t, config, subject, "r1", 1, 1, 1, 0)
+	if n, err := s.Settle(ctx); err != nil || n != 2 {
+		t.Fatalf("应结算2票: %d, %v", n, err)
+	}
+	if got := score(t, client, "agree"); !near(got, 1.05) {
+		t.Errorf("与共识一致应加分: %v", got)
+	}
+	if got := score(t, client, "disagree"); !near(got, 0.9) {
+		t.Errorf("与共识相反应扣分: %v", got)
+	}
+	if n, _ := client.LLen(ctx, reputationPendingKey).Result(); n != 1 {
+		t.Errorf("未到期的投票应保留, 剩余%d", n)
+	}
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"meea-icey/models"
+)
+
+// 可选的可信度评分方法
+const (
+	ScoreRaw    = "raw"    // 可信票数/总票数
+	ScoreWilson = "wilson" // Wilson 置信区间下界
+	ScoreBayes  = "bayes"  // 带先验的贝叶斯平均
+	ScoreDecay  = "decay"  // 按投票时间指数衰减后的贝叶斯平均
+)
+
+// Scorer 根据投票统计计算可信度评分(0-100)
+type Scorer struct {
+	method      string
+	z           float64
+	priorMean   float64
+	priorWeight float64
+	halfLife    time.Duration
+}
+
+// NewScorer 根据配置创建Scorer，未配置的参数使用默认值
+func NewScorer(config *models.Config) *Scorer {
+	cfg := config.Scoring
+	s := &Scorer{
+		method:      cfg.Method,
+		z:           cfg.WilsonZ,
+		priorMean:   cfg.PriorMean,
+		priorWeight: cfg.PriorWeight,
+		halfLife:    cfg.HalfLife,
+	}
+	if s.method == "" {
+		s.method = ScoreWilson
+	}
+	if s.z <= 0 {
+		s.z = 1.96
+	}
+	if s.priorMean <= 0 || s.priorMean >= 1 {
+		s.priorMean = 0.5
+	}
+	if s.priorWeight <= 0 {
+		s.priorWeight = 5
+	}
+	if s.halfLife <= 0 {
+		s.halfLife = 30 * 24 * time.Hour
+	}
+	return s
+}
+
+// ValidScoreMethod 检查评分方法是否受支持，空字符串表示使用默认方法
+func ValidScoreMethod(method string) bool {
+	switch method {
+	case "", ScoreRaw, ScoreWilson, ScoreBayes, ScoreDecay:
+		return true
+	}
+	return false
+}
+
+// Resolve 返回实际使用的评分方法，method为空时为配置的默认方法
+func (s *Scorer) Resolve(method string) string {
+	if method == "" {
+		return s.method
+	}
+	return method
+}
+
+// Score 按指定方法计算评分，method为空时使用配置的默认方法
+// 返回评分和实际使用的方法
+func (s *Scorer) Score(method string, stats *VoteStats, now time.Time) (float64, string, error) {
+	method = s.Resolve(method)
+
+	// 除raw外均使用按投票人信誉加权后的票数
+	pos := stats.WeightedTrue
+	n := stats.WeightedTotal
+
+	var score float64
+	switch method {
+	case ScoreRaw:
+		if stats.Total > 0 {
+			score = float64(stats.True) / float64(stats.Total)
+		}
+	case ScoreWilson:
+		score = WilsonLowerBound(pos, n, s.z)
+	case ScoreBayes:
+		score = BayesianAverage(pos, n, s.priorMean, s.priorWeight)
+	case ScoreDecay:
+		dPos, dN := DecayedCounts(stats, s.halfLife, now)
+		score = BayesianAverage(dPos, dN, s.priorMean, s.priorWeight)
+	default:
+		return 0, method, fmt.Errorf("不支持的评分方法: %s", method)
+	}
+	return math.Round(score*10000) / 100, method, nil
+}
+
+// WilsonLowerBound 计算Wilson置信区间下界，n为0时返回0
+func WilsonLowerBound(pos, n, z float64) float64 {
+	if n <= 0 {
+		return 0
+	}
+	phat := pos / n
+	z2 := z * z
+	return (phat + z2/(2*n) - z*math.Sqrt((phat*(1-phat)+z2/(4*n))/n)) / (1 + z2/n)
+}
+
+// BayesianAverage 计算贝叶斯平均: 先验相当于priorWeight张可信率为priorMean的虚拟投票
+func BayesianAverage(pos, n, priorMean, priorWeight float64) float64 {
+	return (pos + priorMean*priorWeight) / (n + priorWeight)
+}
+
+// DecayedCounts 按半衰期对加权投票做指数衰减，返回衰减后的可信票数与总票数
+// 近期窗口内的投票按各自时间衰减；窗口之外的历史投票没有时间信息，
+// 按不晚于窗口内最旧一票的时间处理。
+func DecayedCounts(stats *VoteStats, halfLife time.Duration, now time.Time) (float64, float64) {
+	if halfLife <= 0 {
+		return stats.WeightedTrue, stats.WeightedTotal
+	}
+	weight := func(at time.Time) float64 {
+		if at.IsZero() {
+			// 没有时间信息的投票不做衰减
+			return 1
+		}
+		age := now.Sub(at)
+		if age < 0 {
+			age = 0
+		}
+		return math.Pow(0.5, float64(age)/float64(halfLife))
+	}
+
+	var pos, n, recentTrue, recentTotal float64
+	for _, e := range stats.Recent {
+		w := weight(e.At) * e.Weight
+		n += w
+		recentTotal += e.Weight
+		if e.Value == 1 {
+			pos += w
+			recentTrue += e.Weight
+		}
+	}
+
+	oldTrue := stats.WeightedTrue - recentTrue
+	oldTotal := stats.WeightedTotal - recentTotal
+	if oldTotal > 1e-9 {
+		oldest := stats.FirstAt
+		if len(stats.Recent) > 0 {
+			oldest = stats.Recent[0].At
+		}
+		w := weight(oldest)
+		pos += oldTrue * w
+		n += oldTotal * w
+	}
+	return pos, n
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"meea-icey/models"
+)
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-6 }
+
+func TestWilsonLowerBound(t *testing.T) {
+	for _, c := range []struct{ pos, n, want float64 }{
+		{8, 10, 0.490157},
+		{80, 100, 0.711169},
+		{1, 1, 0.206543},
+		{0, 0, 0},
+	} {
+		if got := WilsonLowerBound(c.pos, c.n, 1.96); !near(got, c.want) {
+			t.Errorf("Wilson(%v/%v) = %v, 预期%v", c.pos, c.n, got, c.want)
+		}
+	}
+	// 可信率相同时票数越多下界越高
+	if WilsonLowerBound(8, 10, 1.96) >= WilsonLowerBound(80, 100, 1.96) {
+		t.Error("票数多的记录下界应更高")
+	}
+}
+
+func TestBayesianAverage(t *testing.T) {
+	if got := BayesianAverage(0, 0, 0.5, 5); got != 0.5 {
+		t.Errorf("没有投票时应等于先验: %v", got)
+	}
+	if got := BayesianAverage(8, 10, 0.5, 5); !near(got, 0.7) {
+		t.Errorf("BayesianAverage(8/10) = %v, 预期0.7", got)
+	}
+}
+
+func TestDecayedCounts(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	halfLife := 10 * 24 * time.Hour
+	stats := &VoteStats{
+		WeightedTrue:  3,
+		WeightedTotal: 5,
+		FirstAt:       now.Add(-40 * 24 * time.Hour),
+		Recent: []VoteEntry{
+			{At: now.Add(-10 * 24 * time.Hour), Value: 1, Weight: 1},
+			{At: now, Value: 0, Weight: 2},
+		},
+	}
+	// 窗口内: 0.5*1(可信) + 1*2(不可信)；窗口外的2票可信按窗口内最旧一票的时间衰减
+	pos, n := DecayedCounts(stats, halfLife, now)
+	if !near(pos, 0.5+2*0.5) || !near(n, 0.5+2+2*0.5) {
+		t.Errorf("衰减结果不符: pos=%v, n=%v", pos, n)
+	}
+
+	if pos, n := DecayedCounts(stats, 0, now); pos != 3 || n != 5 {
+		t.Errorf("半衰期为0时不应衰减: %v, %v", pos, n)
+	}
+}
+
+func TestScorer(t *testing.T) {
+	config := &models.Config{}
+	scorer := NewScorer(config)
+	stats := &VoteStats{True: 8, Total: 10, WeightedTrue: 8, WeightedTotal: 10}
+	now := time.Now()
+
+	for method, want := range map[string]float64{
+		"":          49.02,
+		ScoreRaw:    80,
+		ScoreWilson: 49.02,
+		ScoreBayes:  70,
+		ScoreDecay:  70,
+	} {
+		score, used, err := scorer.Score(method, stats, now)
+		if err != nil || score != want {
+			t.Errorf("%q 评分为%v(%v), 预期%v", method, score, err, want)
+		}
+		if method == "" && used != ScoreWilson {
+			t.Errorf("默认方法应为wilson: %s", used)
+		}
+	}
+	if _, _, err := scorer.Score("median", stats, now); err == nil || ValidScoreMethod("median") {
+		t.Error("不支持的方法应报错")
+	}
+}
@@ -0,0 +1,447 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ManifestFileName 主题记录清单文件名，位于主题目录下；汇总索引(summary.idx)由清单派生
+const ManifestFileName = "manifest.idx"
+
+// 记录状态
+const (
+	RecordActive  = "active"
+	RecordDeleted = "deleted"
+)
+
+const (
+	manifestVersion = 1
+	// 已删除记录的墓碑保留时长，超过后写清单时移除
+	tombstoneRetention = 30 * 24 * time.Hour
+	// 主题清单被其他请求或实例锁定时的最长等待时间和重试间隔
+	indexLockTimeout = 10 * time.Second
+	indexLockRetry   = 200 * time.Millisecond
+)
+
+// ManifestRecord 清单中的单条记录
+type ManifestRecord struct {
+	Prefix        string  `json:"prefix"` // 文件名前缀 "时间戳-雪花ID"
+	TS            int64   `json:"ts"`
+	Size          int64   `json:"size"`
+	True          int     `json:"true"`
+	False         int     `json:"false"`
+	WeightedTrue  float64 `json:"weighted_true"`
+	WeightedTotal float64 `json:"weighted_total"`
+	RecentTrue    int     `json:"recent_true"`
+	RecentFalse   int     `json:"recent_false"`
+	LastVoteAt    int64   `json:"last_vote_at,omitempty"`
+	VoteHash      string  `json:"vote_hash,omitempty"` // 计数对应的.vt文件内容哈希，不一致时以.vt为准
+	Category      string  `json:"category,omitempty"`
+	Status        string  `json:"status"`
+	DeletedAt     int64   `json:"deleted_at,omitempty"`
+}
+
+// SubjectManifest 主题清单，按雪花ID索引，查询/投票/删除无需扫描目录
+type SubjectManifest struct {
+	Version   int                        `json:"version"`
+	Records   map[string]*ManifestRecord `json:"records"`
+	UpdatedAt int64                      `json:"updated_at"`
+}
+
+// SubjectIndex 维护主题清单，汇总索引由清单计算后通过SummaryService写入
+type SubjectIndex struct {
+	voteStore *VoteStore
+	summaries *SummaryService
+	mu        sync.Mutex
+}
+
+// NewSubjectIndex 创建SubjectIndex实例
+func NewSubjectIndex(voteStore *VoteStore, summaries *SummaryService) *SubjectIndex {
+	return &SubjectIndex{voteStore: voteStore, summaries: summaries}
+}
+
+// RecordID 从文件名前缀中提取雪花ID
+func RecordID(prefix string) string {
+	if i := strings.LastIndex(prefix, "-"); i >= 0 {
+		return prefix[i+1:]
+	}
+	return prefix
+}
+
+// Load 读取主题清单，清单文件不存在时根据目录中的记录重建(不写盘)
+func (x *SubjectIndex) Load(dirPath string) (*SubjectManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dirPath, ManifestFileName))
+	if os.IsNotExist(err) {
+		return x.scan(dirPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取清单失败: %v", err)
+	}
+	var m SubjectManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("解析清单失败: %v", err)
+	}
+	if m.Records == nil {
+		m.Records = make(map[string]*ManifestRecord)
+	}
+	return &m, nil
+}
+
+// Lookup 按雪花ID查找有效记录
+func (x *SubjectIndex) Lookup(dirPath, id string) (*ManifestRecord, error) {
+	m, err := x.Load(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	rec, ok := m.Records[id]
+	if !ok || rec.Status != RecordActive {
+		return nil, nil
+	}
+	return rec, nil
+}
+
+// LoadSummary 读取主题汇总，汇总文件不存在时由清单计算
+func (x *SubjectIndex) LoadSummary(dirPath string) (*SubjectSummary, error) {
+	if !FileExists(filepath.Join(dirPath, SummaryFileName)) {
+		m, err := x.Load(dirPath)
+		if err != nil {
+			return nil, err
+		}
+		return m.Summary(), nil
+	}
+	return x.summaries.Load(dirPath)
+}
+
+// Verify 校验有效记录的计数与.vt文件是否一致：.vt被单独修改(例如手工提交或旧版本实例)
+// 而清单未更新时，以.vt文件的统计为准并返回changed=true，调用方应改用清单重新计算汇总。
+// 返回以记录前缀为键的完整统计(含近期窗口)，便于时间衰减评分使用
+func (x *SubjectIndex) Verify(dirPath string, m *SubjectManifest) (map[string]*VoteStats, bool) {
+	stats := make(map[string]*VoteStats, len(m.Records))
+	changed := false
+	for _, rec := range m.Active() {
+		data, err := os.ReadFile(filepath.Join(dirPath, rec.Prefix+VoteFileExt))
+		if err != nil {
+			continue
+		}
+		if hash := voteHash(data); hash != rec.VoteHash {
+			vf, err := DecodeVoteFile(data)
+			if err != nil {
+				continue
+			}
+			rec.setStats(vf.Stats())
+			rec.VoteHash = hash
+			changed = true
+			stats[rec.Prefix] = vf.Stats()
+			continue
+		}
+		if vf, err := DecodeVoteFile(data); err == nil {
+			stats[rec.Prefix] = vf.Stats()
+		}
+	}
+	return stats, changed
+}
+
+// Lock 锁定主题清单。同一主题的所有记录共用清单和汇总，提交、投票和删除在改写前都需持有该锁；
+// 多实例部署时通过LFS锁串行化，已被占用时在indexLockTimeout内重试。
+// 获得锁后调用方需重新拉取仓库，再读取清单
+func (x *SubjectIndex) Lock(dirPath string) error {
+	path := filepath.Join(dirPath, ManifestFileName)
+	deadline := time.Now().Add(indexLockTimeout)
+	for {
+		err := x.voteStore.gitService.LockFile(path)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrFileLocked) || time.Now().After(deadline) {
+			return fmt.Errorf("锁定主题清单失败: %v", err)
+		}
+		time.Sleep(indexLockRetry)
+	}
+}
+
+// Unlock 解锁主题清单
+func (x *SubjectIndex) Unlock(dirPath string) error {
+	if err := x.voteStore.gitService.UnlockFile(filepath.Join(dirPath, ManifestFileName)); err != nil {
+		return fmt.Errorf("解锁主题清单失败: %v", err)
+	}
+	return nil
+}
+
+// OnCommit 登记新记录，需在记录文件写入之后调用
+func (x *SubjectIndex) OnCommit(dirPath, prefix, category string) error {
+	return x.update(dirPath, func(m *SubjectManifest) error {
+		rec, err := x.recordFromFiles(dirPath, prefix)
+		if err != nil {
+			return err
+		}
+		rec.Category = category
+		m.Records[RecordID(prefix)] = rec
+		return nil
+	})
+}
+
+// OnVote 用最新的投票统计刷新记录计数，需在投票文件写入之后调用
+func (x *SubjectIndex) OnVote(dirPath, prefix string, stats *VoteStats) error {
+	return x.update(dirPath, func(m *SubjectManifest) error {
+		rec, ok := m.Records[RecordID(prefix)]
+		if !ok {
+			var err error
+			if rec, err = x.recordFromFiles(dirPath, prefix); err != nil {
+				return err
+			}
+			m.Records[RecordID(prefix)] = rec
+		}
+		rec.setStats(stats)
+		data, err := os.ReadFile(filepath.Join(dirPath, prefix+VoteFileExt))
+		if err != nil {
+			return fmt.Errorf("读取投票文件失败: %v", err)
+		}
+		rec.VoteHash = voteHash(data)
+		return nil
+	})
+}
+
+// OnDelete 将记录标记为已删除
+func (x *SubjectIndex) OnDelete(dirPath, prefix string) error {
+	return x.update(dirPath, func(m *SubjectManifest) error {
+		rec, ok := m.Records[RecordID(prefix)]
+		if !ok {
+			rec = &ManifestRecord{Prefix: prefix, TS: recordCreatedAt(prefix).UnixMilli()}
+			m.Records[RecordID(prefix)] = rec
+		}
+		rec.Status = RecordDeleted
+		rec.DeletedAt = time.Now().UnixMilli()
+		return nil
+	})
+}
+
+// Rebuild 根据目录中的文件重新生成清单和汇总并写盘，保留原清单中的分类
+func (x *SubjectIndex) Rebuild(dirPath string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	m, err := x.scan(dirPath)
+	if err != nil {
+		return err
+	}
+	if old, err := x.Load(dirPath); err == nil {
+		for id, rec := range m.Records {
+			if prev, ok := old.Records[id]; ok {
+				rec.Category = prev.Category
+			}
+		}
+	}
+	return x.write(dirPath, m)
+}
+
+// RebuildAll 重建仓库中所有主题的索引，返回相对于repoRoot的变更文件列表
+func (x *SubjectIndex) RebuildAll(repoRoot string) ([]string, error) {
+	var changed []string
+	err := filepath.WalkDir(repoRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		// 主题目录位于 aa/bb/cc/<subject> 第四层
+		rel, err := filepath.Rel(repoRoot, path)
+		if err != nil || strings.Count(rel, string(filepath.Separator)) != 3 {
+			return nil
+		}
+		if err := x.Rebuild(path); err != nil {
+			return fmt.Errorf("重建%s失败: %v", rel, err)
+		}
+		changed = append(changed, filepath.Join(rel, ManifestFileName), filepath.Join(rel, SummaryFileName))
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// update 读取、修改并写回清单和汇总
+func (x *SubjectIndex) update(dirPath string, fn func(m *SubjectManifest) error) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	m, err := x.Load(dirPath)
+	if err != nil {
+		return err
+	}
+	if err := fn(m); err != nil {
+		return err
+	}
+	return x.write(dirPath, m)
+}
+
+// write 原子写入清单和汇总，两个文件随同一次Git提交推送
+func (x *SubjectIndex) write(dirPath string, m *SubjectManifest) error {
+	now := time.Now()
+	m.Version = manifestVersion
+	m.UpdatedAt = now.UnixMilli()
+	m.compact(now)
+	sum := m.Summary()
+	sum.UpdatedAt = now.UnixMilli()
+
+	manifestData, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("序列化清单失败: %v", err)
+	}
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return fmt.Errorf("创建目录失败: %v", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dirPath, ManifestFileName), manifestData, 0644); err != nil {
+		return err
+	}
+	return x.summaries.Save(dirPath, sum)
+}
+
+// compact 移除超过保留期的墓碑；没有删除时间的旧墓碑从本次开始计时
+func (m *SubjectManifest) compact(now time.Time) {
+	cutoff := now.Add(-tombstoneRetention).UnixMilli()
+	for id, rec := range m.Records {
+		if rec.Status != RecordDeleted {
+			continue
+		}
+		if rec.DeletedAt == 0 {
+			rec.DeletedAt = now.UnixMilli()
+		} else if rec.DeletedAt < cutoff {
+			delete(m.Records, id)
+		}
+	}
+}
+
+// scan 扫描目录中的 .sj 文件生成清单
+func (x *SubjectIndex) scan(dirPath string) (*SubjectManifest, error) {
+	m := &SubjectManifest{Version: manifestVersion, Records: make(map[string]*ManifestRecord)}
+	entries, err := os.ReadDir(dirPath)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取目录失败: %v", err)
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".sj") {
+			continue
+		}
+		prefix := strings.TrimSuffix(entry.Name(), ".sj")
+		if len(strings.Split(prefix, "-")) != 2 {
+			continue
+		}
+		rec, err := x.recordFromFiles(dirPath, prefix)
+		if err != nil {
+			return nil, err
+		}
+		m.Records[RecordID(prefix)] = rec
+	}
+
+	// 汇总索引中记录了每条记录的分类，重建时沿用
+	if !FileExists(filepath.Join(dirPath, SummaryFileName)) {
+		return m, nil
+	}
+	if sum, err := x.summaries.Load(dirPath); err == nil {
+		for prefix, category := range sum.RecordCategories {
+			if rec, ok := m.Records[RecordID(prefix)]; ok {
+				rec.Category = category
+			}
+		}
+	}
+	return m, nil
+}
+
+// recordFromFiles 根据记录文件生成清单条目
+func (x *SubjectIndex) recordFromFiles(dirPath, prefix string) (*ManifestRecord, error) {
+	vtFile := filepath.Join(dirPath, prefix+VoteFileExt)
+	stats, err := x.voteStore.GetStats(vtFile)
+	if err != nil {
+		return nil, err
+	}
+	rec := &ManifestRecord{
+		Prefix: prefix,
+		TS:     recordCreatedAt(prefix).UnixMilli(),
+		Size:   GetFileSize(filepath.Join(dirPath, prefix+".sj")),
+		Status: RecordActive,
+	}
+	rec.setStats(stats)
+	if data, err := os.ReadFile(vtFile); err == nil {
+		rec.VoteHash = voteHash(data)
+	}
+	return rec, nil
+}
+
+// voteHash 投票文件内容哈希的前16位十六进制
+func voteHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (r *ManifestRecord) setStats(stats *VoteStats) {
+	r.True = stats.True
+	r.False = stats.False
+	r.WeightedTrue = stats.WeightedTrue
+	r.WeightedTotal = stats.WeightedTotal
+	r.RecentTrue = stats.RecentTrue
+	r.RecentFalse = stats.RecentFalse
+	r.LastVoteAt = unixMilli(stats.LastAt)
+}
+
+// Stats 由清单计数构造投票统计(不含近期窗口明细)
+func (r *ManifestRecord) Stats() *VoteStats {
+	st := &VoteStats{
+		True:          r.True,
+		False:         r.False,
+		Total:         r.True + r.False,
+		WeightedTrue:  r.WeightedTrue,
+		WeightedTotal: r.WeightedTotal,
+		RecentTrue:    r.RecentTrue,
+		RecentFalse:   r.RecentFalse,
+		RecentTotal:   r.RecentTrue + r.RecentFalse,
+		LastAt:        fromUnixMilli(r.LastVoteAt),
+	}
+	st.Percent = percentOf(st.True, st.Total)
+	st.RecentPercent = percentOf(st.RecentTrue, st.RecentTotal)
+	return st
+}
+
+// Active 返回有效记录，按时间倒序排列
+func (m *SubjectManifest) Active() []*ManifestRecord {
+	var records []*ManifestRecord
+	for _, rec := range m.Records {
+		if rec.Status == RecordActive {
+			records = append(records, rec)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].TS != records[j].TS {
+			return records[i].TS > records[j].TS
+		}
+		return records[i].Prefix > records[j].Prefix
+	})
+	return records
+}
+
+// Summary 由清单计算主题汇总
+func (m *SubjectManifest) Summary() *SubjectSummary {
+	sum := &SubjectSummary{}
+	for _, rec := range m.Active() {
+		sum.addRecord(rec.Prefix, rec.Category)
+		sum.addVotes(rec.True, rec.True+rec.False, rec.WeightedTrue, rec.WeightedTotal)
+	}
+	return sum
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestIndex(t *testing.T) (*SubjectIndex, string) {
+	t.Helper()
+	store := NewVoteStore(nil, 0)
+	return NewSubjectIndex(store, NewSummaryService(store)), t.TempDir()
+}
+
+// writeRecord 写入一条记录的内容和投票文件
+func writeRecord(t *testing.T, dir, prefix string, values ...uint8) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, prefix+".sj"), []byte("内容"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	vf := &VoteFile{Version: voteFileVersion, Window: DefaultVoteWindow}
+	for _, v := range values {
+		vf.add(VoteEntry{At: time.Now(), Value: v, Weight: 1})
+	}
+	if err := os.WriteFile(filepath.Join(dir, prefix+VoteFileExt), EncodeVoteFile(vf), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubjectIndexRebuild(t *testing.T) {
+	x, dir := newTestIndex(t)
+	writeRecord(t, dir, "1700000000000-1", 1, 1, 0)
+	writeRecord(t, dir, "1700000060000-2", 0)
+	if err := x.OnCommit(dir, "1700000060000-2", "诈骗"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 清单丢失后重建，分类从汇总索引中恢复
+	os.Remove(filepath.Join(dir, ManifestFileName))
+	if err := x.Rebuild(dir); err != nil {
+		t.Fatal(err)
+	}
+	m, err := x.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Active()) != 2 || m.Records["1"].True != 2 || m.Records["2"].Category != "诈骗" {
+		t.Fatalf("重建的清单不符: %+v, %+v", m.Records["1"], m.Records["2"])
+	}
+	sum, err := x.LoadSummary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Records != 2 || sum.TrueVotes != 2 || sum.TotalVotes != 4 || sum.Categories["诈骗"] != 1 {
+		t.Errorf("重建的汇总不符: %+v", sum)
+	}
+}
+
+func TestSubjectIndexVerify(t *testing.T) {
+	x, dir := newTestIndex(t)
+	writeRecord(t, dir, "1700000000000-1", 1)
+	if err := x.OnCommit(dir, "1700000000000-1", ""); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := x.Load(dir)
+	if _, stale := x.Verify(dir, m); stale {
+		t.Fatal("清单与投票文件一致时不应判定过期")
+	}
+
+	// 投票文件被其他实例更新而清单未更新时，以投票文件为准
+	writeRecord(t, dir, "1700000000000-1", 1, 0, 0)
+	m, _ = x.Load(dir)
+	stats, stale := x.Verify(dir, m)
+	if !stale || stats["1700000000000-1"].Total != 3 {
+		t.Fatalf("应使用投票文件统计: %v, %+v", stale, stats["1700000000000-1"])
+	}
+	if sum := m.Summary(); sum.TotalVotes != 3 || sum.TrueVotes != 1 {
+		t.Errorf("汇总应按校验后的计数计算: %+v", sum)
+	}
+}
+
+func TestSubjectIndexCompactsTombstones(t *testing.T) {
+	x, dir := newTestIndex(t)
+	writeRecord(t, dir, "1700000000000-1")
+	writeRecord(t, dir, "1700000060000-2")
+	x.OnCommit(dir, "1700000000000-1", "")
+	x.OnCommit(dir, "1700000060000-2", "")
+	if err := x.OnDelete(dir, "1700000000000-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.OnDelete(dir, "1700000060000-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	m, _ := x.Load(dir)
+	m.Records["1"].DeletedAt = time.Now().Add(-tombstoneRetention - time.Hour).UnixMilli()
+	if err := x.write(dir, m); err != nil {
+		t.Fatal(err)
+	}
+	m, _ = x.Load(dir)
+	if _, ok := m.Records["1"]; ok {
+		t.Error("超过保留期的墓碑应被移除")
+	}
+	if rec, ok := m.Records["2"]; !ok || rec.Status != RecordDeleted {
+		t.Error("保留期内的墓碑应保留")
+	}
+}
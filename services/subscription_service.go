@@ -0,0 +1,242 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"meea-icey/internal/events"
+	"meea-icey/internal/logging"
+	"meea-icey/models"
+)
+
+var subscriptionLog = logging.Component("subscription")
+
+// 订阅相关的Redis Key
+const (
+	subscribersKey  = "icey:subs:subject:%s"     // 主题的订阅用户(openid集合)
+	userSubsKey     = "icey:subs:user:%s"        // 用户订阅的主题(subject哈希集合)
+	notifyThrottle  = "icey:subs:throttle:%s:%s" // 通知节流标记
+	percentBaseline = "icey:subs:baseline:%s"    // 上次通知时各记录的可信率
+)
+
+// SubjectNameKey 主题原文的Redis Key，仅在主题有订阅用户时保存，用于在消息中展示主题
+func SubjectNameKey(subjectHash string) string {
+	return fmt.Sprintf("icey:subject-name:%s", subjectHash)
+}
+
+// dropSubjectName 主题没有订阅用户时删除保存的主题原文，与订阅的事务互斥
+var dropSubjectName = redis.NewScript(`
+if redis.call("SCARD", KEYS[1]) == 0 then
+	return redis.call("DEL", KEYS[2])
+end
+return 0
+`)
+
+// SubscriptionService 管理微信用户对主题的订阅，并在主题有新记录或可信率大幅变化时发送客服消息
+type SubscriptionService struct {
+	redisClient   *redis.Client
+	wechatService *WechatService
+
+	enabled        bool
+	throttle       time.Duration
+	shiftThreshold int
+	minVotes       int
+	maxPerUser     int
+}
+
+// NewSubscriptionService 创建SubscriptionService实例，未配置的参数使用默认值
+func NewSubscriptionService(config *models.Config, redisClient *redis.Client, wechatService *WechatService) *SubscriptionService {
+	cfg := config.Subscriptions
+	s := &SubscriptionService{
+		redisClient:    redisClient,
+		wechatService:  wechatService,
+		enabled:        cfg.Enabled,
+		throttle:       cfg.Throttle,
+		shiftThreshold: cfg.ShiftThreshold,
+		minVotes:       cfg.MinVotes,
+		maxPerUser:     cfg.MaxPerUser,
+	}
+	if s.throttle <= 0 {
+		s.throttle = time.Hour
+	}
+	if s.shiftThreshold <= 0 {
+		s.shiftThreshold = 20
+	}
+	if s.minVotes <= 0 {
+		s.minVotes = 5
+	}
+	if s.maxPerUser <= 0 {
+		s.maxPerUser = 20
+	}
+	return s
+}
+
+// Subscribe 订阅主题，返回主题哈希
+func (s *SubscriptionService) Subscribe(ctx context.Context, openid, subject string) (string, error) {
+	if !s.enabled {
+		return "", fmt.Errorf("订阅功能未开启")
+	}
+	subjectHash := SubjectHash(subject)
+	userKey := fmt.Sprintf(userSubsKey, openid)
+
+	already, err := s.redisClient.SIsMember(ctx, userKey, subjectHash).Result()
+	if err != nil {
+		return "", fmt.Errorf("读取订阅失败: %v", err)
+	}
+	if !already {
+		n, err := s.redisClient.SCard(ctx, userKey).Result()
+		if err != nil {
+			return "", fmt.Errorf("读取订阅失败: %v", err)
+		}
+		if int(n) >= s.maxPerUser {
+			return "", fmt.Errorf("最多只能订阅%d个主题", s.maxPerUser)
+		}
+	}
+
+	pipe := s.redisClient.TxPipeline()
+	pipe.SAdd(ctx, fmt.Sprintf(subscribersKey, subjectHash), openid)
+	pipe.SAdd(ctx, userKey, subjectHash)
+	pipe.Set(ctx, SubjectNameKey(subjectHash), subject, 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", fmt.Errorf("保存订阅失败: %v", err)
+	}
+	return subjectHash, nil
+}
+
+// Subscriptions 返回用户订阅的主题哈希
+func (s *SubscriptionService) Subscriptions(ctx context.Context, openid string) ([]string, error) {
+	hashes, err := s.redisClient.SMembers(ctx, fmt.Sprintf(userSubsKey, openid)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("读取订阅失败: %v", err)
+	}
+	return hashes, nil
+}
+
+// Unsubscribe 取消订阅
+func (s *SubscriptionService) Unsubscribe(ctx context.Context, openid, subjectHash string) error {
+	pipe := s.redisClient.TxPipeline()
+	pipe.SRem(ctx, fmt.Sprintf(subscribersKey, subjectHash), openid)
+	pipe.SRem(ctx, fmt.Sprintf(userSubsKey, openid), subjectHash)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("取消订阅失败: %v", err)
+	}
+	return s.forgetName(ctx, subjectHash)
+}
+
+// UnsubscribeAll 取消用户的全部订阅(用户取消关注时调用)
+func (s *SubscriptionService) UnsubscribeAll(ctx context.Context, openid string) error {
+	userKey := fmt.Sprintf(userSubsKey, openid)
+	hashes, err := s.redisClient.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return fmt.Errorf("读取订阅失败: %v", err)
+	}
+	pipe := s.redisClient.TxPipeline()
+	for _, h := range hashes {
+		pipe.SRem(ctx, fmt.Sprintf(subscribersKey, h), openid)
+	}
+	pipe.Del(ctx, userKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("取消订阅失败: %v", err)
+	}
+	for _, h := range hashes {
+		if err := s.forgetName(ctx, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forgetName 主题已没有订阅用户时删除主题原文
+func (s *SubscriptionService) forgetName(ctx context.Context, subjectHash string) error {
+	keys := []string{fmt.Sprintf(subscribersKey, subjectHash), SubjectNameKey(subjectHash)}
+	if err := dropSubjectName.Run(ctx, s.redisClient, keys).Err(); err != nil {
+		return fmt.Errorf("删除主题名称失败: %v", err)
+	}
+	return nil
+}
+
+func (s *SubscriptionService) Name() string { return "wechat-subscription" }
+
+// Handle 作为事件总线的接收端，按事件类型通知订阅用户
+func (s *SubscriptionService) Handle(ctx context.Context, e events.Event) error {
+	if !s.enabled {
+		return nil
+	}
+	switch e.Type {
+	case events.RecordCreated:
+		return s.notify(ctx, e.Subject, "您订阅的「%s」有新记录，发送“%s验证码”获取验证码后即可查询。")
+	case events.RecordVoted:
+		shifted, err := s.percentShifted(ctx, e)
+		if err != nil || !shifted {
+			return err
+		}
+		return s.notify(ctx, e.Subject, "您订阅的「%s」有记录的可信度发生了较大变化，发送“%s验证码”获取验证码后即可查看。")
+	case events.RecordDeleted:
+		return s.redisClient.HDel(ctx, fmt.Sprintf(percentBaseline, e.Subject), e.RecordID).Err()
+	}
+	return nil
+}
+
+// percentShifted 记录票数达到minVotes后，可信率相对上次通知变化超过阈值时返回true并更新基线
+func (s *SubscriptionService) percentShifted(ctx context.Context, e events.Event) (bool, error) {
+	total, _ := e.Data["total"].(int)
+	percent, _ := e.Data["percent"].(int)
+	if total < s.minVotes {
+		return false, nil
+	}
+
+	key := fmt.Sprintf(percentBaseline, e.Subject)
+	last, err := s.redisClient.HGet(ctx, key, e.RecordID).Result()
+	if err != nil && err != redis.Nil {
+		return false, fmt.Errorf("读取可信率基线失败: %v", err)
+	}
+	if err == redis.Nil {
+		// 首次达到票数门槛只记录基线
+		return false, s.redisClient.HSet(ctx, key, e.RecordID, percent).Err()
+	}
+	baseline, _ := strconv.Atoi(last)
+	diff := percent - baseline
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff < s.shiftThreshold {
+		return false, nil
+	}
+	return true, s.redisClient.HSet(ctx, key, e.RecordID, percent).Err()
+}
+
+// notify 给主题的所有订阅用户发送客服消息，同一用户同一主题在节流时间内只发送一次
+func (s *SubscriptionService) notify(ctx context.Context, subjectHash, format string) error {
+	openids, err := s.redisClient.SMembers(ctx, fmt.Sprintf(subscribersKey, subjectHash)).Result()
+	if err != nil {
+		return fmt.Errorf("读取订阅用户失败: %v", err)
+	}
+	if len(openids) == 0 {
+		return nil
+	}
+	name, err := s.redisClient.Get(ctx, SubjectNameKey(subjectHash)).Result()
+	if err != nil {
+		return fmt.Errorf("读取主题名称失败: %v", err)
+	}
+	content := fmt.Sprintf(format, name, name)
+
+	sent := 0
+	for _, openid := range openids {
+		first, err := s.redisClient.SetNX(ctx, fmt.Sprintf(notifyThrottle, subjectHash, openid), 1, s.throttle).Result()
+		if err != nil || !first {
+			continue
+		}
+		if err := s.wechatService.SendMessage(openid, content); err != nil {
+			subscriptionLog.Warn("发送通知失败", "error", err)
+			continue
+		}
+		sent++
+	}
+	if sent > 0 {
+		subscriptionLog.Info("已通知订阅用户", "count", sent)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"meea-icey/models"
+)
+
+func newTestSubscriptions(t *testing.T) (*SubscriptionService, *VerifyService, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	config := &models.Config{}
+	config.Subscriptions.Enabled = true
+	config.Subscriptions.MaxPerUser = 2
+	return NewSubscriptionService(config, client, nil), NewVerifyService(client, config), client
+}
+
+func TestSubjectNameOnlyStoredWhileSubscribed(t *testing.T) {
+	subs, verify, client := newTestSubscriptions(t)
+	ctx := context.Background()
+	subject := "张三13800000000"
+	nameKey := SubjectNameKey(SubjectHash(subject))
+
+	// 签发验证码不保存主题原文
+	if _, _, err := verify.IssueCode(ctx, subject, "owner"); err != nil {
+		t.Fatal(err)
+	}
+	if client.Exists(ctx, nameKey).Val() != 0 {
+		t.Fatal("签发验证码不应保存主题原文")
+	}
+
+	hash, err := subs.Subscribe(ctx, "openidA", subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs.Subscribe(ctx, "openidB", subject)
+	if client.Get(ctx, nameKey).Val() != subject {
+		t.Fatal("订阅时应保存主题原文用于通知")
+	}
+
+	// 仍有其他订阅用户时保留，最后一个用户退订后删除
+	if err := subs.Unsubscribe(ctx, "openidA", hash); err != nil {
+		t.Fatal(err)
+	}
+	if client.Exists(ctx, nameKey).Val() != 1 {
+		t.Fatal("仍有订阅用户时应保留主题原文")
+	}
+	if err := subs.UnsubscribeAll(ctx, "openidB"); err != nil {
+		t.Fatal(err)
+	}
+	if client.Exists(ctx, nameKey).Val() != 0 {
+		t.Error("没有订阅用户后应删除主题原文")
+	}
+}
+
+func TestSubscribeLimit(t *testing.T) {
+	subs, _, _ := newTestSubscriptions(t)
+	ctx := context.Background()
+	for _, subject := range []string{"主题一号码", "主题二号码"} {
+		if _, err := subs.Subscribe(ctx, "openid", subject); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := subs.Subscribe(ctx, "openid", "主题一号码"); err != nil {
+		t.Errorf("重复订阅不应计入上限: %v", err)
+	}
+	if _, err := subs.Subscribe(ctx, "openid", "主题三号码"); err == nil {
+		t.Error("超过订阅上限应报错")
+	}
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SummaryFileName 主题汇总索引文件名，位于主题目录下
+const SummaryFileName = "summary.idx"
+
+// topCategoryLimit 汇总中返回的热门分类数量
+const topCategoryLimit = 5
+
+// SubjectSummary 主题汇总索引，由主题清单派生，随清单在提交/投票/删除时一起写入
+type SubjectSummary struct {
+	Records          int               `json:"records"`
+	TrueVotes        int               `json:"true_votes"`
+	TotalVotes       int               `json:"total_votes"`
+	WeightedTrue     float64           `json:"weighted_true"`
+	WeightedTotal    float64           `json:"weighted_total"`
+	FirstAt          int64             `json:"first_at"`
+	LastAt           int64             `json:"last_at"`
+	Categories       map[string]int    `json:"categories,omitempty"`
+	RecordCategories map[string]string `json:"record_categories,omitempty"`
+	UpdatedAt        int64             `json:"updated_at"`
+}
+
+// CategoryCount 分类及其记录数
+type CategoryCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// SummaryView 查询接口返回的汇总信息
+type SummaryView struct {
+	Records       int             `json:"records"`
+	TotalVotes    int             `json:"total_votes"`
+	Credibility   float64         `json:"credibility"`
+	ScoreMethod   string          `json:"score_method"`
+	FirstAt       int64           `json:"first_at,omitempty"`
+	LastAt        int64           `json:"last_at,omitempty"`
+	TopCategories []CategoryCount `json:"top_categories"`
+}
+
+// SummaryService 读写主题汇总索引文件
+type SummaryService struct {
+	voteStore *VoteStore
+	mu        sync.Mutex
+}
+
+// NewSummaryService 创建SummaryService实例
+func NewSummaryService(voteStore *VoteStore) *SummaryService {
+	return &SummaryService{voteStore: voteStore}
+}
+
+// Load 读取主题汇总，索引文件不存在时根据目录中的记录重建(不写盘)
+func (s *SummaryService) Load(dirPath string) (*SubjectSummary, error) {
+	data, err := os.ReadFile(filepath.Join(dirPath, SummaryFileName))
+	if os.IsNotExist(err) {
+		return s.Rebuild(dirPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取汇总索引失败: %v", err)
+	}
+	var sum SubjectSummary
+	if err := json.Unmarshal(data, &sum); err != nil {
+		return nil, fmt.Errorf("解析汇总索引失败: %v", err)
+	}
+	return &sum, nil
+}
+
+// Rebuild 扫描目录中的记录重新计算汇总；历史记录没有分类信息
+func (s *SummaryService) Rebuild(dirPath string) (*SubjectSummary, error) {
+	sum := &SubjectSummary{}
+	entries, err := os.ReadDir(dirPath)
+	if os.IsNotExist(err) {
+		return sum, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取目录失败: %v", err)
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".sj") {
+			continue
+		}
+		prefix := strings.TrimSuffix(entry.Name(), ".sj")
+		stats, err := s.voteStore.GetStats(filepath.Join(dirPath, prefix+VoteFileExt))
+		if err != nil {
+			return nil, err
+		}
+		sum.addRecord(prefix, "")
+		sum.addVotes(stats.True, stats.Total, stats.WeightedTrue, stats.WeightedTotal)
+	}
+	return sum, nil
+}
+
+// Save 原子写入汇总索引
+func (s *SummaryService) Save(dirPath string, sum *SubjectSummary) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(sum)
+	if err != nil {
+		return fmt.Errorf("序列化汇总索引失败: %v", err)
+	}
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return fmt.Errorf("创建目录失败: %v", err)
+	}
+	return writeFileAtomic(filepath.Join(dirPath, SummaryFileName), data, 0644)
+}
+
+// View 生成查询接口使用的汇总信息
+func (s *SubjectSummary) View(scorer *Scorer, method string, now time.Time) (*SummaryView, error) {
+	stats := &VoteStats{
+		True:          s.TrueVotes,
+		Total:         s.TotalVotes,
+		WeightedTrue:  s.WeightedTrue,
+		WeightedTotal: s.WeightedTotal,
+	}
+	// 汇总不保留投票时间，衰减评分退化为贝叶斯平均
+	if method == ScoreDecay {
+		method = ScoreBayes
+	}
+	credibility, used, err := scorer.Score(method, stats, now)
+	if err != nil {
+		return nil, err
+	}
+
+	view := &SummaryView{
+		Records:       s.Records,
+		TotalVotes:    s.TotalVotes,
+		Credibility:   credibility,
+		ScoreMethod:   used,
+		FirstAt:       s.FirstAt,
+		LastAt:        s.LastAt,
+		TopCategories: []CategoryCount{},
+	}
+	for name, count := range s.Categories {
+		view.TopCategories = append(view.TopCategories, CategoryCount{Name: name, Count: count})
+	}
+	sort.Slice(view.TopCategories, func(i, j int) bool {
+		if view.TopCategories[i].Count != view.TopCategories[j].Count {
+			return view.TopCategories[i].Count > view.TopCategories[j].Count
+		}
+		return view.TopCategories[i].Name < view.TopCategories[j].Name
+	})
+	if len(view.TopCategories) > topCategoryLimit {
+		view.TopCategories = view.TopCategories[:topCategoryLimit]
+	}
+	return view, nil
+}
+
+func (s *SubjectSummary) addRecord(prefix, category string) {
+	s.Records++
+	ts := recordCreatedAt(prefix).UnixMilli()
+	if s.FirstAt == 0 || ts < s.FirstAt {
+		s.FirstAt = ts
+	}
+	if ts > s.LastAt {
+		s.LastAt = ts
+	}
+	if category != "" {
+		if s.Categories == nil {
+			s.Categories = make(map[string]int)
+		}
+		if s.RecordCategories == nil {
+			s.RecordCategories = make(map[string]string)
+		}
+		s.Categories[category]++
+		s.RecordCategories[prefix] = category
+	}
+}
+
+func (s *SubjectSummary) addVotes(trueVotes, total int, weightedTrue, weightedTotal float64) {
+	s.TrueVotes += trueVotes
+	s.TotalVotes += total
+	s.WeightedTrue += weightedTrue
+	s.WeightedTotal += weightedTotal
+	if s.WeightedTotal < 1e-9 {
+		s.WeightedTrue, s.WeightedTotal = 0, 0
+	}
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"meea-icey/models"
+)
+
+func TestSummaryServiceRebuild(t *testing.T) {
+	store := NewVoteStore(nil, 0)
+	s := NewSummaryService(store)
+	dir := t.TempDir()
+	writeRecord(t, dir, "1700000000000-1", 1, 1)
+	writeRecord(t, dir, "1700000060000-2", 0)
+
+	// 没有索引文件时由目录中的记录计算
+	sum, err := s.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Records != 2 || sum.TrueVotes != 2 || sum.TotalVotes != 3 {
+		t.Fatalf("重建的汇总不符: %+v", sum)
+	}
+	if sum.FirstAt != 1700000000000 || sum.LastAt != 1700000060000 {
+		t.Errorf("记录时间范围不符: %d, %d", sum.FirstAt, sum.LastAt)
+	}
+
+	sum.addRecord("1700000120000-3", "诈骗")
+	if err := s.Save(dir, sum); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := s.Load(dir)
+	if err != nil || saved.Records != 3 || saved.RecordCategories["1700000120000-3"] != "诈骗" {
+		t.Fatalf("应读取已保存的汇总: %+v, %v", saved, err)
+	}
+
+	view, err := saved.View(NewScorer(&models.Config{}), ScoreRaw, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.Records != 3 || len(view.TopCategories) != 1 || view.TopCategories[0].Name != "诈骗" {
+		t.Errorf("汇总视图不符: %+v", view)
+	}
+}
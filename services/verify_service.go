@@ -2,12 +2,23 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
 	"github.com/go-redis/redis/v8"
-	"log"
+	"log/slog"
+	"math/big"
+	"meea-icey/internal/metrics"
 	"meea-icey/models"
+	"strings"
+	"time"
 )
 
+// 验证码有效期
+const codeTTL = 24 * time.Hour
+
+// 用户当前有效的验证码("subject哈希:验证码"集合)，key为openid哈希
+const userCodesKey = "icey:user-codes:%s"
+
 type VerifyService struct {
 	redisClient *redis.Client
 	config      *models.Config
@@ -20,13 +31,15 @@ func NewVerifyService(redisClient *redis.Client, config *models.Config) *VerifyS
 	}
 }
 
-func (s *VerifyService) VerifyCode(subject, code string) (bool, error) {
+func (s *VerifyService) VerifyCode(ctx context.Context, subject, code string) (ok bool, err error) {
+	defer func() { metrics.Verification(ok, err) }()
 	// 拼接Redis Key
 	redisKey := fmt.Sprintf("icey:subject:%s:%s", subject, code)
-	fmt.Printf("Verifying code with Redis key: %s\n", redisKey)
+	slog.DebugContext(ctx, "校验验证码")
 
 	// 检查Key是否存在
-	exists, err := s.redisClient.Exists(context.Background(), redisKey).Result()
+	var exists int64
+	exists, err = s.redisClient.Exists(ctx, redisKey).Result()
 	if err != nil {
 		return false, fmt.Errorf("检查验证码失败: %v", err)
 	}
@@ -35,23 +48,105 @@ func (s *VerifyService) VerifyCode(subject, code string) (bool, error) {
 	}
 
 	// 检查使用次数
-	count, err := s.redisClient.Get(context.Background(), redisKey).Int()
+	count, err := s.redisClient.Get(ctx, redisKey).Int()
 	if err != nil {
 		return false, fmt.Errorf("获取验证码使用次数失败: %v", err)
 	}
 	if count >= s.config.Verification.MaxAttempts {
 		// 删除超过使用次数限制的验证码
-		if err := s.redisClient.Del(context.Background(), redisKey).Err(); err != nil {
-			log.Printf("删除过期验证码失败: %v", err)
+		if err := s.redisClient.Del(ctx, redisKey).Err(); err != nil {
+			slog.WarnContext(ctx, "删除过期验证码失败", "error", err)
 		}
 		return false, nil
 	}
 
 	// 增加使用次数
-	_, err = s.redisClient.Incr(context.Background(), redisKey).Result()
+	_, err = s.redisClient.Incr(ctx, redisKey).Result()
 	if err != nil {
 		return false, fmt.Errorf("更新验证码使用次数失败: %v", err)
 	}
 
 	return true, nil
+}
+
+// IssueCode 为用户生成主题验证码，返回主题哈希和验证码
+// owner为用户身份的哈希(微信用户为openid哈希)，各签发渠道共用同一份Redis记录
+func (s *VerifyService) IssueCode(ctx context.Context, subject, owner string) (string, string, error) {
+	subjectHash := SubjectHash(subject)
+	code := generateSixDigitCode()
+
+	pipe := s.redisClient.TxPipeline()
+	// 初始使用次数为0
+	pipe.Set(ctx, fmt.Sprintf("icey:subject:%s:%s", subjectHash, code), 0, codeTTL)
+	// 记录验证码所属用户，用于投票权重计算
+	pipe.Set(ctx, CodeOwnerKey(subjectHash, code), owner, codeTTL)
+	userKey := fmt.Sprintf(userCodesKey, owner)
+	pipe.SAdd(ctx, userKey, subjectHash+":"+code)
+	pipe.Expire(ctx, userKey, codeTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", "", fmt.Errorf("存储验证码失败: %v", err)
+	}
+	return subjectHash, code, nil
+}
+
+// RevokeCodes 作废用户所有未过期的验证码，返回作废数量
+func (s *VerifyService) RevokeCodes(ctx context.Context, owner string) (int, error) {
+	userKey := fmt.Sprintf(userCodesKey, owner)
+	entries, err := s.redisClient.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("读取验证码失败: %v", err)
+	}
+	keys := []string{userKey}
+	revoked := 0
+	for _, entry := range entries {
+		subjectHash, code, ok := strings.Cut(entry, ":")
+		if !ok {
+			continue
+		}
+		keys = append(keys,
+			fmt.Sprintf("icey:subject:%s:%s", subjectHash, code),
+			CodeOwnerKey(subjectHash, code),
+		)
+		revoked++
+	}
+	if err := s.redisClient.Del(ctx, keys...).Err(); err != nil {
+		return 0, fmt.Errorf("作废验证码失败: %v", err)
+	}
+	return revoked, nil
+}
+
+// CodeTTL 返回验证码有效期
+func (s *VerifyService) CodeTTL() time.Duration {
+	return codeTTL
+}
+
+// 生成六位随机数字验证码
+func generateSixDigitCode() string {
+	max := big.NewInt(900000)
+	min := big.NewInt(100000)
+	// 生成 [0, 900000) 之间的随机数
+	randNum, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "123456"
+	}
+	// 加上 min，得到 [100000, 1000000) 之间的随机数
+	result := new(big.Int).Add(randNum, min)
+	return result.String()
+}
+
+// CodeOwnerKey 验证码所属用户(openid哈希)的Redis Key
+func CodeOwnerKey(subject, code string) string {
+	return fmt.Sprintf("icey:code-owner:%s:%s", subject, code)
+}
+
+// CodeOwner 返回验证码所属用户的openid哈希，未知时返回空字符串
+func (s *VerifyService) CodeOwner(subject, code string) string {
+	owner, err := s.redisClient.Get(context.Background(), CodeOwnerKey(subject, code)).Result()
+	if err != nil {
+		if err != redis.Nil {
+			slog.Warn("获取验证码所属用户失败", "error", err)
+		}
+		return ""
+	}
+	return owner
 }
\ No newline at end of file
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConvertLegacyBitmap 将旧版 .bm/.bmi 文件对转换为投票文件
+// 旧格式中 bmi[i]==1 表示第i位有投票，bm[i] 为投票值；
+// 旧文件没有投票时间，统一使用记录的创建时间。
+func ConvertLegacyBitmap(bmFile, bmiFile string, window int) (*VoteFile, error) {
+	bm, err := os.ReadFile(bmFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取bm文件失败: %v", err)
+	}
+	bmi, err := os.ReadFile(bmiFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取bmi文件失败: %v", err)
+	}
+
+	if window <= 0 || window > 0xFFFF {
+		window = DefaultVoteWindow
+	}
+	vf := &VoteFile{Version: voteFileVersion, Window: uint16(window)}
+	at := recordCreatedAt(bmFile)
+	for i := 0; i < len(bmi) && i < len(bm); i++ {
+		if bmi[i] != 1 {
+			continue
+		}
+		value := uint8(0)
+		if bm[i] == 1 {
+			value = 1
+		}
+		vf.add(VoteEntry{At: at, Value: value, Weight: 1})
+	}
+	return vf, nil
+}
+
+// MigrateLegacy 遍历仓库，将所有旧版 .bm/.bmi 文件对转换为 .vt 文件并删除旧文件
+// 返回相对于repoRoot的变更文件列表，dryRun为true时只统计不写盘
+func (s *VoteStore) MigrateLegacy(repoRoot string, dryRun bool) ([]string, error) {
+	var changed []string
+	err := filepath.WalkDir(repoRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != legacyBitmapExt {
+			return nil
+		}
+
+		prefix := trimVoteExt(path)
+		bmiFile := prefix + legacyBitmapIdxExt
+		vtFile := prefix + VoteFileExt
+		if !FileExists(bmiFile) {
+			return nil
+		}
+
+		files := []string{path, bmiFile}
+		if !FileExists(vtFile) {
+			files = append(files, vtFile)
+			if !dryRun {
+				vf, err := ConvertLegacyBitmap(path, bmiFile, s.window)
+				if err != nil {
+					return fmt.Errorf("转换%s失败: %v", path, err)
+				}
+				if err := writeFileAtomic(vtFile, EncodeVoteFile(vf), 0644); err != nil {
+					return fmt.Errorf("写入%s失败: %v", vtFile, err)
+				}
+			}
+		}
+		if !dryRun {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("删除%s失败: %v", path, err)
+			}
+			if err := os.Remove(bmiFile); err != nil {
+				return fmt.Errorf("删除%s失败: %v", bmiFile, err)
+			}
+		}
+
+		for _, f := range files {
+			rel, err := filepath.Rel(repoRoot, f)
+			if err != nil {
+				return err
+			}
+			changed = append(changed, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// recordCreatedAt 从"时间戳-雪花ID"格式的文件名中解析记录创建时间
+func recordCreatedAt(filePath string) time.Time {
+	prefix := trimVoteExt(filepath.Base(filePath))
+	ts, err := strconv.ParseInt(strings.SplitN(prefix, "-", 2)[0], 10, 64)
+	if err != nil || ts <= 0 {
+		return time.Now()
+	}
+	return time.UnixMilli(ts)
+}
@@ -1,8 +1,15 @@
 package services
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"os"
 	"path/filepath"
+	"time"
+
+	"meea-icey/internal/events"
+	"meea-icey/internal/metrics"
 	"meea-icey/models"
 )
 
@@ -10,90 +17,199 @@ type VoteService struct {
 	config        *models.Config
 	verifyService *VerifyService
 	gitService    *GitService
-	bitmapService *BitmapService
+	syncer        *RepoSyncer
+	voteStore     *VoteStore
+	reputation    *ReputationService
+	index         *SubjectIndex
+	events        *events.Bus
 }
 
-func NewVoteService(config *models.Config, verifyService *VerifyService, gitService *GitService, bitmapService *BitmapService) *VoteService {
+func NewVoteService(config *models.Config, verifyService *VerifyService, gitService *GitService, syncer *RepoSyncer, voteStore *VoteStore, reputation *ReputationService, index *SubjectIndex, bus *events.Bus) *VoteService {
 	return &VoteService{
 		config:        config,
 		verifyService: verifyService,
 		gitService:    gitService,
-		bitmapService: bitmapService,
+		syncer:        syncer,
+		voteStore:     voteStore,
+		reputation:    reputation,
+		index:         index,
+		events:        bus,
 	}
 }
 
-// 投票，返回最新可信比例
-func (s *VoteService) Vote(subject, id string, vote uint8, code string) (int, error) {
-	fmt.Printf("[Vote] subject=%s, id=%s, vote=%d, code=%s\n", subject, id, vote, code)
+// 投票，返回最新统计结果
+func (s *VoteService) Vote(ctx context.Context, subject, id string, vote uint8, code string) (*VoteStats, error) {
+	slog.DebugContext(ctx, "处理投票", "id", id, "vote", vote)
 	// 验证 subject 和 code
 	if len(subject) != 64 {
-		fmt.Printf("[Vote] subject格式不正确\n")
-		return 0, fmt.Errorf("subject格式不正确，必须是64位十六进制字符串")
+		return nil, fmt.Errorf("subject格式不正确，必须是64位十六进制字符串")
 	}
-	valid, err := s.verifyService.VerifyCode(subject, code)
+	valid, err := s.verifyService.VerifyCode(ctx, subject, code)
 	if err != nil {
-		fmt.Printf("[Vote] 验证码验证失败: %v\n", err)
-		return 0, fmt.Errorf("验证码验证失败: %v", err)
+		slog.ErrorContext(ctx, "验证码验证失败", "error", err)
+		return nil, fmt.Errorf("验证码验证失败: %v", err)
 	}
 	if !valid {
-		fmt.Printf("[Vote] 验证码无效或已过期\n")
-		return 0, fmt.Errorf("验证码无效或已过期")
+		return nil, fmt.Errorf("验证码无效或已过期")
 	}
 
-	// 验证码验证通过后，先拉取/克隆仓库
-	fmt.Printf("[Vote] 拉取/克隆仓库...\n")
-	if err := s.gitService.PullRepository("icey-storage"); err != nil {
-		fmt.Printf("[Vote] 拉取仓库失败: %v\n", err)
-		return 0, fmt.Errorf("拉取仓库失败: %v", err)
+	// 验证码验证通过后，锁定主题清单和本地仓库直到推送完成，加锁时拉取/克隆仓库
+	dirPath, relativePath := BuildSubjectPath(s.config.Repository.ClonePath, subject)
+	if err := s.index.Lock(dirPath); err != nil {
+		slog.WarnContext(ctx, "主题清单加锁失败", "error", err)
+		return nil, err
+	}
+	defer s.index.Unlock(dirPath)
+	unlock, err := s.syncer.Lock()
+	if err != nil {
+		slog.ErrorContext(ctx, "拉取仓库失败", "error", err)
+		return nil, fmt.Errorf("拉取仓库失败: %v", err)
 	}
+	defer unlock()
 
-	// 构建 bitmap 路径
-	part1 := subject[:2]
-	part2 := subject[2:4]
-	part3 := subject[4:6]
-	dirPath := filepath.Join(s.config.Repository.ClonePath, "icey-storage", part1, part2, part3, subject)
-	filePrefix := id
-	bmFile := filepath.Join(dirPath, filePrefix+".bm")
-	bmiFile := filepath.Join(dirPath, filePrefix+".bmi")
+	// 通过清单确认记录存在
+	rec, err := s.index.Lookup(dirPath, RecordID(id))
+	if err != nil {
+		slog.ErrorContext(ctx, "读取主题清单失败", "error", err)
+		return nil, fmt.Errorf("读取主题清单失败: %v", err)
+	}
+	if rec == nil || rec.Prefix != id {
+		return nil, fmt.Errorf("记录不存在或已删除")
+	}
 
-	fmt.Printf("[Vote] bitmap路径: bmFile=%s, bmiFile=%s\n", bmFile, bmiFile)
+	// 构建投票文件路径
+	filePrefix := rec.Prefix
+	vtFile := filepath.Join(dirPath, filePrefix+VoteFileExt)
+	filesToCommit := []string{
+		filepath.Join(relativePath, filePrefix+VoteFileExt),
+		filepath.Join(relativePath, ManifestFileName),
+		filepath.Join(relativePath, SummaryFileName),
+	}
 
 	// 加锁
-	fmt.Printf("[Vote] 尝试加锁...\n")
-	if err := s.bitmapService.LockBitmaps(bmFile, bmiFile); err != nil {
-		fmt.Printf("[Vote] 加锁失败: %v\n", err)
-		return 0, fmt.Errorf("锁定bitmap文件失败: %v", err)
+	if err := s.voteStore.Lock(vtFile); err != nil {
+		slog.WarnContext(ctx, "投票文件加锁失败", "error", err)
+		return nil, err
 	}
-	defer func() {
-		fmt.Printf("[Vote] 尝试解锁...\n")
-		s.bitmapService.UnlockBitmaps(bmFile, bmiFile)
-	}()
+	defer s.voteStore.Unlock(vtFile)
+
+	// 尚未迁移的记录先就地转换旧版bitmap
+	migration, err := s.migrateRecord(dirPath, filePrefix)
+	if err != nil {
+		slog.ErrorContext(ctx, "迁移旧版bitmap失败", "error", err)
+		return nil, fmt.Errorf("迁移旧版bitmap失败: %v", err)
+	}
+	if migration != nil {
+		for _, name := range migration.removed {
+			filesToCommit = append(filesToCommit, filepath.Join(relativePath, name))
+		}
+	}
+	headBefore, _ := s.gitService.HeadCommit()
+
+	// 按投票人信誉加权
+	voter := s.verifyService.CodeOwner(subject, code)
+	entry := VoteEntry{At: time.Now(), Value: vote, Weight: s.reputation.Weight(voter)}
 
 	// 添加投票
-	fmt.Printf("[Vote] 添加投票... vote=%d\n", vote)
-	if err := s.bitmapService.AddBit(bmFile, bmiFile, vote); err != nil {
-		fmt.Printf("[Vote] 添加投票失败: %v\n", err)
-		return 0, fmt.Errorf("添加投票失败: %v", err)
+	if err := s.voteStore.Append(vtFile, entry); err != nil {
+		slog.ErrorContext(ctx, "添加投票失败", "error", err)
+		return nil, fmt.Errorf("添加投票失败: %v", err)
+	}
+
+	// 统计最新结果并刷新主题清单
+	stats, err := s.voteStore.GetStats(vtFile)
+	if err != nil {
+		slog.ErrorContext(ctx, "统计投票失败", "error", err)
+		return nil, fmt.Errorf("统计投票失败: %v", err)
+	}
+	if err := s.index.OnVote(dirPath, filePrefix, stats); err != nil {
+		slog.ErrorContext(ctx, "更新主题清单失败", "error", err)
+		return nil, fmt.Errorf("更新主题清单失败: %v", err)
 	}
 
-	// 提交变更（bm和bmi都要提交）
+	// 提交变更
 	commitMsg := fmt.Sprintf("vote update for %s-%s", subject, id)
-	fmt.Printf("[Vote] 提交变更: %s\n", commitMsg)
-	if err := s.gitService.CommitChanges("icey-storage", []string{
-		filepath.Join(part1, part2, part3, subject, filePrefix+".bm"),
-		filepath.Join(part1, part2, part3, subject, filePrefix+".bmi"),
-	}, commitMsg); err != nil {
-		fmt.Printf("[Vote] Git提交失败: %v\n", err)
-		return 0, fmt.Errorf("Git提交失败: %v", err)
-	}
-
-	// 统计最新结果
-	fmt.Printf("[Vote] 统计最新结果...\n")
-	percent, err := s.bitmapService.GetStats(bmFile, bmiFile)
+	if err := s.gitService.CommitChanges("icey-storage", filesToCommit, commitMsg); err != nil {
+		slog.ErrorContext(ctx, "Git提交失败", "error", err)
+		// 未生成提交时还原旧版文件，避免工作区残留未提交的迁移结果
+		if head, _ := s.gitService.HeadCommit(); migration != nil && head == headBefore {
+			if rerr := migration.restore(); rerr != nil {
+				slog.ErrorContext(ctx, "还原旧版bitmap失败", "error", rerr)
+			}
+		}
+		return nil, fmt.Errorf("Git提交失败: %v", err)
+	}
+	s.reputation.RecordVote(subject, id, voter, vote)
+	metrics.Vote(vote)
+	s.events.Publish(events.New(events.RecordVoted, subject, filePrefix, map[string]interface{}{
+		"vote":           vote,
+		"weight":         entry.Weight,
+		"total":          stats.Total,
+		"percent":        stats.Percent,
+		"recent_percent": stats.RecentPercent,
+		"weighted_true":  stats.WeightedTrue,
+		"weighted_total": stats.WeightedTotal,
+	}))
+
+	slog.InfoContext(ctx, "投票完成", "id", id, "vote", vote, "weight", entry.Weight, "percent", stats.Percent, "recent_percent", stats.RecentPercent)
+	return stats, nil
+}
+
+// legacyMigration 一次就地迁移的结果，提交失败时用于还原旧版文件
+type legacyMigration struct {
+	vtFile  string
+	removed []string          // 被删除的旧文件名(相对于主题目录)
+	backup  map[string][]byte // 旧文件完整路径到原内容
+}
+
+// restore 写回旧版 .bm/.bmi 并删除生成的 .vt
+func (m *legacyMigration) restore() error {
+	for path, data := range m.backup {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return fmt.Errorf("写回%s失败: %v", filepath.Base(path), err)
+		}
+	}
+	if err := os.Remove(m.vtFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除%s失败: %v", filepath.Base(m.vtFile), err)
+	}
+	return nil
+}
+
+// migrateRecord 将单条记录的旧版 .bm/.bmi 转换为 .vt，调用方需已锁定.vt；无需迁移时返回nil
+func (s *VoteService) migrateRecord(dirPath, filePrefix string) (*legacyMigration, error) {
+	vtFile := filepath.Join(dirPath, filePrefix+VoteFileExt)
+	bmFile := filepath.Join(dirPath, filePrefix+legacyBitmapExt)
+	bmiFile := filepath.Join(dirPath, filePrefix+legacyBitmapIdxExt)
+	if FileExists(vtFile) || !FileExists(bmFile) || !FileExists(bmiFile) {
+		return nil, nil
+	}
+
+	m := &legacyMigration{
+		vtFile:  vtFile,
+		removed: []string{filePrefix + legacyBitmapExt, filePrefix + legacyBitmapIdxExt},
+		backup:  make(map[string][]byte, 2),
+	}
+	for _, path := range []string{bmFile, bmiFile} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("读取%s失败: %v", filepath.Base(path), err)
+		}
+		m.backup[path] = data
+	}
+
+	vf, err := ConvertLegacyBitmap(bmFile, bmiFile, s.voteStore.window)
 	if err != nil {
-		fmt.Printf("[Vote] 统计bitmap失败: %v\n", err)
-		return 0, fmt.Errorf("统计bitmap失败: %v", err)
+		return nil, err
+	}
+	if err := writeFileAtomic(vtFile, EncodeVoteFile(vf), 0644); err != nil {
+		return nil, err
 	}
-	fmt.Printf("[Vote] 完成，percent=%d\n", percent)
-	return percent, nil
-} 
\ No newline at end of file
+	for _, path := range []string{bmFile, bmiFile} {
+		if err := os.Remove(path); err != nil {
+			m.restore()
+			return nil, err
+		}
+	}
+	metrics.VoteMigration()
+	return m, nil
+}
@@ -0,0 +1,362 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 投票文件(.vt)格式，所有整数均为大端序:
+//
+//	头部(v1为48字节，v2/v3为64字节):
+//	  [0:4]   魔数 "ICVT"
+//	  [4:6]   版本号
+//	  [6:8]   近期窗口容量
+//	  [8:16]  累计可信票数
+//	  [16:24] 累计不可信票数
+//	  [24:32] 首次投票时间(毫秒时间戳)
+//	  [32:40] 最近投票时间(毫秒时间戳)
+//	  [40:44] 窗口内记录数
+//	  [44:48] 保留
+//	  [48:56] 累计可信票加权和(float64, v2起)
+//	  [56:64] 累计不可信票加权和(float64, v2起)
+//	窗口记录(v1每条9字节，v2每条19字节，v3每条11字节，按时间顺序追加):
+//	  [0:8]   投票时间(毫秒时间戳)
+//	  [8]     投票值 0/1
+//	  [9:11]  投票权重*1000(v2起)
+//	  [11:19] 投票人openid哈希前8字节(仅v2，读取时忽略)
+//
+// 累计计数永不丢弃，窗口超出容量时只丢弃最旧的记录。
+// 仓库是公开的，文件中不保存投票人信息，投票人与投票的对应关系只在Redis中。
+// 读取时兼容v1(权重视为1)和v2，写入时统一升级为v3。
+const (
+	voteFileMagic      = "ICVT"
+	voteFileVersion    = 3
+	voteHeaderSizeV1   = 48
+	voteEntrySizeV1    = 9
+	voteEntrySizeV2    = 19
+	voteHeaderSize     = 64
+	voteEntrySize      = 11
+	DefaultVoteWindow  = 256
+	VoteFileExt        = ".vt"
+	legacyBitmapExt    = ".bm"
+	legacyBitmapIdxExt = ".bmi"
+)
+
+// VoteEntry 窗口内的单条投票
+type VoteEntry struct {
+	At     time.Time
+	Value  uint8
+	Weight float64 // 投票权重，由投票人信誉决定
+}
+
+// VoteFile 投票文件的内存表示
+type VoteFile struct {
+	Version       uint16
+	Window        uint16
+	TrueCount     uint64
+	FalseCount    uint64
+	WeightedTrue  float64
+	WeightedFalse float64
+	FirstAt       time.Time
+	LastAt        time.Time
+	Entries       []VoteEntry
+}
+
+// VoteStats 投票统计结果
+type VoteStats struct {
+	True          int
+	False         int
+	Total         int
+	Percent       int
+	WeightedTrue  float64
+	WeightedTotal float64
+	RecentTrue    int
+	RecentFalse   int
+	RecentTotal   int
+	RecentPercent int
+	FirstAt       time.Time
+	LastAt        time.Time
+	Recent        []VoteEntry
+}
+
+// VoteStore 负责投票文件的读写与锁定
+type VoteStore struct {
+	gitService *GitService
+	window     int
+	mu         sync.Mutex
+}
+
+// NewVoteStore 创建VoteStore实例，window<=0时使用默认窗口容量
+func NewVoteStore(gitService *GitService, window int) *VoteStore {
+	if window <= 0 || window > 0xFFFF {
+		window = DefaultVoteWindow
+	}
+	return &VoteStore{
+		gitService: gitService,
+		window:     window,
+	}
+}
+
+// Create 创建空的投票文件
+func (s *VoteStore) Create(filePath string) error {
+	vf := &VoteFile{Version: voteFileVersion, Window: uint16(s.window)}
+	return CreateFileWithContent(filePath, EncodeVoteFile(vf), 0644)
+}
+
+// Append 追加一票并更新累计计数
+func (s *VoteStore) Append(filePath string, e VoteEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vf, err := s.read(filePath)
+	if err != nil {
+		return err
+	}
+	vf.add(e)
+	return writeFileAtomic(filePath, EncodeVoteFile(vf), 0644)
+}
+
+// GetStats 统计累计和近期窗口的投票结果
+func (s *VoteStore) GetStats(filePath string) (*VoteStats, error) {
+	vf, err := s.read(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return vf.Stats(), nil
+}
+
+// read 读取投票文件；文件不存在但有旧版bitmap时在内存中转换
+func (s *VoteStore) read(filePath string) (*VoteFile, error) {
+	data, err := os.ReadFile(filePath)
+	if err == nil {
+		return DecodeVoteFile(data)
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取投票文件失败: %v", err)
+	}
+
+	prefix := trimVoteExt(filePath)
+	if FileExists(prefix+legacyBitmapExt) && FileExists(prefix+legacyBitmapIdxExt) {
+		return ConvertLegacyBitmap(prefix+legacyBitmapExt, prefix+legacyBitmapIdxExt, s.window)
+	}
+	return &VoteFile{Version: voteFileVersion, Window: uint16(s.window)}, nil
+}
+
+// Lock 锁定投票文件；尚未迁移的记录锁定将要生成的.vt路径，迁移在持锁期间进行
+func (s *VoteStore) Lock(filePath string) error {
+	// 先尝试解锁，忽略错误，防止残留锁
+	_ = s.gitService.UnlockFile(filePath)
+
+	prefix := trimVoteExt(filePath)
+	if !FileExists(filePath) && !FileExists(prefix+legacyBitmapExt) {
+		return fmt.Errorf("投票文件不存在: %s", filePath)
+	}
+	if err := s.gitService.LockFile(filePath); err != nil {
+		return fmt.Errorf("锁定投票文件失败: %v", err)
+	}
+	return nil
+}
+
+// Unlock 解锁投票文件
+func (s *VoteStore) Unlock(filePath string) error {
+	if err := s.gitService.UnlockFile(filePath); err != nil {
+		return fmt.Errorf("解锁投票文件失败: %v", err)
+	}
+	return nil
+}
+
+// add 追加记录，窗口已满时丢弃最旧的记录
+func (vf *VoteFile) add(e VoteEntry) {
+	if e.Weight <= 0 {
+		e.Weight = 1
+	}
+	// 权重按千分之一精度存储，累计值与窗口记录保持一致
+	e.Weight = math.Round(e.Weight*1000) / 1000
+	if e.Value == 1 {
+		vf.TrueCount++
+		vf.WeightedTrue += e.Weight
+	} else {
+		vf.FalseCount++
+		vf.WeightedFalse += e.Weight
+	}
+	if vf.FirstAt.IsZero() {
+		vf.FirstAt = e.At
+	}
+	vf.LastAt = e.At
+
+	vf.Entries = append(vf.Entries, e)
+	if over := len(vf.Entries) - int(vf.Window); over > 0 {
+		vf.Entries = vf.Entries[over:]
+	}
+}
+
+// Stats 计算投票统计
+func (vf *VoteFile) Stats() *VoteStats {
+	st := &VoteStats{
+		True:          int(vf.TrueCount),
+		False:         int(vf.FalseCount),
+		Total:         int(vf.TrueCount + vf.FalseCount),
+		WeightedTrue:  vf.WeightedTrue,
+		WeightedTotal: vf.WeightedTrue + vf.WeightedFalse,
+		FirstAt:       vf.FirstAt,
+		LastAt:        vf.LastAt,
+		Recent:        vf.Entries,
+	}
+	st.Percent = percentOf(st.True, st.Total)
+
+	for _, e := range vf.Entries {
+		if e.Value == 1 {
+			st.RecentTrue++
+		} else {
+			st.RecentFalse++
+		}
+	}
+	st.RecentTotal = st.RecentTrue + st.RecentFalse
+	st.RecentPercent = percentOf(st.RecentTrue, st.RecentTotal)
+	return st
+}
+
+// EncodeVoteFile 序列化投票文件
+func EncodeVoteFile(vf *VoteFile) []byte {
+	buf := make([]byte, voteHeaderSize+len(vf.Entries)*voteEntrySize)
+	copy(buf[0:4], voteFileMagic)
+	binary.BigEndian.PutUint16(buf[4:6], voteFileVersion)
+	binary.BigEndian.PutUint16(buf[6:8], vf.Window)
+	binary.BigEndian.PutUint64(buf[8:16], vf.TrueCount)
+	binary.BigEndian.PutUint64(buf[16:24], vf.FalseCount)
+	binary.BigEndian.PutUint64(buf[24:32], uint64(unixMilli(vf.FirstAt)))
+	binary.BigEndian.PutUint64(buf[32:40], uint64(unixMilli(vf.LastAt)))
+	binary.BigEndian.PutUint32(buf[40:44], uint32(len(vf.Entries)))
+	binary.BigEndian.PutUint64(buf[48:56], math.Float64bits(vf.WeightedTrue))
+	binary.BigEndian.PutUint64(buf[56:64], math.Float64bits(vf.WeightedFalse))
+
+	off := voteHeaderSize
+	for _, e := range vf.Entries {
+		binary.BigEndian.PutUint64(buf[off:off+8], uint64(unixMilli(e.At)))
+		buf[off+8] = e.Value
+		binary.BigEndian.PutUint16(buf[off+9:off+11], encodeWeight(e.Weight))
+		off += voteEntrySize
+	}
+	return buf
+}
+
+// DecodeVoteFile 解析投票文件，兼容v1、v2和v3
+func DecodeVoteFile(data []byte) (*VoteFile, error) {
+	if len(data) < voteHeaderSizeV1 || !bytes.Equal(data[0:4], []byte(voteFileMagic)) {
+		return nil, errors.New("无效的投票文件头")
+	}
+	vf := &VoteFile{
+		Version:    binary.BigEndian.Uint16(data[4:6]),
+		Window:     binary.BigEndian.Uint16(data[6:8]),
+		TrueCount:  binary.BigEndian.Uint64(data[8:16]),
+		FalseCount: binary.BigEndian.Uint64(data[16:24]),
+		FirstAt:    fromUnixMilli(int64(binary.BigEndian.Uint64(data[24:32]))),
+		LastAt:     fromUnixMilli(int64(binary.BigEndian.Uint64(data[32:40]))),
+	}
+
+	headerSize, entrySize := voteHeaderSize, voteEntrySize
+	switch vf.Version {
+	case 1:
+		headerSize, entrySize = voteHeaderSizeV1, voteEntrySizeV1
+		vf.WeightedTrue = float64(vf.TrueCount)
+		vf.WeightedFalse = float64(vf.FalseCount)
+	case 2, voteFileVersion:
+		if vf.Version == 2 {
+			entrySize = voteEntrySizeV2
+		}
+		if len(data) < voteHeaderSize {
+			return nil, errors.New("投票文件头已截断")
+		}
+		vf.WeightedTrue = math.Float64frombits(binary.BigEndian.Uint64(data[48:56]))
+		vf.WeightedFalse = math.Float64frombits(binary.BigEndian.Uint64(data[56:64]))
+	default:
+		return nil, fmt.Errorf("不支持的投票文件版本: %d", vf.Version)
+	}
+
+	n := int(binary.BigEndian.Uint32(data[40:44]))
+	if len(data) < headerSize+n*entrySize {
+		return nil, fmt.Errorf("投票文件已截断: 期望%d条记录", n)
+	}
+	vf.Entries = make([]VoteEntry, 0, n)
+	off := headerSize
+	for i := 0; i < n; i++ {
+		e := VoteEntry{
+			At:     fromUnixMilli(int64(binary.BigEndian.Uint64(data[off : off+8]))),
+			Value:  data[off+8],
+			Weight: 1,
+		}
+		if vf.Version >= 2 {
+			e.Weight = float64(binary.BigEndian.Uint16(data[off+9:off+11])) / 1000
+		}
+		vf.Entries = append(vf.Entries, e)
+		off += entrySize
+	}
+	vf.Version = voteFileVersion
+	return vf, nil
+}
+
+// encodeWeight 将权重转换为千分之一精度的定点数
+func encodeWeight(w float64) uint16 {
+	v := math.Round(w * 1000)
+	if v > math.MaxUint16 {
+		return math.MaxUint16
+	}
+	if v < 0 {
+		return 0
+	}
+	return uint16(v)
+}
+
+// writeFileAtomic 先写临时文件再重命名，避免中断时留下半截文件
+func writeFileAtomic(filePath string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), ".tmp-"+filepath.Base(filePath))
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("写入临时文件失败: %v", err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("设置文件权限失败: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("关闭临时文件失败: %v", err)
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
+func trimVoteExt(filePath string) string {
+	return filePath[:len(filePath)-len(filepath.Ext(filePath))]
+}
+
+func percentOf(part, total int) int {
+	if total == 0 {
+		return 0
+	}
+	return int(float64(part) / float64(total) * 100)
+}
+
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func fromUnixMilli(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
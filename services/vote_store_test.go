@@ -0,0 +1,192 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestVoteFileRoundTrip(t *testing.T) {
+	start := time.UnixMilli(1700000000000)
+	vf := &VoteFile{Version: voteFileVersion, Window: 3}
+	for i, v := range []uint8{1, 0, 1, 1} {
+		vf.add(VoteEntry{At: start.Add(time.Duration(i) * time.Minute), Value: v, Weight: 1.2345})
+	}
+
+	got, err := DecodeVoteFile(EncodeVoteFile(vf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TrueCount != 3 || got.FalseCount != 1 || got.Window != 3 {
+		t.Fatalf("累计计数不符: %+v", got)
+	}
+	if !got.FirstAt.Equal(start) || !got.LastAt.Equal(start.Add(3*time.Minute)) {
+		t.Errorf("投票时间不符: %v, %v", got.FirstAt, got.LastAt)
+	}
+	// 窗口只保留最近3条，权重按千分之一精度存储
+	if len(got.Entries) != 3 || got.Entries[0].Value != 0 || got.Entries[0].Weight != 1.235 {
+		t.Fatalf("窗口记录不符: %+v", got.Entries)
+	}
+	if got.WeightedTrue != 3*1.235 || got.WeightedFalse != 1.235 {
+		t.Errorf("加权和不符: %v, %v", got.WeightedTrue, got.WeightedFalse)
+	}
+
+	st := got.Stats()
+	if st.Total != 4 || st.Percent != 75 || st.RecentTotal != 3 || st.RecentPercent != 66 {
+		t.Errorf("统计结果不符: %+v", st)
+	}
+}
+
+func TestDecodeVoteFileV1(t *testing.T) {
+	data := make([]byte, voteHeaderSizeV1+2*voteEntrySizeV1)
+	copy(data, voteFileMagic)
+	binary.BigEndian.PutUint16(data[4:6], 1)
+	binary.BigEndian.PutUint16(data[6:8], 256)
+	binary.BigEndian.PutUint64(data[8:16], 5)
+	binary.BigEndian.PutUint64(data[16:24], 2)
+	binary.BigEndian.PutUint32(data[40:44], 2)
+	binary.BigEndian.PutUint64(data[voteHeaderSizeV1:], 1700000000000)
+	data[voteHeaderSizeV1+8] = 1
+	binary.BigEndian.PutUint64(data[voteHeaderSizeV1+voteEntrySizeV1:], 1700000060000)
+
+	vf, err := DecodeVoteFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vf.Version != voteFileVersion || vf.WeightedTrue != 5 || vf.WeightedFalse != 2 {
+		t.Fatalf("v1文件应升级并以票数作为加权和: %+v", vf)
+	}
+	if len(vf.Entries) != 2 || vf.Entries[0].Value != 1 || vf.Entries[1].Weight != 1 {
+		t.Fatalf("v1窗口记录不符: %+v", vf.Entries)
+	}
+
+	if _, err := DecodeVoteFile(data[:voteHeaderSizeV1+voteEntrySizeV1]); err == nil {
+		t.Error("截断的文件应报错")
+	}
+	if _, err := DecodeVoteFile([]byte("not a vote file at all, definitely not 48 bytes..")); err == nil {
+		t.Error("魔数错误应报错")
+	}
+}
+
+// writeLegacy 写入旧版bitmap文件对，bmi[i]==1表示第i位有投票
+func writeLegacy(t *testing.T, prefix string, bm, bmi []byte) {
+	t.Helper()
+	if err := os.WriteFile(prefix+legacyBitmapExt, bm, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(prefix+legacyBitmapIdxExt, bmi, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConvertLegacyBitmap(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "1700000000000-42")
+	writeLegacy(t, prefix, []byte{1, 0, 1, 1}, []byte{1, 1, 0, 1})
+
+	vf, err := ConvertLegacyBitmap(prefix+legacyBitmapExt, prefix+legacyBitmapIdxExt, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vf.TrueCount != 2 || vf.FalseCount != 1 || vf.Window != DefaultVoteWindow {
+		t.Fatalf("转换结果不符: %+v", vf)
+	}
+	// 旧文件没有投票时间，使用文件名中的记录创建时间
+	if !vf.FirstAt.Equal(time.UnixMilli(1700000000000)) {
+		t.Errorf("投票时间应为记录创建时间: %v", vf.FirstAt)
+	}
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "ab", "cd")
+	os.MkdirAll(dir, 0755)
+	writeLegacy(t, filepath.Join(dir, "1700000000000-1"), []byte{1}, []byte{1})
+	writeLegacy(t, filepath.Join(dir, "1700000000000-2"), []byte{0, 1}, []byte{1, 1})
+	store := NewVoteStore(nil, 0)
+
+	changed, err := store.MigrateLegacy(root, true)
+	if err != nil || len(changed) != 6 {
+		t.Fatalf("dry-run应列出6个文件: %v, %v", changed, err)
+	}
+	if FileExists(filepath.Join(dir, "1700000000000-1"+VoteFileExt)) {
+		t.Fatal("dry-run不应写盘")
+	}
+
+	if _, err := store.MigrateLegacy(root, false); err != nil {
+		t.Fatal(err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"))
+	sort.Strings(matches)
+	if len(matches) != 2 || filepath.Ext(matches[0]) != VoteFileExt || filepath.Ext(matches[1]) != VoteFileExt {
+		t.Fatalf("迁移后应只剩.vt文件: %v", matches)
+	}
+	st, err := store.GetStats(matches[1])
+	if err != nil || st.True != 1 || st.False != 1 {
+		t.Errorf("迁移后的统计不符: %+v, %v", st, err)
+	}
+}
+
+func TestMigrateRecordRestore(t *testing.T) {
+	dir := t.TempDir()
+	prefix := "1700000000000-7"
+	writeLegacy(t, filepath.Join(dir, prefix), []byte{1, 0}, []byte{1, 1})
+	s := &VoteService{voteStore: NewVoteStore(nil, 0)}
+
+	m, err := s.migrateRecord(dir, prefix)
+	if err != nil || m == nil {
+		t.Fatalf("应执行迁移: %v", err)
+	}
+	if FileExists(filepath.Join(dir, prefix+legacyBitmapExt)) || !FileExists(filepath.Join(dir, prefix+VoteFileExt)) {
+		t.Fatal("迁移后应删除旧文件并生成.vt")
+	}
+	if again, _ := s.migrateRecord(dir, prefix); again != nil {
+		t.Fatal("已迁移的记录不应重复迁移")
+	}
+
+	// 提交失败时还原
+	if err := m.restore(); err != nil {
+		t.Fatal(err)
+	}
+	if FileExists(filepath.Join(dir, prefix+VoteFileExt)) {
+		t.Error("还原后不应保留.vt")
+	}
+	bm, _ := os.ReadFile(filepath.Join(dir, prefix+legacyBitmapExt))
+	bmi, _ := os.ReadFile(filepath.Join(dir, prefix+legacyBitmapIdxExt))
+	if !bytes.Equal(bm, []byte{1, 0}) || !bytes.Equal(bmi, []byte{1, 1}) {
+		t.Errorf("旧文件内容应还原: %v, %v", bm, bmi)
+	}
+}
+
+func TestDecodeVoteFileV2DropsVoter(t *testing.T) {
+	data := make([]byte, voteHeaderSize+voteEntrySizeV2)
+	copy(data, voteFileMagic)
+	binary.BigEndian.PutUint16(data[4:6], 2)
+	binary.BigEndian.PutUint16(data[6:8], 256)
+	binary.BigEndian.PutUint64(data[8:16], 1)
+	binary.BigEndian.PutUint32(data[40:44], 1)
+	binary.BigEndian.PutUint64(data[voteHeaderSize:], 1700000000000)
+	data[voteHeaderSize+8] = 1
+	binary.BigEndian.PutUint16(data[voteHeaderSize+9:], 1500)
+	voter := []byte{0xde, 0xad, 0xbe, 0xef, 0x01, 0x02, 0x03, 0x04}
+	copy(data[voteHeaderSize+11:], voter)
+
+	vf, err := DecodeVoteFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vf.Entries) != 1 || vf.Entries[0].Weight != 1.5 || vf.Entries[0].Value != 1 {
+		t.Fatalf("v2窗口记录不符: %+v", vf.Entries)
+	}
+	// 重新写入时升级为不含投票人的格式
+	out := EncodeVoteFile(vf)
+	if len(out) != voteHeaderSize+voteEntrySize || bytes.Contains(out, voter) {
+		t.Fatalf("写入的文件不应包含投票人信息: %x", out)
+	}
+	if binary.BigEndian.Uint16(out[4:6]) != voteFileVersion {
+		t.Errorf("应写入当前版本: %d", binary.BigEndian.Uint16(out[4:6]))
+	}
+}
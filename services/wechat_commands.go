@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"meea-icey/models"
+)
+
+// CommandRequest 一条待处理的微信文本指令
+type CommandRequest struct {
+	OpenID  string
+	Content string
+	// Args 为指令正则的捕获分组(已去除首尾空白)
+	Args []string
+}
+
+// CommandFunc 指令处理函数，返回被动回复的文本
+type CommandFunc func(ctx context.Context, req *CommandRequest) (string, error)
+
+// Command 一条微信文本指令
+type Command struct {
+	Name    string
+	Usage   string // 帮助中展示的用法，为空时不展示
+	Pattern *regexp.Regexp
+	Handle  CommandFunc
+}
+
+// CommandRouter 按注册顺序匹配文本消息并分发到对应指令
+type CommandRouter struct {
+	commands []*Command
+}
+
+func NewCommandRouter() *CommandRouter {
+	return &CommandRouter{}
+}
+
+// Register 注册指令，先注册的指令优先匹配
+func (r *CommandRouter) Register(cmd *Command) {
+	r.commands = append(r.commands, cmd)
+}
+
+// Dispatch 分发指令，没有匹配的指令时返回帮助提示
+func (r *CommandRouter) Dispatch(ctx context.Context, openid, content string) (string, error) {
+	content = strings.TrimSpace(content)
+	for _, cmd := range r.commands {
+		m := cmd.Pattern.FindStringSubmatch(content)
+		if m == nil {
+			continue
+		}
+		args := make([]string, 0, len(m)-1)
+		for _, a := range m[1:] {
+			args = append(args, strings.TrimSpace(a))
+		}
+		return cmd.Handle(ctx, &CommandRequest{OpenID: openid, Content: content, Args: args})
+	}
+	return "未识别的指令，发送“帮助”查看可用指令。", nil
+}
+
+// Help 返回所有指令的用法
+func (r *CommandRouter) Help() string {
+	var b strings.Builder
+	b.WriteString("可用指令：")
+	for _, cmd := range r.commands {
+		if cmd.Usage == "" {
+			continue
+		}
+		b.WriteString("\n")
+		b.WriteString(cmd.Usage)
+	}
+	return b.String()
+}
+
+// wechatCommands 默认指令的实现
+type wechatCommands struct {
+	config        *models.Config
+	redisClient   *redis.Client
+	verifyService *VerifyService
+	codes         CodeIssuer
+	subscriptions *SubscriptionService
+	reputation    *ReputationService
+	syncer        *RepoSyncer
+	index         *SubjectIndex
+	scorer        *Scorer
+}
+
+// NewWechatCommandRouter 创建注册了默认指令的CommandRouter
+func NewWechatCommandRouter(config *models.Config, redisClient *redis.Client, verifyService *VerifyService, subscriptions *SubscriptionService, reputation *ReputationService, syncer *RepoSyncer, index *SubjectIndex, scorer *Scorer) *CommandRouter {
+	c := &wechatCommands{
+		config:        config,
+		redisClient:   redisClient,
+		verifyService: verifyService,
+		codes:         NewWechatIssuer(verifyService),
+		subscriptions: subscriptions,
+		reputation:    reputation,
+		syncer:        syncer,
+		index:         index,
+		scorer:        scorer,
+	}
+	r := NewCommandRouter()
+	r.Register(&Command{Name: "help", Usage: "帮助 —— 查看可用指令", Pattern: regexp.MustCompile(`^(?i)(?:帮助|help|\?|？)$`),
+		Handle: func(ctx context.Context, req *CommandRequest) (string, error) { return r.Help(), nil }})
+	// 必须在验证码指令之前注册，否则会被当作主题“取消”的验证码请求
+	r.Register(&Command{Name: "revoke", Usage: "取消验证码 —— 作废您所有未过期的验证码", Pattern: regexp.MustCompile(`^(?:取消|作废)验证码$`), Handle: c.revoke})
+	r.Register(&Command{Name: "code", Usage: "<主题>验证码 —— 获取主题验证码", Pattern: regexp.MustCompile(`^(.+?)验证码$`), Handle: c.code})
+	r.Register(&Command{Name: "summary", Usage: "<主题>查询 —— 查看主题汇总", Pattern: regexp.MustCompile(`^(.+?)查询$`), Handle: c.summary})
+	r.Register(&Command{Name: "subscriptions", Usage: "我的订阅 —— 查看已订阅的主题", Pattern: regexp.MustCompile(`^我的订阅$`), Handle: c.mySubscriptions})
+	r.Register(&Command{Name: "subscribe", Usage: "<主题>订阅 —— 有新记录时通知您", Pattern: regexp.MustCompile(`^(.+?)订阅$`), Handle: c.subscribe})
+	r.Register(&Command{Name: "unsubscribe", Usage: "<主题>退订 —— 取消订阅", Pattern: regexp.MustCompile(`^(.+?)退订$`), Handle: c.unsubscribe})
+	r.Register(&Command{Name: "submissions", Usage: "我的提交 —— 查看最近提交的记录", Pattern: regexp.MustCompile(`^我的提交$`), Handle: c.submissions})
+	return r
+}
+
+func (c *wechatCommands) code(ctx context.Context, req *CommandRequest) (string, error) {
+	issued, err := c.codes.Issue(ctx, &CodeRequest{Subject: req.Args[0], Recipient: req.OpenID})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("您的验证码是: %s", issued.Code), nil
+}
+
+func (c *wechatCommands) revoke(ctx context.Context, req *CommandRequest) (string, error) {
+	n, err := c.verifyService.RevokeCodes(ctx, HashOpenID(req.OpenID))
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return "您当前没有未过期的验证码。", nil
+	}
+	return fmt.Sprintf("已作废 %d 个验证码。", n), nil
+}
+
+func (c *wechatCommands) summary(ctx context.Context, req *CommandRequest) (string, error) {
+	subject := req.Args[0]
+	if err := c.syncer.EnsureFresh(); err != nil {
+		return "", fmt.Errorf("同步仓库失败: %v", err)
+	}
+	dirPath, _ := BuildSubjectPath(c.config.Repository.ClonePath, SubjectHash(subject))
+	if !FileExists(dirPath) {
+		return fmt.Sprintf("「%s」暂无记录。", subject), nil
+	}
+	sum, err := c.index.LoadSummary(dirPath)
+	if err != nil {
+		return "", fmt.Errorf("读取汇总索引失败: %v", err)
+	}
+	if sum.Records == 0 {
+		return fmt.Sprintf("「%s」暂无记录。", subject), nil
+	}
+	view, err := sum.View(c.scorer, "", time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "「%s」共 %d 条记录，累计 %d 票", subject, view.Records, view.TotalVotes)
+	if view.TotalVotes > 0 {
+		fmt.Fprintf(&b, "，可信度 %.1f%%", view.Credibility)
+	}
+	b.WriteString("。")
+	if view.LastAt > 0 {
+		fmt.Fprintf(&b, "\n最近更新: %s", time.UnixMilli(view.LastAt).Format("2006-01-02 15:04"))
+	}
+	if len(view.TopCategories) > 0 {
+		names := make([]string, 0, len(view.TopCategories))
+		for _, cat := range view.TopCategories {
+			names = append(names, fmt.Sprintf("%s(%d)", cat.Name, cat.Count))
+		}
+		b.WriteString("\n分类: " + strings.Join(names, "、"))
+	}
+	fmt.Fprintf(&b, "\n发送“%s验证码”获取验证码后可查看详情。", subject)
+	return b.String(), nil
+}
+
+func (c *wechatCommands) subscribe(ctx context.Context, req *CommandRequest) (string, error) {
+	if _, err := c.subscriptions.Subscribe(ctx, req.OpenID, req.Args[0]); err != nil {
+		return "订阅失败: " + err.Error(), nil
+	}
+	return fmt.Sprintf("已订阅「%s」，有新记录或可信度大幅变化时会通知您。", req.Args[0]), nil
+}
+
+func (c *wechatCommands) unsubscribe(ctx context.Context, req *CommandRequest) (string, error) {
+	if err := c.subscriptions.Unsubscribe(ctx, req.OpenID, SubjectHash(req.Args[0])); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("已取消订阅「%s」。", req.Args[0]), nil
+}
+
+func (c *wechatCommands) mySubscriptions(ctx context.Context, req *CommandRequest) (string, error) {
+	hashes, err := c.subscriptions.Subscriptions(ctx, req.OpenID)
+	if err != nil {
+		return "", err
+	}
+	if len(hashes) == 0 {
+		return "您还没有订阅任何主题，发送“<主题>订阅”即可订阅。", nil
+	}
+	var b strings.Builder
+	b.WriteString("您订阅的主题：")
+	for _, h := range hashes {
+		b.WriteString("\n" + c.subjectName(ctx, h))
+	}
+	return b.String(), nil
+}
+
+func (c *wechatCommands) submissions(ctx context.Context, req *CommandRequest) (string, error) {
+	items, err := c.reputation.Submissions(ctx, HashOpenID(req.OpenID), 10)
+	if err != nil {
+		return "", err
+	}
+	if len(items) == 0 {
+		return "您还没有提交过记录。", nil
+	}
+
+	var b strings.Builder
+	b.WriteString("您最近提交的记录：")
+	for _, item := range items {
+		subjectHash, prefix, ok := strings.Cut(item, "/")
+		if !ok {
+			continue
+		}
+		status := "已删除"
+		dirPath, _ := BuildSubjectPath(c.config.Repository.ClonePath, subjectHash)
+		if rec, err := c.index.Lookup(dirPath, RecordID(prefix)); err == nil && rec != nil {
+			stats := rec.Stats()
+			status = fmt.Sprintf("%d 票，可信 %d%%", stats.Total, stats.Percent)
+		}
+		at := "-"
+		if ts, _, ok := strings.Cut(prefix, "-"); ok {
+			var ms int64
+			fmt.Sscan(ts, &ms)
+			at = time.UnixMilli(ms).Format("01-02 15:04")
+		}
+		fmt.Fprintf(&b, "\n%s %s：%s", at, c.subjectName(ctx, subjectHash), status)
+	}
+	return b.String(), nil
+}
+
+// subjectName 返回主题原文，未记录时返回哈希前缀
+func (c *wechatCommands) subjectName(ctx context.Context, subjectHash string) string {
+	name, err := c.redisClient.Get(ctx, SubjectNameKey(subjectHash)).Result()
+	if err != nil || name == "" {
+		return subjectHash[:Min(len(subjectHash), 8)]
+	}
+	return "「" + name + "」"
+}
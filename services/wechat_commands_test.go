@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"meea-icey/models"
+)
+
+// stubRouter 使用默认指令的匹配规则，处理函数只返回指令名和参数
+func stubRouter() *CommandRouter {
+	r := NewWechatCommandRouter(&models.Config{}, nil, nil, nil, nil, nil, nil, nil)
+	for _, cmd := range r.commands {
+		if cmd.Name == "help" {
+			continue
+		}
+		name := cmd.Name
+		cmd.Handle = func(ctx context.Context, req *CommandRequest) (string, error) {
+			return name + ":" + strings.Join(req.Args, ","), nil
+		}
+	}
+	return r
+}
+
+func TestCommandParsing(t *testing.T) {
+	r := stubRouter()
+	for content, want := range map[string]string{
+		"张三验证码":      "code:张三",
+		"  张三 验证码  ": "code:张三",
+		"取消验证码":      "revoke:",
+		"作废验证码":      "revoke:",
+		"张三查询":       "summary:张三",
+		"张三订阅":       "subscribe:张三",
+		"张三退订":       "unsubscribe:张三",
+		"我的订阅":       "subscriptions:",
+		"我的提交":       "submissions:",
+		"验证码":        "未识别的指令，发送“帮助”查看可用指令。",
+		"你好":         "未识别的指令，发送“帮助”查看可用指令。",
+	} {
+		got, err := r.Dispatch(context.Background(), "openid", content)
+		if err != nil || got != want {
+			t.Errorf("%q 分发为%q(%v), 预期%q", content, got, err, want)
+		}
+	}
+
+	for _, content := range []string{"帮助", "help", "HELP", "?", "？"} {
+		got, _ := r.Dispatch(context.Background(), "openid", content)
+		if !strings.HasPrefix(got, "可用指令：") || !strings.Contains(got, "<主题>验证码") {
+			t.Errorf("%q 应返回帮助: %q", content, got)
+		}
+	}
+}
@@ -3,31 +3,37 @@ package services
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	neturl "net/url"
 	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"meea-icey/internal/metrics"
 	"meea-icey/models"
 )
 
 // WechatService 微信服务
 type WechatService struct {
-	config     *models.Config
+	config      *models.Config
 	redisClient *redis.Client
-	ctx        context.Context
+	ctx         context.Context
+	tokens      *TokenManager
+	client      *http.Client
+	baseURL     string
 }
 
 // NewWechatService 创建微信服务实例
 func NewWechatService(config *models.Config, redisClient *redis.Client, ctx context.Context) *WechatService {
 	return &WechatService{
-		config:     config,
+		config:      config,
 		redisClient: redisClient,
-		ctx:        ctx,
+		ctx:         ctx,
+		tokens:      NewTokenManager(config, redisClient),
+		client:      &http.Client{Timeout: 10 * time.Second},
+		baseURL:     WechatAPIBaseURL(config),
 	}
 }
 
@@ -39,62 +45,22 @@ type AccessToken struct {
 	ErrMsg      string `json:"errmsg"`
 }
 
+// Tokens 返回access_token管理器
+func (s *WechatService) Tokens() *TokenManager {
+	return s.tokens
+}
+
 // GetAccessToken 获取微信access_token
 func (s *WechatService) GetAccessToken() (string, error) {
-	// 尝试从Redis获取缓存的access_token
-	accessToken, err := s.redisClient.Get(s.ctx, "wechat_access_token").Result()
-	if err == nil {
-		if accessToken != "" {
-			log.Printf("从Redis获取access_token成功: %s", accessToken)
-			return accessToken, nil
-		}
-		log.Printf("Redis中的access_token为空字符串")
-	} else if err != redis.Nil {
-		log.Printf("从Redis获取access_token失败: %v", err)
-	}
-
-	// Redis中没有缓存或已过期，调用微信接口获取
-	url := fmt.Sprintf("https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=%s&secret=%s", s.config.Wechat.AppID, s.config.Wechat.AppSecret)
-	log.Printf("请求access_token URL: %s", url)
-
-	// 创建HTTP客户端，不使用代理
-	client := &http.Client{}
-	resp, err := client.Get(url)
-	if err != nil {
-		return "", fmt.Errorf("获取access_token失败: %v", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("读取access_token响应失败: %v", err)
-	}
-	log.Printf("微信access_token响应: %s", string(body))
-
-	var result AccessToken
-	err = json.Unmarshal(body, &result)
-	if err != nil {
-		return "", fmt.Errorf("解析access_token响应失败: %v, 响应内容: %s", err, string(body))
-	}
-
-	if result.ErrCode != 0 {
-		return "", fmt.Errorf("微信接口返回错误: errcode=%d, errmsg=%s", result.ErrCode, result.ErrMsg)
-	}
-
-	if result.AccessToken == "" {
-		return "", errors.New("微信接口返回空的access_token")
-	}
+	return s.tokens.Token(s.ctx)
+}
 
-	// 将access_token存入Redis，并设置过期时间
-	expireDuration := time.Duration(result.ExpiresIn-300) * time.Second // 提前300秒过期
-	err = s.redisClient.Set(s.ctx, "wechat_access_token", result.AccessToken, expireDuration).Err()
-	if err != nil {
-		log.Printf("缓存access_token到Redis失败: %v", err)
-		// 不中断程序，继续返回access_token
+// CreateMenu 创建自定义菜单，menu为微信菜单定义JSON
+func (s *WechatService) CreateMenu(menu []byte) error {
+	if err := s.post("/cgi-bin/menu/create", menu); err != nil {
+		return fmt.Errorf("创建菜单失败: %v", err)
 	}
-
-	log.Printf("获取access_token成功: %s", result.AccessToken)
-	return result.AccessToken, nil
+	return nil
 }
 
 // CustomerMessage 微信客服消息结构体
@@ -108,13 +74,6 @@ type CustomerMessage struct {
 
 // SendMessage 发送微信客服消息
 func (s *WechatService) SendMessage(openid, content string) error {
-	accessToken, err := s.GetAccessToken()
-	if err != nil {
-		return err
-	}
-
-	url := fmt.Sprintf("https://api.weixin.qq.com/cgi-bin/message/custom/send?access_token=%s", accessToken)
-
 	msg := CustomerMessage{
 		ToUser:  openid,
 		MsgType: "text",
@@ -125,28 +84,71 @@ func (s *WechatService) SendMessage(openid, content string) error {
 	if err != nil {
 		return fmt.Errorf("构造消息JSON失败: %v", err)
 	}
+	if err := s.post("/cgi-bin/message/custom/send", jsonData); err != nil {
+		return fmt.Errorf("发送消息失败: %v", err)
+	}
+	return nil
+}
+
+// wechatError 微信接口的通用错误返回
+type wechatError struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+func (e *wechatError) Error() string {
+	return fmt.Sprintf("微信接口返回错误: errcode=%d, errmsg=%s", e.ErrCode, e.ErrMsg)
+}
 
-	resp, err := http.Post(url, "application/json", strings.NewReader(string(jsonData)))
+// post 调用需要access_token的微信接口，token失效(40001/42001)时强制刷新后重试一次
+func (s *WechatService) post(path string, payload []byte) error {
+	token, err := s.tokens.Token(s.ctx)
 	if err != nil {
-		return fmt.Errorf("发送消息失败: %v", err)
+		return err
+	}
+	err = s.postWithToken(path, token, payload)
+	if werr, ok := err.(*wechatError); ok && IsTokenError(werr.ErrCode) {
+		if token, err = s.tokens.ForceRefresh(s.ctx, token); err != nil {
+			return err
+		}
+		err = s.postWithToken(path, token, payload)
+	}
+	return err
+}
+
+// postWithToken 调用一次微信接口并按路径记录调用结果
+func (s *WechatService) postWithToken(path, token string, payload []byte) error {
+	err := s.doPost(path, token, payload)
+	errcode := 0
+	if werr, ok := err.(*wechatError); ok {
+		errcode = werr.ErrCode
+	}
+	metrics.WechatAPI(path, errcode, err)
+	return err
+}
+
+func (s *WechatService) doPost(path, token string, payload []byte) error {
+	url := fmt.Sprintf("%s%s?access_token=%s", s.baseURL, path, token)
+	resp, err := s.client.Post(url, "application/json", strings.NewReader(string(payload)))
+	if err != nil {
+		// 错误信息中的URL带有access_token，只保留底层错误
+		if uerr, ok := err.(*neturl.Error); ok {
+			err = uerr.Err
+		}
+		return fmt.Errorf("请求微信接口%s失败: %v", path, err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("读取消息发送响应失败: %v", err)
+		return fmt.Errorf("读取响应失败: %v", err)
 	}
-
-	// 检查微信接口返回结果
-	var result map[string]interface{}
+	var result wechatError
 	if err := json.Unmarshal(body, &result); err != nil {
-		return fmt.Errorf("解析消息发送响应失败: %v, 响应内容: %s", err, string(body))
+		return fmt.Errorf("解析响应失败: %v, 响应内容: %s", err, string(body))
 	}
-
-	if result["errcode"].(float64) != 0 {
-		return fmt.Errorf("微信接口返回错误: 错误码=%v, 错误信息=%v, 响应内容=%s",
-			result["errcode"], result["errmsg"], string(body))
+	if result.ErrCode != 0 {
+		return &result
 	}
-
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"meea-icey/internal/logging"
+	"meea-icey/internal/metrics"
+	"meea-icey/models"
+)
+
+var tokenLog = logging.Component("wechat_token")
+
+const (
+	accessTokenKey     = "wechat_access_token"
+	accessTokenLockKey = "wechat_access_token:lock"
+	// 获取access_token的最长耗时，同时作为分布式锁的过期时间
+	accessTokenLockTTL = 10 * time.Second
+	// 默认在过期前多久主动刷新
+	defaultTokenRefreshBefore = 10 * time.Minute
+	// DefaultWechatAPIBaseURL 微信公众平台接口地址
+	DefaultWechatAPIBaseURL = "https://api.weixin.qq.com"
+)
+
+// releaseTokenLock 只释放自己持有的刷新锁；获取耗时超过锁的过期时间时，
+// 锁可能已被其他实例取得，不能直接删除
+var releaseTokenLock = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// 微信接口中表示access_token失效的错误码
+const (
+	errCodeInvalidToken = 40001
+	errCodeInvalidFmt   = 40014
+	errCodeTokenExpired = 42001
+)
+
+// IsTokenError 判断微信接口错误码是否表示access_token失效
+func IsTokenError(errcode int) bool {
+	return errcode == errCodeInvalidToken || errcode == errCodeInvalidFmt || errcode == errCodeTokenExpired
+}
+
+// TokenManager 管理微信access_token
+// 多实例共享Redis中的缓存，刷新时通过Redis锁保证同一时刻只有一个请求调用微信接口，
+// 避免新获取的token使其他实例刚拿到的token失效
+type TokenManager struct {
+	config        *models.Config
+	redisClient   *redis.Client
+	client        *http.Client
+	baseURL       string
+	refreshBefore time.Duration
+
+	// 进程内的刷新互斥，减少对Redis锁的争抢
+	mu sync.Mutex
+}
+
+// NewTokenManager 创建TokenManager实例
+func NewTokenManager(config *models.Config, redisClient *redis.Client) *TokenManager {
+	m := &TokenManager{
+		config:        config,
+		redisClient:   redisClient,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		baseURL:       WechatAPIBaseURL(config),
+		refreshBefore: config.Wechat.TokenRefreshBefore,
+	}
+	if m.refreshBefore <= 0 {
+		m.refreshBefore = defaultTokenRefreshBefore
+	}
+	return m
+}
+
+// WechatAPIBaseURL 返回配置的微信接口地址，未配置时使用官方地址
+func WechatAPIBaseURL(config *models.Config) string {
+	if config.Wechat.APIBaseURL == "" {
+		return DefaultWechatAPIBaseURL
+	}
+	return strings.TrimRight(config.Wechat.APIBaseURL, "/")
+}
+
+// Token 返回可用的access_token，缓存不存在时刷新
+func (m *TokenManager) Token(ctx context.Context) (string, error) {
+	token, err := m.cached(ctx)
+	if err != nil {
+		return "", err
+	}
+	if token != "" {
+		return token, nil
+	}
+	return m.refresh(ctx, "")
+}
+
+// ForceRefresh 微信接口返回token失效时调用；stale为失效的token，
+// 若缓存中已是其他请求刷新后的新token则直接返回，不再重复刷新
+func (m *TokenManager) ForceRefresh(ctx context.Context, stale string) (string, error) {
+	return m.refresh(ctx, stale)
+}
+
+// Run 定期检查access_token剩余有效期，在过期前主动刷新，ctx取消时退出
+func (m *TokenManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ttl, err := m.redisClient.TTL(ctx, accessTokenKey).Result()
+		if err != nil {
+			tokenLog.Warn("读取access_token有效期失败", "error", err)
+			continue
+		}
+		// key不存在时TTL为负数，由下一次请求按需获取
+		if ttl < 0 || ttl > m.refreshBefore {
+			continue
+		}
+		stale, _ := m.cached(ctx)
+		if _, err := m.refresh(ctx, stale); err != nil {
+			tokenLog.Warn("主动刷新access_token失败", "error", err)
+		}
+	}
+}
+
+// TTL 返回缓存中access_token的剩余有效期，缓存不存在时返回负数
+func (m *TokenManager) TTL(ctx context.Context) (time.Duration, error) {
+	ttl, err := m.redisClient.TTL(ctx, accessTokenKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("读取access_token有效期失败: %v", err)
+	}
+	return ttl, nil
+}
+
+// RefreshBefore 返回过期前主动刷新的提前量
+func (m *TokenManager) RefreshBefore() time.Duration {
+	return m.refreshBefore
+}
+
+func (m *TokenManager) cached(ctx context.Context) (string, error) {
+	token, err := m.redisClient.Get(ctx, accessTokenKey).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("读取access_token缓存失败: %v", err)
+	}
+	return token, nil
+}
+
+// refresh 获取新的access_token；stale非空时，只有缓存仍为stale(或为空)才真正刷新
+func (m *TokenManager) refresh(ctx context.Context, stale string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deadline := time.Now().Add(accessTokenLockTTL)
+	for {
+		// 等待期间可能已被其他请求或实例刷新
+		token, err := m.cached(ctx)
+		if err != nil {
+			return "", err
+		}
+		if token != "" && token != stale {
+			return token, nil
+		}
+
+		owner := GenerateRandomToken(16)
+		locked, err := m.redisClient.SetNX(ctx, accessTokenLockKey, owner, accessTokenLockTTL).Result()
+		if err != nil {
+			return "", fmt.Errorf("获取access_token刷新锁失败: %v", err)
+		}
+		if locked {
+			defer func() {
+				if err := releaseTokenLock.Run(context.Background(), m.redisClient, []string{accessTokenLockKey}, owner).Err(); err != nil {
+					tokenLog.Warn("释放access_token刷新锁失败", "error", err)
+				}
+			}()
+			return m.fetch(ctx)
+		}
+
+		if time.Now().After(deadline) {
+			return "", errors.New("等待其他实例刷新access_token超时")
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// fetch 调用微信接口获取access_token并写入缓存，调用方需持有刷新锁
+func (m *TokenManager) fetch(ctx context.Context) (token string, err error) {
+	var result AccessToken
+	defer func() { metrics.WechatAPI("/cgi-bin/token", result.ErrCode, err) }()
+
+	params := url.Values{}
+	params.Set("grant_type", "client_credential")
+	params.Set("appid", m.config.Wechat.AppID)
+	params.Set("secret", m.config.Wechat.AppSecret)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.baseURL+"/cgi-bin/token?"+params.Encode(), nil)
+	if err != nil {
+		return "", fmt.Errorf("创建access_token请求失败: %v", err)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("获取access_token失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("读取access_token响应失败: %v", err)
+	}
+
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("解析access_token响应失败: %v", err)
+	}
+	if result.ErrCode != 0 {
+		return "", fmt.Errorf("微信接口返回错误: errcode=%d, errmsg=%s", result.ErrCode, result.ErrMsg)
+	}
+	if result.AccessToken == "" {
+		return "", errors.New("微信接口返回空的access_token")
+	}
+
+	// 提前60秒过期，主动刷新在此之前进行
+	expire := time.Duration(result.ExpiresIn)*time.Second - time.Minute
+	if expire <= 0 {
+		expire = time.Duration(result.ExpiresIn) * time.Second
+	}
+	if err := m.redisClient.Set(ctx, accessTokenKey, result.AccessToken, expire).Err(); err != nil {
+		// 不中断程序，继续返回access_token
+		tokenLog.Warn("缓存access_token到Redis失败", "error", err)
+	}
+	tokenLog.Info("已刷新access_token", "expires_in", result.ExpiresIn)
+	return result.AccessToken, nil
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"meea-icey/models"
+)
+
+func TestTokenRefreshKeepsLockTakenByOtherOwner(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	// 获取耗时超过锁的过期时间，期间锁被其他实例取得
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mr.FastForward(accessTokenLockTTL + time.Second)
+		if mr.Exists(accessTokenLockKey) {
+			t.Error("锁应已过期")
+		}
+		mr.Set(accessTokenLockKey, "other-instance")
+		w.Write([]byte(`{"access_token":"token-1","expires_in":7200}`))
+	}))
+	defer server.Close()
+
+	config := &models.Config{}
+	config.Wechat.APIBaseURL = server.URL
+	m := NewTokenManager(config, client)
+	token, err := m.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "token-1" {
+		t.Errorf("token = %q", token)
+	}
+	if owner, err := mr.Get(accessTokenLockKey); err != nil || owner != "other-instance" {
+		t.Errorf("不应删除其他实例持有的刷新锁: %q, %v", owner, err)
+	}
+}
+
+func TestTokenRefreshReleasesOwnLock(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token":"token-1","expires_in":7200}`))
+	}))
+	defer server.Close()
+
+	config := &models.Config{}
+	config.Wechat.APIBaseURL = server.URL
+	if _, err := NewTokenManager(config, client).Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(accessTokenLockKey) {
+		t.Error("刷新完成后应释放自己持有的锁")
+	}
+}
@@ -1,21 +1,27 @@
 package tools
 
 import (
+	"bytes"
 	"encoding/binary"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
 	"crypto/sha1"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"sort"
 	"strings"
 )
 
+// 微信加解密使用的PKCS7块大小
+const wechatBlockSize = 32
+
 // EncryptedMessage 微信加密消息结构体
 type EncryptedMessage struct {
 	XMLName     xml.Name `xml:"xml"`
@@ -32,6 +38,9 @@ type DecryptedMessage struct {
 	MsgType      string   `xml:"MsgType"`
 	Content      string   `xml:"Content"`
 	MsgID        string   `xml:"MsgId"`
+	// 事件消息(MsgType为event)
+	Event    string `xml:"Event"`
+	EventKey string `xml:"EventKey"`
 }
 
 // PKCS7Unpad 移除PKCS7填充
@@ -63,16 +72,40 @@ func min(a, b int) int {
 
 // DecryptWechatMessage 解密微信消息
 func DecryptWechatMessage(encryptedData string, encodingAESKey string, expectedAppID string) (*DecryptedMessage, error) {
-	// 解码EncodingAESKey
+	msgContent, err := DecryptWechatPayload(encryptedData, encodingAESKey, expectedAppID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 解析XML消息
+	var msg DecryptedMessage
+	err = xml.Unmarshal(msgContent, &msg)
+	if err != nil {
+		// 错误会被记录到日志，不包含解密后的消息原文
+		return nil, fmt.Errorf("解析XML消息失败: %v, 消息长度: %d", err, len(msgContent))
+	}
+
+	return &msg, nil
+}
+
+// decodeAESKey 解码43位EncodingAESKey为32字节AES密钥
+func decodeAESKey(encodingAESKey string) ([]byte, error) {
 	aesKey, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
 	if err != nil {
 		return nil, fmt.Errorf("解码EncodingAESKey失败: %v", err)
 	}
-
-	// 验证AES密钥长度
 	if len(aesKey) != 32 {
 		return nil, fmt.Errorf("无效的EncodingAESKey长度: %d, 预期32字节", len(aesKey))
 	}
+	return aesKey, nil
+}
+
+// DecryptWechatPayload 解密微信加密数据并校验AppID，返回消息原文
+func DecryptWechatPayload(encryptedData string, encodingAESKey string, expectedAppID string) ([]byte, error) {
+	aesKey, err := decodeAESKey(encodingAESKey)
+	if err != nil {
+		return nil, err
+	}
 
 	// 创建AES解密器
 	block, err := aes.NewCipher(aesKey)
@@ -110,28 +143,20 @@ func DecryptWechatMessage(encryptedData string, encodingAESKey string, expectedA
 	}
 
 	// 解析明文结构 (16字节随机数 + 4字节消息长度 + 消息内容 + appid)
-	log.Printf("解密后明文长度: %d, 完整明文: %x", len(plaintext), plaintext)
 	if len(plaintext) < 20 {
 		return nil, errors.New("解密后数据太短")
 	}
 
-	// 提取16字节随机数
-	random := plaintext[:16]
-	log.Printf("随机数: %x", random)
-
 	// 从16字节随机数后提取4字节消息长度（网络字节序）
 	lengthBytes := plaintext[16:20]
-	log.Printf("消息长度字段原始字节: %x", lengthBytes)
 
 	// 微信规范：消息长度为4字节网络字节序（大端序）整数
 	// 验证长度字段是否为有效的二进制整数
 	if lengthBytes[0] == 0x3c { // '<'字符，表明可能解析位置错误
 		return nil, fmt.Errorf("消息长度字段位置错误，可能是XML内容: %x", lengthBytes)
 	}
-	log.Printf("消息长度字段原始字节: %x", lengthBytes)
 	length := binary.BigEndian.Uint32(lengthBytes)
 	msgLen := int(length)
-	log.Printf("解析出的消息长度: %d", msgLen)
 
 	// 验证消息长度是否有效
 	if msgLen <= 0 || msgLen > len(plaintext)-20 {
@@ -145,34 +170,138 @@ func DecryptWechatMessage(encryptedData string, encodingAESKey string, expectedA
 	}
 	msgContent := plaintext[20:contentEnd]
 	appID := string(plaintext[contentEnd:])
-	log.Printf("解密得到AppID: %s", appID)
+	slog.Debug("解密微信消息", "plaintext_len", len(plaintext), "msg_len", msgLen, "appid", appID)
 
 	// 验证AppID（需从配置传入正确AppID）
 	if appID != expectedAppID {
 		return nil, fmt.Errorf("AppID验证失败: 预期%s, 实际%s", expectedAppID, appID)
 	}
 
-	// 解析XML消息
-	var msg DecryptedMessage
-	err = xml.Unmarshal(msgContent, &msg)
+	return msgContent, nil
+}
+
+// PKCS7Pad 按微信规范以32字节为块大小进行PKCS7填充
+func PKCS7Pad(data []byte, blockSize int) []byte {
+	padding := blockSize - len(data)%blockSize
+	return append(data, bytes.Repeat([]byte{byte(padding)}, padding)...)
+}
+
+// EncryptWechatMessage 加密回复消息
+// 明文结构: 16字节随机数 + 4字节消息长度(网络字节序) + 消息内容 + AppID，
+// PKCS7填充后使用AES-256-CBC加密(IV为密钥前16字节)，返回Base64编码的密文
+func EncryptWechatMessage(msg []byte, encodingAESKey string, appID string) (string, error) {
+	random := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, random); err != nil {
+		return "", fmt.Errorf("生成随机数失败: %v", err)
+	}
+	return encryptWithRandom(random, msg, encodingAESKey, appID)
+}
+
+func encryptWithRandom(random, msg []byte, encodingAESKey string, appID string) (string, error) {
+	aesKey, err := decodeAESKey(encodingAESKey)
 	if err != nil {
-		return nil, fmt.Errorf("解析XML消息失败: %v, 消息内容: %s", err, string(msgContent))
+		return "", err
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return "", fmt.Errorf("创建AES加密器失败: %v", err)
 	}
 
-	return &msg, nil
+	plaintext := make([]byte, 0, 20+len(msg)+len(appID)+wechatBlockSize)
+	plaintext = append(plaintext, random...)
+	plaintext = binary.BigEndian.AppendUint32(plaintext, uint32(len(msg)))
+	plaintext = append(plaintext, msg...)
+	plaintext = append(plaintext, appID...)
+	plaintext = PKCS7Pad(plaintext, wechatBlockSize)
+
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, aesKey[:16]).CryptBlocks(ciphertext, plaintext)
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
-func VerifyWechatSignature(token, signature, timestamp, nonce string) bool {
-	if token == "" || signature == "" || timestamp == "" || nonce == "" {
+// GenerateMsgSignature 计算安全模式的消息签名: SHA1(字典序排序后拼接的token、timestamp、nonce、密文)
+func GenerateMsgSignature(token, timestamp, nonce, encrypt string) string {
+	strs := []string{token, timestamp, nonce, encrypt}
+	sort.Strings(strs)
+	sha1Hash := sha1.New()
+	io.WriteString(sha1Hash, strings.Join(strs, ""))
+	return hex.EncodeToString(sha1Hash.Sum(nil))
+}
+
+// EncryptedReply 安全模式下的被动回复结构体
+type EncryptedReply struct {
+	XMLName      xml.Name `xml:"xml"`
+	Encrypt      cdata    `xml:"Encrypt"`
+	MsgSignature cdata    `xml:"MsgSignature"`
+	TimeStamp    string   `xml:"TimeStamp"`
+	Nonce        cdata    `xml:"Nonce"`
+}
+
+type cdata struct {
+	Value string `xml:",cdata"`
+}
+
+// TextReply 文本消息的被动回复结构体
+type TextReply struct {
+	XMLName      xml.Name `xml:"xml"`
+	ToUserName   cdata    `xml:"ToUserName"`
+	FromUserName cdata    `xml:"FromUserName"`
+	CreateTime   int64    `xml:"CreateTime"`
+	MsgType      cdata    `xml:"MsgType"`
+	Content      cdata    `xml:"Content"`
+}
+
+// BuildTextReply 生成文本消息的被动回复XML，内容中的"]]>"会被拆分到相邻的CDATA段
+func BuildTextReply(toUser, fromUser string, createTime int64, content string) ([]byte, error) {
+	return xml.Marshal(TextReply{
+		ToUserName:   cdata{toUser},
+		FromUserName: cdata{fromUser},
+		CreateTime:   createTime,
+		MsgType:      cdata{"text"},
+		Content:      cdata{content},
+	})
+}
+
+// BuildEncryptedReply 加密并签名被动回复XML，生成安全模式的回复包
+func BuildEncryptedReply(replyXML []byte, token, encodingAESKey, appID, timestamp, nonce string) ([]byte, error) {
+	encrypt, err := EncryptWechatMessage(replyXML, encodingAESKey, appID)
+	if err != nil {
+		return nil, err
+	}
+	reply := EncryptedReply{
+		Encrypt:      cdata{encrypt},
+		MsgSignature: cdata{GenerateMsgSignature(token, timestamp, nonce, encrypt)},
+		TimeStamp:    timestamp,
+		Nonce:        cdata{nonce},
+	}
+	return xml.Marshal(reply)
+}
+
+// VerifyMsgSignature 校验安全模式推送的msg_signature
+func VerifyMsgSignature(token, timestamp, nonce, encrypt, msgSignature string) bool {
+	if token == "" || msgSignature == "" || timestamp == "" || nonce == "" || encrypt == "" {
 		return false
 	}
+	expected := GenerateMsgSignature(token, timestamp, nonce, encrypt)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(msgSignature)) == 1
+}
+
+// GenerateSignature 计算URL中的signature: SHA1(字典序排序后拼接的token、timestamp、nonce)
+func GenerateSignature(token, timestamp, nonce string) string {
 	// 将token、timestamp、nonce按字典序排序
 	strs := []string{token, timestamp, nonce}
 	sort.Strings(strs)
 	// 拼接并计算SHA1
 	sha1Hash := sha1.New()
 	io.WriteString(sha1Hash, strings.Join(strs, ""))
-	computedSignature := hex.EncodeToString(sha1Hash.Sum(nil))
+	return hex.EncodeToString(sha1Hash.Sum(nil))
+}
+
+func VerifyWechatSignature(token, signature, timestamp, nonce string) bool {
+	if token == "" || signature == "" || timestamp == "" || nonce == "" {
+		return false
+	}
+	computedSignature := GenerateSignature(token, timestamp, nonce)
 	// 比较签名
-	return computedSignature == signature
+	return subtle.ConstantTimeCompare([]byte(computedSignature), []byte(signature)) == 1
 }
\ No newline at end of file
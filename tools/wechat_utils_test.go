@@ -0,0 +1,199 @@
+package tools
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+// 微信公众平台消息加解密官方示例中的测试数据
+const (
+	sampleToken          = "spamtest"
+	sampleEncodingAESKey = "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFG"
+	sampleAppID          = "wx2c2769f8efd9abc2"
+	sampleTimestamp      = "1409735669"
+	sampleNonce          = "1320562132"
+	sampleMsgSignature   = "5d197aaffba7e9b25a30732f161a50dee96bd5fa"
+	sampleEncrypt        = "hyzAe4OzmOMbd6TvGdIOO6uBmdJoD0Fk53REIHvxYtJlE2B655HuD0m8KUePWB3+LrPXo87wzQ1QLvbeUgmBM4x6F8PGHQHFVAFmOD2LdJF9FrXpbUAh0B5GIItb52sn896wVsMSHGuPE328HnRGBcrS7C41IzDWyWNlZkyyXwon8T332jisa+h6tEDYsVticbSnyU8dKOIbgU6ux5VTjg3yt+WGzjlpKn6NPhRjpA912xMezR4kw6KWwMrCVKSVCZciVGCgavjIQ6X8tCOp3yZbGpy0VxpAe+77TszTfRd5RJSVO/HTnifJpXgCSUdUue1v6h0EIBYYI1BD1DlD+C0CR8e6OewpusjZ4uBl9FyJvnhvQl+q5rv1ixrcpCumEPo5MJSgM9ehVsNPfUM669WuMyVWQLCzpu9GhglF2PE="
+)
+
+func TestDecryptOfficialSample(t *testing.T) {
+	msg, err := DecryptWechatMessage(sampleEncrypt, sampleEncodingAESKey, sampleAppID)
+	if err != nil {
+		t.Fatalf("解密官方示例失败: %v", err)
+	}
+	if msg.ToUserName != "gh_10f6c3c3ac5a" || msg.FromUserName != "oyORnuP8q7ou2gfYjqLzSIWZf0rs" {
+		t.Errorf("用户字段不符: %+v", msg)
+	}
+	if msg.MsgType != "text" || msg.Content != "abcdteT" || msg.MsgID != "6054768590064713728" {
+		t.Errorf("消息字段不符: %+v", msg)
+	}
+}
+
+func TestDecryptRejectsWrongAppID(t *testing.T) {
+	if _, err := DecryptWechatMessage(sampleEncrypt, sampleEncodingAESKey, "wx0000000000000000"); err == nil {
+		t.Fatal("AppID不符时应返回错误")
+	}
+}
+
+func TestMsgSignatureOfficialSample(t *testing.T) {
+	got := GenerateMsgSignature(sampleToken, sampleTimestamp, sampleNonce, sampleEncrypt)
+	if got != sampleMsgSignature {
+		t.Fatalf("msg_signature = %s, 期望 %s", got, sampleMsgSignature)
+	}
+}
+
+func TestVerifyMsgSignature(t *testing.T) {
+	if !VerifyMsgSignature(sampleToken, sampleTimestamp, sampleNonce, sampleEncrypt, sampleMsgSignature) {
+		t.Fatal("官方示例msg_signature校验失败")
+	}
+	if VerifyMsgSignature(sampleToken, "1409735670", sampleNonce, sampleEncrypt, sampleMsgSignature) {
+		t.Error("时间戳被篡改时校验应失败")
+	}
+	if VerifyMsgSignature(sampleToken, sampleTimestamp, sampleNonce, sampleEncrypt[1:], sampleMsgSignature) {
+		t.Error("密文被篡改时校验应失败")
+	}
+	if VerifyMsgSignature(sampleToken, sampleTimestamp, sampleNonce, sampleEncrypt, "") {
+		t.Error("缺少msg_signature时校验应失败")
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	reply := []byte(`<xml><ToUserName><![CDATA[oia2TjjewbmiOUlr6X-1crbLOvLw]]></ToUserName><FromUserName><![CDATA[gh_7f083739789a]]></FromUserName><CreateTime>1407743423</CreateTime><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[您的验证码是: 123456]]></Content></xml>`)
+
+	encrypt, err := EncryptWechatMessage(reply, sampleEncodingAESKey, sampleAppID)
+	if err != nil {
+		t.Fatalf("加密失败: %v", err)
+	}
+	plain, err := DecryptWechatPayload(encrypt, sampleEncodingAESKey, sampleAppID)
+	if err != nil {
+		t.Fatalf("解密失败: %v", err)
+	}
+	if !bytes.Equal(plain, reply) {
+		t.Fatalf("往返结果不一致:\n%s\n%s", plain, reply)
+	}
+
+	// 随机前缀不同，两次加密结果应不同
+	again, _ := EncryptWechatMessage(reply, sampleEncodingAESKey, sampleAppID)
+	if again == encrypt {
+		t.Error("两次加密结果相同，随机前缀未生效")
+	}
+}
+
+func TestEncryptMatchesOfficialSample(t *testing.T) {
+	// 用官方示例解出的随机前缀和明文重新加密，应得到完全相同的密文
+	plain, err := DecryptWechatPayload(sampleEncrypt, sampleEncodingAESKey, sampleAppID)
+	if err != nil {
+		t.Fatalf("解密官方示例失败: %v", err)
+	}
+	random := officialRandomPrefix(t)
+	encrypt, err := encryptWithRandom(random, plain, sampleEncodingAESKey, sampleAppID)
+	if err != nil {
+		t.Fatalf("加密失败: %v", err)
+	}
+	if encrypt != sampleEncrypt {
+		t.Fatalf("密文与官方示例不一致:\n%s\n%s", encrypt, sampleEncrypt)
+	}
+}
+
+func TestBuildEncryptedReply(t *testing.T) {
+	reply := []byte(`<xml><Content><![CDATA[hello]]></Content></xml>`)
+	data, err := BuildEncryptedReply(reply, sampleToken, sampleEncodingAESKey, sampleAppID, sampleTimestamp, sampleNonce)
+	if err != nil {
+		t.Fatalf("生成加密回复失败: %v", err)
+	}
+
+	var parsed struct {
+		Encrypt      string `xml:"Encrypt"`
+		MsgSignature string `xml:"MsgSignature"`
+		TimeStamp    string `xml:"TimeStamp"`
+		Nonce        string `xml:"Nonce"`
+	}
+	if err := xml.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("解析加密回复失败: %v", err)
+	}
+	if parsed.TimeStamp != sampleTimestamp || parsed.Nonce != sampleNonce {
+		t.Errorf("时间戳或随机串不符: %+v", parsed)
+	}
+	if parsed.MsgSignature != GenerateMsgSignature(sampleToken, sampleTimestamp, sampleNonce, parsed.Encrypt) {
+		t.Error("msg_signature与密文不匹配")
+	}
+	plain, err := DecryptWechatPayload(parsed.Encrypt, sampleEncodingAESKey, sampleAppID)
+	if err != nil || !bytes.Equal(plain, reply) {
+		t.Fatalf("回复解密结果不符: %s, %v", plain, err)
+	}
+}
+
+func TestPKCS7PadUnpad(t *testing.T) {
+	for n := 0; n <= 64; n++ {
+		data := bytes.Repeat([]byte{'a'}, n)
+		padded := PKCS7Pad(append([]byte(nil), data...), wechatBlockSize)
+		if len(padded)%wechatBlockSize != 0 || len(padded) == n {
+			t.Fatalf("长度%d填充结果错误: %d", n, len(padded))
+		}
+		out, err := PKCS7Unpad(padded)
+		if err != nil || !bytes.Equal(out, data) {
+			t.Fatalf("长度%d去除填充失败: %v", n, err)
+		}
+	}
+}
+
+// officialRandomPrefix 从官方示例密文中解出16字节随机前缀
+func officialRandomPrefix(t *testing.T) []byte {
+	t.Helper()
+	aesKey, err := decodeAESKey(sampleEncodingAESKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(sampleEncrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := make([]byte, aes.BlockSize)
+	cipher.NewCBCDecrypter(block, aesKey[:16]).CryptBlocks(first, ciphertext[:aes.BlockSize])
+	return first
+}
+
+func TestBuildTextReply(t *testing.T) {
+	content := "记录内容]]><MsgType>news</MsgType>"
+	data, err := BuildTextReply("openid", "gh_7f083739789a", 1407743423, content)
+	if err != nil {
+		t.Fatalf("生成回复失败: %v", err)
+	}
+	var parsed struct {
+		ToUserName   string `xml:"ToUserName"`
+		FromUserName string `xml:"FromUserName"`
+		CreateTime   int64  `xml:"CreateTime"`
+		MsgType      string `xml:"MsgType"`
+		Content      string `xml:"Content"`
+	}
+	if err := xml.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("解析回复失败: %v\n%s", err, data)
+	}
+	if parsed.Content != content || parsed.MsgType != "text" || parsed.ToUserName != "openid" || parsed.CreateTime != 1407743423 {
+		t.Fatalf("回复内容被篡改: %+v", parsed)
+	}
+}
+
+func TestDecryptWechatMessageErrorOmitsPlaintext(t *testing.T) {
+	secret := "<xml><Content>13800000000"
+	encrypt, err := EncryptWechatMessage([]byte(secret), sampleEncodingAESKey, sampleAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = DecryptWechatMessage(encrypt, sampleEncodingAESKey, sampleAppID)
+	if err == nil {
+		t.Fatal("无效的XML应报错")
+	}
+	if strings.Contains(err.Error(), "13800000000") {
+		t.Errorf("错误信息不应包含消息原文: %v", err)
+	}
+}